@@ -0,0 +1,293 @@
+package anchor
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+
+	"github.com/mr-tron/base58"
+)
+
+// Limit on collection lengths to avoid allocating huge amounts of memory on malformed data
+const maxCollectionLen = 1 << 20
+
+// borshDecoder decodes borsh encoded data into JSON friendly values
+// Integers larger than 32 bits are represented as strings to avoid losing precision in JSON clients
+type borshDecoder struct {
+	idl  *Idl
+	data []byte
+	pos  int
+}
+
+func newBorshDecoder(idl *Idl, data []byte) *borshDecoder {
+	return &borshDecoder{idl: idl, data: data}
+}
+
+func (d *borshDecoder) read(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.data) {
+		return nil, fmt.Errorf("Unexpected end of data, wanted %v bytes at offset %v, have %v", n, d.pos, len(d.data))
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *borshDecoder) readLen() (int, error) {
+	b, err := d.read(4)
+	if err != nil {
+		return 0, err
+	}
+	l := binary.LittleEndian.Uint32(b)
+	if l > maxCollectionLen {
+		return 0, fmt.Errorf("Collection length %v exceeds limit", l)
+	}
+	return int(l), nil
+}
+
+func (d *borshDecoder) decodeFields(fields IdlFields) (map[string]interface{}, error) {
+	out := map[string]interface{}{}
+	for _, f := range fields.Named {
+		v, err := d.decodeType(f.Type)
+		if err != nil {
+			return nil, fmt.Errorf("Field %v: %w", f.Name, err)
+		}
+		out[f.Name] = v
+	}
+	for i, t := range fields.Tuple {
+		v, err := d.decodeType(t)
+		if err != nil {
+			return nil, fmt.Errorf("Field %v: %w", i, err)
+		}
+		out[strconv.Itoa(i)] = v
+	}
+	return out, nil
+}
+
+func (d *borshDecoder) decodeType(t IdlType) (interface{}, error) {
+	switch {
+	case t.Primitive != "":
+		return d.decodePrimitive(t.Primitive)
+	case t.Option != nil:
+		flag, err := d.read(1)
+		if err != nil {
+			return nil, err
+		}
+		if flag[0] == 0 {
+			return nil, nil
+		}
+		return d.decodeType(*t.Option)
+	case t.COption != nil:
+		flag, err := d.read(4)
+		if err != nil {
+			return nil, err
+		}
+		if binary.LittleEndian.Uint32(flag) == 0 {
+			return nil, nil
+		}
+		return d.decodeType(*t.COption)
+	case t.Vec != nil:
+		l, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeSeq(*t.Vec, l)
+	case t.Array != nil:
+		return d.decodeSeq(*t.Array, t.ArrayLen)
+	case t.Defined != "":
+		return d.decodeDefined(t.Defined)
+	}
+	return nil, fmt.Errorf("Empty IDL type")
+}
+
+func (d *borshDecoder) decodeSeq(t IdlType, l int) (interface{}, error) {
+	// Byte arrays are represented as base64 rather than an array of numbers
+	if t.Primitive == "u8" {
+		b, err := d.read(l)
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.EncodeToString(b), nil
+	}
+
+	out := make([]interface{}, 0, l)
+	for i := 0; i < l; i++ {
+		v, err := d.decodeType(t)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func (d *borshDecoder) decodeDefined(name string) (interface{}, error) {
+	def, err := d.idl.typeDef(name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch def.Type.Kind {
+	case "struct":
+		return d.decodeFields(def.Type.Fields)
+	case "enum":
+		idx, err := d.read(1)
+		if err != nil {
+			return nil, err
+		}
+		if int(idx[0]) >= len(def.Type.Variants) {
+			return nil, fmt.Errorf("Invalid variant %v for enum %v", idx[0], name)
+		}
+		variant := def.Type.Variants[idx[0]]
+		fields, err := d.decodeFields(variant.Fields)
+		if err != nil {
+			return nil, err
+		}
+		// Matches the representation used by the anchor TS client
+		return map[string]interface{}{variant.Name: fields}, nil
+	case "type":
+		if def.Type.Alias == nil {
+			return nil, fmt.Errorf("Missing alias for type %v", name)
+		}
+		return d.decodeType(*def.Type.Alias)
+	}
+
+	return nil, fmt.Errorf("Unsupported type kind %v for %v", def.Type.Kind, name)
+}
+
+func (d *borshDecoder) decodePrimitive(p string) (interface{}, error) {
+	switch p {
+	case "bool":
+		b, err := d.read(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0] != 0, nil
+	case "u8":
+		b, err := d.read(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0], nil
+	case "i8":
+		b, err := d.read(1)
+		if err != nil {
+			return nil, err
+		}
+		return int8(b[0]), nil
+	case "u16":
+		b, err := d.read(2)
+		if err != nil {
+			return nil, err
+		}
+		return binary.LittleEndian.Uint16(b), nil
+	case "i16":
+		b, err := d.read(2)
+		if err != nil {
+			return nil, err
+		}
+		return int16(binary.LittleEndian.Uint16(b)), nil
+	case "u32":
+		b, err := d.read(4)
+		if err != nil {
+			return nil, err
+		}
+		return binary.LittleEndian.Uint32(b), nil
+	case "i32":
+		b, err := d.read(4)
+		if err != nil {
+			return nil, err
+		}
+		return int32(binary.LittleEndian.Uint32(b)), nil
+	case "f32":
+		b, err := d.read(4)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float32frombits(binary.LittleEndian.Uint32(b)), nil
+	case "f64":
+		b, err := d.read(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case "u64":
+		b, err := d.read(8)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.FormatUint(binary.LittleEndian.Uint64(b), 10), nil
+	case "i64":
+		b, err := d.read(8)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.FormatInt(int64(binary.LittleEndian.Uint64(b)), 10), nil
+	case "u128", "u256":
+		b, err := d.read(primitiveSize(p))
+		if err != nil {
+			return nil, err
+		}
+		return leUint(b).String(), nil
+	case "i128", "i256":
+		b, err := d.read(primitiveSize(p))
+		if err != nil {
+			return nil, err
+		}
+		v := leUint(b)
+		// Two's complement
+		if b[len(b)-1]&0x80 != 0 {
+			v.Sub(v, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+		}
+		return v.String(), nil
+	case "string":
+		l, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.read(l)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case "bytes":
+		l, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.read(l)
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.EncodeToString(b), nil
+	case "pubkey", "publicKey":
+		b, err := d.read(32)
+		if err != nil {
+			return nil, err
+		}
+		return base58.Encode(b), nil
+	}
+
+	return nil, fmt.Errorf("Unsupported primitive type: %v", p)
+}
+
+func primitiveSize(p string) int {
+	switch p {
+	case "u128", "i128":
+		return 16
+	case "u256", "i256":
+		return 32
+	}
+	return 0
+}
+
+// leUint parses little endian bytes into a big.Int
+func leUint(b []byte) *big.Int {
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+	return new(big.Int).SetBytes(be)
+}
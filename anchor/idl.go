@@ -0,0 +1,232 @@
+package anchor
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+/**
+ * Types for Anchor IDLs. Both the current (>= 0.30) spec, where discriminators are included in the IDL,
+ * and the legacy spec, where discriminators are derived from the names, are supported.
+ * Spec can be found here https://github.com/coral-xyz/anchor/blob/master/idl/spec/src/lib.rs
+ * */
+
+type Idl struct {
+	Address      string           `json:"address"`
+	Metadata     *IdlMetadata     `json:"metadata"`
+	Instructions []IdlInstruction `json:"instructions"`
+	Events       []IdlEvent       `json:"events"`
+	Types        []IdlTypeDef     `json:"types"`
+
+	// Legacy IDLs define the name and version at the top level and the address in the metadata
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type IdlMetadata struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Spec    string `json:"spec"`
+	Address string `json:"address"`
+}
+
+type IdlInstruction struct {
+	Name          string     `json:"name"`
+	Discriminator []byte     `json:"discriminator"`
+	Args          []IdlField `json:"args"`
+}
+
+type IdlEvent struct {
+	Name          string `json:"name"`
+	Discriminator []byte `json:"discriminator"`
+
+	// Legacy IDLs define the event fields inline rather than in types
+	Fields []IdlField `json:"fields"`
+}
+
+type IdlField struct {
+	Name string  `json:"name"`
+	Type IdlType `json:"type"`
+}
+
+type IdlTypeDef struct {
+	Name string          `json:"name"`
+	Type IdlTypeDefShape `json:"type"`
+}
+
+type IdlTypeDefShape struct {
+	Kind     string           `json:"kind"` // 'struct' | 'enum' | 'type'
+	Fields   IdlFields        `json:"fields"`
+	Variants []IdlEnumVariant `json:"variants"`
+	Alias    *IdlType         `json:"alias"`
+}
+
+type IdlEnumVariant struct {
+	Name   string    `json:"name"`
+	Fields IdlFields `json:"fields"`
+}
+
+// IdlFields are either named fields or a tuple of types
+type IdlFields struct {
+	Named []IdlField
+	Tuple []IdlType
+}
+
+func (f *IdlFields) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	for _, r := range raw {
+		var field IdlField
+		if err := json.Unmarshal(r, &field); err == nil && field.Name != "" {
+			f.Named = append(f.Named, field)
+			continue
+		}
+
+		var t IdlType
+		if err := json.Unmarshal(r, &t); err != nil {
+			return err
+		}
+		f.Tuple = append(f.Tuple, t)
+	}
+
+	return nil
+}
+
+// IdlType is a recursive type definition, only one of the fields is set
+type IdlType struct {
+	Primitive string
+	Option    *IdlType
+	COption   *IdlType
+	Vec       *IdlType
+	Array     *IdlType
+	ArrayLen  int
+	Defined   string
+}
+
+func (t *IdlType) UnmarshalJSON(data []byte) error {
+	var primitive string
+	if err := json.Unmarshal(data, &primitive); err == nil {
+		t.Primitive = primitive
+		return nil
+	}
+
+	type rawDefined struct {
+		Name string `json:"name"`
+	}
+
+	var raw struct {
+		Option  *IdlType          `json:"option"`
+		COption *IdlType          `json:"coption"`
+		Vec     *IdlType          `json:"vec"`
+		Array   []json.RawMessage `json:"array"`
+		Defined json.RawMessage   `json:"defined"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	t.Option = raw.Option
+	t.COption = raw.COption
+	t.Vec = raw.Vec
+
+	if len(raw.Array) == 2 {
+		t.Array = &IdlType{}
+		if err := json.Unmarshal(raw.Array[0], t.Array); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(raw.Array[1], &t.ArrayLen); err != nil {
+			// Generic array lengths are not supported
+			return fmt.Errorf("Unsupported array length: %s", raw.Array[1])
+		}
+	}
+
+	if len(raw.Defined) > 0 {
+		// Legacy IDLs use a string, newer IDLs use an object
+		if err := json.Unmarshal(raw.Defined, &t.Defined); err != nil {
+			var d rawDefined
+			if err := json.Unmarshal(raw.Defined, &d); err != nil {
+				return err
+			}
+			t.Defined = d.Name
+		}
+	}
+
+	if t.Option == nil && t.COption == nil && t.Vec == nil && t.Array == nil && t.Defined == "" {
+		return fmt.Errorf("Unsupported IDL type: %s", data)
+	}
+
+	return nil
+}
+
+// ProgramId returns the program address the IDL is for, this can be empty for legacy IDLs
+func (idl *Idl) ProgramId() string {
+	if idl.Address != "" {
+		return idl.Address
+	}
+	if idl.Metadata != nil {
+		return idl.Metadata.Address
+	}
+	return ""
+}
+
+func (idl *Idl) typeDef(name string) (*IdlTypeDef, error) {
+	for i := range idl.Types {
+		if idl.Types[i].Name == name {
+			return &idl.Types[i], nil
+		}
+	}
+	return nil, fmt.Errorf("Unable to find type definition: %v", name)
+}
+
+func (idl *Idl) instructionDiscriminator(inst IdlInstruction) []byte {
+	if len(inst.Discriminator) > 0 {
+		return inst.Discriminator
+	}
+	return sighash("global", toSnakeCase(inst.Name))
+}
+
+func (idl *Idl) eventDiscriminator(event IdlEvent) []byte {
+	if len(event.Discriminator) > 0 {
+		return event.Discriminator
+	}
+	return sighash("event", event.Name)
+}
+
+// eventFields returns the fields of an event, either inline (legacy) or from the type definitions
+func (idl *Idl) eventFields(event IdlEvent) (IdlFields, error) {
+	if len(event.Fields) > 0 {
+		return IdlFields{Named: event.Fields}, nil
+	}
+	def, err := idl.typeDef(event.Name)
+	if err != nil {
+		return IdlFields{}, err
+	}
+	return def.Type.Fields, nil
+}
+
+func sighash(namespace, name string) []byte {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s:%s", namespace, name)))
+	return hash[:8]
+}
+
+func toSnakeCase(s string) string {
+	var b strings.Builder
+	runes := []rune(s)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])) {
+				b.WriteRune('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
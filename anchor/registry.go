@@ -0,0 +1,130 @@
+package anchor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/subquery/solana-takoyaki/solana"
+)
+
+// Registry holds Anchor IDLs keyed by program id
+type Registry struct {
+	idls map[string]*Idl
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		idls: map[string]*Idl{},
+	}
+}
+
+// LoadDir loads all `.json` IDLs from a directory.
+// The program id is taken from the IDL address, falling back to the file name (e.g. `<programId>.json`)
+func LoadDir(dir string) (*Registry, error) {
+	r := NewRegistry()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		raw, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		idl := &Idl{}
+		if err := json.Unmarshal(raw, idl); err != nil {
+			return nil, fmt.Errorf("Unable to parse IDL %v: %w", entry.Name(), err)
+		}
+
+		programId := idl.ProgramId()
+		if programId == "" {
+			programId = strings.TrimSuffix(entry.Name(), ".json")
+		}
+
+		r.Register(programId, idl)
+		slog.Info("Loaded IDL", "programId", programId, "file", entry.Name())
+	}
+
+	return r, nil
+}
+
+func (r *Registry) Register(programId string, idl *Idl) {
+	r.idls[programId] = idl
+}
+
+func (r *Registry) Len() int {
+	return len(r.idls)
+}
+
+// DecodeInstruction decodes instruction data for a program.
+// A nil result with no error is returned if the program or instruction is unknown
+func (r *Registry) DecodeInstruction(programId string, data []byte) (*solana.DecodedInstruction, error) {
+	idl := r.idls[programId]
+	if idl == nil {
+		return nil, nil
+	}
+
+	for _, inst := range idl.Instructions {
+		disc := idl.instructionDiscriminator(inst)
+		if !bytes.HasPrefix(data, disc) {
+			continue
+		}
+
+		args, err := newBorshDecoder(idl, data[len(disc):]).decodeFields(IdlFields{Named: inst.Args})
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decode instruction %v: %w", inst.Name, err)
+		}
+
+		return &solana.DecodedInstruction{
+			Name: inst.Name,
+			Args: args,
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// DecodeEvent decodes event data for a program, as found in `Program data:` logs.
+// A nil result with no error is returned if the program or event is unknown
+func (r *Registry) DecodeEvent(programId string, data []byte) (*solana.DecodedEvent, error) {
+	idl := r.idls[programId]
+	if idl == nil {
+		return nil, nil
+	}
+
+	for _, event := range idl.Events {
+		disc := idl.eventDiscriminator(event)
+		if !bytes.HasPrefix(data, disc) {
+			continue
+		}
+
+		fields, err := idl.eventFields(event)
+		if err != nil {
+			return nil, err
+		}
+
+		out, err := newBorshDecoder(idl, data[len(disc):]).decodeFields(fields)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decode event %v: %w", event.Name, err)
+		}
+
+		return &solana.DecodedEvent{
+			Name: event.Name,
+			Data: out,
+		}, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,175 @@
+package anchor
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mr-tron/base58"
+)
+
+const PROGRAM_ID = "675kPX9MHTjS2zt1qfr1NYHuzeLXfQM9H24wFSUt1Mp8"
+
+const TEST_IDL = `{
+	"address": "675kPX9MHTjS2zt1qfr1NYHuzeLXfQM9H24wFSUt1Mp8",
+	"metadata": { "name": "test", "version": "0.1.0", "spec": "0.1.0" },
+	"instructions": [
+		{
+			"name": "swap",
+			"discriminator": [1, 2, 3, 4, 5, 6, 7, 8],
+			"args": [
+				{ "name": "amount", "type": "u64" },
+				{ "name": "minOut", "type": { "option": "u64" } },
+				{ "name": "side", "type": { "defined": { "name": "Side" } } },
+				{ "name": "memo", "type": "string" }
+			]
+		}
+	],
+	"events": [
+		{ "name": "SwapEvent", "discriminator": [8, 7, 6, 5, 4, 3, 2, 1] }
+	],
+	"types": [
+		{ "name": "Side", "type": { "kind": "enum", "variants": [{ "name": "bid" }, { "name": "ask" }] } },
+		{
+			"name": "SwapEvent",
+			"type": {
+				"kind": "struct",
+				"fields": [
+					{ "name": "user", "type": "pubkey" },
+					{ "name": "amounts", "type": { "vec": "u32" } },
+					{ "name": "delta", "type": "i128" }
+				]
+			}
+		}
+	]
+}`
+
+const LEGACY_IDL = `{
+	"version": "0.1.0",
+	"name": "legacy",
+	"instructions": [
+		{ "name": "initializeV2", "accounts": [], "args": [{ "name": "flag", "type": "bool" }] }
+	],
+	"events": [
+		{ "name": "Initialized", "fields": [{ "name": "authority", "type": "publicKey", "index": false }] }
+	]
+}`
+
+func loadIdl(t *testing.T, raw string) *Idl {
+	idl := &Idl{}
+	if err := json.Unmarshal([]byte(raw), idl); err != nil {
+		t.Fatalf("Failed to parse IDL: %v", err)
+	}
+	return idl
+}
+
+func TestDecodeInstruction(t *testing.T) {
+	r := NewRegistry()
+	r.Register(PROGRAM_ID, loadIdl(t, TEST_IDL))
+
+	data := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	data = binary.LittleEndian.AppendUint64(data, 1_000_000)
+	data = append(data, 0)                           // minOut: None
+	data = append(data, 1)                           // side: ask
+	data = binary.LittleEndian.AppendUint32(data, 2) // memo length
+	data = append(data, []byte("hi")...)
+
+	decoded, err := r.DecodeInstruction(PROGRAM_ID, data)
+	if err != nil {
+		t.Fatalf("Failed to decode instruction: %v", err)
+	}
+	if decoded == nil {
+		t.Fatal("Expected decoded instruction")
+	}
+
+	if decoded.Name != "swap" {
+		t.Errorf("Expected name swap, got %v", decoded.Name)
+	}
+
+	raw, _ := json.Marshal(decoded.Args)
+	expected := `{"amount":"1000000","memo":"hi","minOut":null,"side":{"ask":{}}}`
+	if string(raw) != expected {
+		t.Errorf("Args mismatch\nexpected: %v\ngot: %v", expected, string(raw))
+	}
+}
+
+func TestDecodeEvent(t *testing.T) {
+	r := NewRegistry()
+	r.Register(PROGRAM_ID, loadIdl(t, TEST_IDL))
+
+	user, _ := base58.Decode(PROGRAM_ID)
+
+	data := []byte{8, 7, 6, 5, 4, 3, 2, 1}
+	data = append(data, user...)
+	data = binary.LittleEndian.AppendUint32(data, 2)
+	data = binary.LittleEndian.AppendUint32(data, 1)
+	data = binary.LittleEndian.AppendUint32(data, 2)
+	// -2 as i128
+	data = append(data, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+
+	decoded, err := r.DecodeEvent(PROGRAM_ID, data)
+	if err != nil {
+		t.Fatalf("Failed to decode event: %v", err)
+	}
+	if decoded == nil {
+		t.Fatal("Expected decoded event")
+	}
+
+	raw, _ := json.Marshal(decoded)
+	expected := `{"name":"SwapEvent","data":{"amounts":[1,2],"delta":"-2","user":"675kPX9MHTjS2zt1qfr1NYHuzeLXfQM9H24wFSUt1Mp8"}}`
+	if string(raw) != expected {
+		t.Errorf("Event mismatch\nexpected: %v\ngot: %v", expected, string(raw))
+	}
+}
+
+func TestDecodeUnknown(t *testing.T) {
+	r := NewRegistry()
+	r.Register(PROGRAM_ID, loadIdl(t, TEST_IDL))
+
+	decoded, err := r.DecodeInstruction("11111111111111111111111111111111", []byte{1, 2, 3, 4, 5, 6, 7, 8})
+	if err != nil || decoded != nil {
+		t.Errorf("Expected unknown program to be ignored, got %v, %v", decoded, err)
+	}
+
+	decoded, err = r.DecodeInstruction(PROGRAM_ID, []byte{0, 0, 0, 0, 0, 0, 0, 0})
+	if err != nil || decoded != nil {
+		t.Errorf("Expected unknown discriminator to be ignored, got %v, %v", decoded, err)
+	}
+
+	_, err = r.DecodeInstruction(PROGRAM_ID, []byte{1, 2, 3, 4, 5, 6, 7, 8, 1})
+	if err == nil {
+		t.Error("Expected error for truncated data")
+	}
+}
+
+func TestLoadDirLegacy(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, PROGRAM_ID+".json"), []byte(LEGACY_IDL), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to load IDLs: %v", err)
+	}
+
+	data := append(sighash("global", "initialize_v2"), 1)
+	decoded, err := r.DecodeInstruction(PROGRAM_ID, data)
+	if err != nil || decoded == nil {
+		t.Fatalf("Failed to decode legacy instruction: %v, %v", decoded, err)
+	}
+	if decoded.Args["flag"] != true {
+		t.Errorf("Expected flag to be true, got %v", decoded.Args["flag"])
+	}
+
+	authority, _ := base58.Decode(PROGRAM_ID)
+	event, err := r.DecodeEvent(PROGRAM_ID, append(sighash("event", "Initialized"), authority...))
+	if err != nil || event == nil {
+		t.Fatalf("Failed to decode legacy event: %v, %v", event, err)
+	}
+	if event.Data["authority"] != PROGRAM_ID {
+		t.Errorf("Expected authority %v, got %v", PROGRAM_ID, event.Data["authority"])
+	}
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"log/slog"
+	"strings"
+
+	"github.com/mr-tron/base58"
+	"github.com/subquery/solana-takoyaki/anchor"
+	"github.com/subquery/solana-takoyaki/programs"
+	"github.com/subquery/solana-takoyaki/solana"
+)
+
+type decodeOptions struct {
+	idls               *anchor.Registry
+	decodeInstructions bool
+	decodeLogs         bool
+	parseInstructions  bool
+}
+
+// decodeBlock attaches decoded instructions and events for programs with a registered IDL
+// and parsed instructions for builtin programs.
+// Unknown programs and data that fails to decode are left untouched
+func decodeBlock(ctx context.Context, block *solana.Block, idls *anchor.Registry, fieldSelector *FieldSelector) {
+	if fieldSelector == nil {
+		return
+	}
+
+	opts := decodeOptions{idls: idls}
+	if fieldSelector.Instructions != nil {
+		opts.decodeInstructions = idls != nil && fieldSelector.Instructions.Decoded
+		opts.parseInstructions = fieldSelector.Instructions.Parsed
+	}
+	if fieldSelector.Logs != nil {
+		opts.decodeLogs = idls != nil && fieldSelector.Logs.Decoded
+	}
+	if !opts.decodeInstructions && !opts.decodeLogs && !opts.parseInstructions {
+		return
+	}
+
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+
+		if (opts.decodeInstructions || opts.parseInstructions) && tx.Transaction != nil {
+			for j := range tx.Transaction.Message.Instructions {
+				decodeInstruction(ctx, tx, &tx.Transaction.Message.Instructions[j], opts)
+			}
+			if tx.Meta != nil {
+				for _, inner := range tx.Meta.InnerInstructions {
+					for j := range inner.Instructions {
+						decodeInstruction(ctx, tx, &inner.Instructions[j], opts)
+					}
+				}
+			}
+		}
+
+		if opts.decodeLogs && tx.Meta != nil {
+			for j := range tx.Meta.Logs {
+				decodeLog(ctx, &tx.Meta.Logs[j], idls)
+			}
+		}
+	}
+}
+
+func decodeInstruction(ctx context.Context, tx *solana.Transaction, inst *solana.CompiledInstruction, opts decodeOptions) {
+	programId, err := tx.AccountKey(inst.ProgramIDIndex)
+	if err != nil {
+		slog.DebugContext(ctx, "Unable to resolve program id", "error", err)
+		return
+	}
+
+	data, err := base58.Decode(inst.Data)
+	if err != nil {
+		slog.DebugContext(ctx, "Unable to decode instruction data", "programId", programId, "error", err)
+		return
+	}
+
+	if opts.parseInstructions && programs.IsSupported(programId) {
+		accounts := make([]string, 0, len(inst.Accounts))
+		for _, idx := range inst.Accounts {
+			account, err := tx.AccountKey(idx)
+			if err != nil {
+				slog.DebugContext(ctx, "Unable to resolve instruction account", "programId", programId, "error", err)
+				return
+			}
+			accounts = append(accounts, account)
+		}
+
+		program, parsed, err := programs.Parse(programId, accounts, data)
+		if err != nil {
+			slog.DebugContext(ctx, "Unable to parse instruction", "programId", programId, "error", err)
+		} else {
+			inst.Program = program
+			inst.Parsed = parsed
+		}
+	}
+
+	if opts.decodeInstructions {
+		decoded, err := opts.idls.DecodeInstruction(programId, data)
+		if err != nil {
+			slog.DebugContext(ctx, "Unable to decode instruction", "programId", programId, "error", err)
+			return
+		}
+		inst.Decoded = decoded
+	}
+}
+
+func decodeLog(ctx context.Context, log *solana.Log, idls *anchor.Registry) {
+	if log.Kind != "data" {
+		return
+	}
+
+	// A data log can contain multiple space separated base64 values, events are emitted as a single value
+	values := strings.Fields(log.Message)
+	if len(values) == 0 {
+		return
+	}
+
+	data, err := base64.StdEncoding.DecodeString(values[0])
+	if err != nil {
+		slog.DebugContext(ctx, "Unable to decode log data", "programId", log.ProgramId, "error", err)
+		return
+	}
+
+	decoded, err := idls.DecodeEvent(log.ProgramId, data)
+	if err != nil {
+		slog.DebugContext(ctx, "Unable to decode event", "programId", log.ProgramId, "error", err)
+		return
+	}
+	log.Decoded = decoded
+}
@@ -0,0 +1,241 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	solanaGo "github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/mr-tron/base58"
+	"github.com/subquery/solana-takoyaki/anchor"
+	"github.com/subquery/solana-takoyaki/solana"
+)
+
+const (
+	ENCODING_JSON        = "json"
+	ENCODING_JSON_PARSED = "jsonParsed"
+	ENCODING_BASE64      = "base64"
+)
+
+var SUPPORTED_ENCODINGS = []string{ENCODING_JSON, ENCODING_JSON_PARSED, ENCODING_BASE64}
+
+// encodeBlock encodes the transactions of a block, json is the default encoding and leaves the block untouched
+func encodeBlock(ctx context.Context, block *solana.Block, encoding string, idls *anchor.Registry) error {
+	switch encoding {
+	case "", ENCODING_JSON:
+		return nil
+	case ENCODING_JSON_PARSED:
+		// Builtin programs are parsed for both outer and inner instructions
+		decodeBlock(ctx, block, idls, &FieldSelector{Instructions: &InstructionsSelector{Parsed: true}})
+		for i := range block.Transactions {
+			encoded, err := encodeParsedTransaction(&block.Transactions[i])
+			if err != nil {
+				return err
+			}
+			block.Transactions[i].Encoded = encoded
+		}
+	case ENCODING_BASE64:
+		for i := range block.Transactions {
+			encoded, err := encodeBinaryTransaction(&block.Transactions[i])
+			if err != nil {
+				return err
+			}
+			block.Transactions[i].Encoded = encoded
+		}
+	default:
+		return fmt.Errorf("Unsupported encoding: %v. supported encodings: %v", encoding, SUPPORTED_ENCODINGS)
+	}
+
+	return nil
+}
+
+func encodeParsedTransaction(tx *solana.Transaction) (*solana.ParsedTransaction, error) {
+	if tx.Transaction == nil {
+		return nil, fmt.Errorf("Transaction %v has no message", tx.Slot)
+	}
+	msg := tx.Transaction.Message
+
+	accountKeys := []solana.ParsedAccountKey{}
+	numStatic := len(msg.AccountKeys)
+	numSigners := int(msg.Header.NumRequiredSignatures)
+	for i, key := range msg.AccountKeys {
+		writable := false
+		if i < numSigners {
+			writable = i < numSigners-int(msg.Header.NumReadonlySignedAccounts)
+		} else {
+			writable = i < numStatic-int(msg.Header.NumReadonlyUnsignedAccounts)
+		}
+
+		accountKeys = append(accountKeys, solana.ParsedAccountKey{
+			Pubkey:   key,
+			Signer:   i < numSigners,
+			Writable: writable,
+			Source:   "transaction",
+		})
+	}
+	if tx.Meta != nil {
+		for _, key := range tx.Meta.LoadedAddresses.Writable {
+			accountKeys = append(accountKeys, solana.ParsedAccountKey{Pubkey: key, Writable: true, Source: "lookupTable"})
+		}
+		for _, key := range tx.Meta.LoadedAddresses.Readonly {
+			accountKeys = append(accountKeys, solana.ParsedAccountKey{Pubkey: key, Source: "lookupTable"})
+		}
+	}
+
+	instructions := make([]solana.ParsedInstruction, 0, len(msg.Instructions))
+	for _, inst := range msg.Instructions {
+		parsed, err := parseInstruction(tx, inst)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, parsed)
+	}
+
+	// Inner instructions are parsed the same way as the outer instructions
+	if tx.Meta != nil {
+		innerInstructions := make([]solana.ParsedInnerInstruction, 0, len(tx.Meta.InnerInstructions))
+		for _, inner := range tx.Meta.InnerInstructions {
+			parsedInner := solana.ParsedInnerInstruction{
+				Index:        inner.Index,
+				Instructions: make([]solana.ParsedInstruction, 0, len(inner.Instructions)),
+			}
+			for _, inst := range inner.Instructions {
+				parsed, err := parseInstruction(tx, inst)
+				if err != nil {
+					return nil, err
+				}
+				parsedInner.Instructions = append(parsedInner.Instructions, parsed)
+			}
+			innerInstructions = append(innerInstructions, parsedInner)
+		}
+		tx.Meta.EncodedInnerInstructions = innerInstructions
+	}
+
+	return &solana.ParsedTransaction{
+		Signatures: tx.Transaction.Signatures,
+		Message: solana.ParsedMessage{
+			AccountKeys:         accountKeys,
+			RecentBlockhash:     msg.RecentBlockhash,
+			Instructions:        instructions,
+			AddressTableLookups: msg.AddressTableLookups,
+		},
+	}, nil
+}
+
+// parseInstruction resolves the accounts of an instruction, builtin programs are kept parsed
+func parseInstruction(tx *solana.Transaction, inst solana.CompiledInstruction) (solana.ParsedInstruction, error) {
+	programId, err := tx.AccountKey(inst.ProgramIDIndex)
+	if err != nil {
+		return solana.ParsedInstruction{}, err
+	}
+
+	parsed := solana.ParsedInstruction{
+		ProgramId:   programId,
+		Decoded:     inst.Decoded,
+		StackHeight: inst.StackHeight,
+	}
+
+	if inst.Parsed != nil {
+		parsed.Program = inst.Program
+		parsed.Parsed = inst.Parsed
+		return parsed, nil
+	}
+
+	parsed.Data = inst.Data
+	parsed.Accounts = make([]string, 0, len(inst.Accounts))
+	for _, idx := range inst.Accounts {
+		account, err := tx.AccountKey(idx)
+		if err != nil {
+			return solana.ParsedInstruction{}, err
+		}
+		parsed.Accounts = append(parsed.Accounts, account)
+	}
+	return parsed, nil
+}
+
+// encodeBinaryTransaction reconstructs the wire format of a transaction.
+// The result is only identical to the original transaction if all instructions were included in the response.
+// Without the recent blockhash the bytes wouldn't be the real transaction so the encoding is unsupported
+func encodeBinaryTransaction(tx *solana.Transaction) (solana.BinaryTransaction, error) {
+	if tx.Transaction == nil {
+		return solana.BinaryTransaction{}, fmt.Errorf("Transaction %v has no message", tx.Slot)
+	}
+	msg := tx.Transaction.Message
+	if msg.RecentBlockhash == "" {
+		supported := []string{ENCODING_JSON, ENCODING_JSON_PARSED}
+		return solana.BinaryTransaction{}, newRequestError(UNSUPPORTED_ENCODING_ERROR_CODE, encodingErrorData{ENCODING_BASE64, supported}, "Unsupported encoding: %v, the recent blockhash is not available. supported encodings: %v", ENCODING_BASE64, supported)
+	}
+
+	out := solanaGo.Transaction{
+		Signatures: make([]solanaGo.Signature, 0, len(tx.Transaction.Signatures)),
+		Message: solanaGo.Message{
+			AccountKeys: make(solanaGo.PublicKeySlice, 0, len(msg.AccountKeys)),
+			Header: solanaGo.MessageHeader{
+				NumRequiredSignatures:       msg.Header.NumRequiredSignatures,
+				NumReadonlySignedAccounts:   msg.Header.NumReadonlySignedAccounts,
+				NumReadonlyUnsignedAccounts: msg.Header.NumReadonlyUnsignedAccounts,
+			},
+			Instructions: make([]solanaGo.CompiledInstruction, 0, len(msg.Instructions)),
+		},
+	}
+
+	if tx.Version == rpc.LegacyTransactionVersion {
+		out.Message.SetVersion(solanaGo.MessageVersionLegacy)
+	} else {
+		out.Message.SetVersion(solanaGo.MessageVersionV0)
+	}
+
+	for _, sig := range tx.Transaction.Signatures {
+		s, err := solanaGo.SignatureFromBase58(sig)
+		if err != nil {
+			return solana.BinaryTransaction{}, err
+		}
+		out.Signatures = append(out.Signatures, s)
+	}
+
+	for _, key := range msg.AccountKeys {
+		k, err := solanaGo.PublicKeyFromBase58(key)
+		if err != nil {
+			return solana.BinaryTransaction{}, err
+		}
+		out.Message.AccountKeys = append(out.Message.AccountKeys, k)
+	}
+
+	hash, err := solanaGo.HashFromBase58(msg.RecentBlockhash)
+	if err != nil {
+		return solana.BinaryTransaction{}, err
+	}
+	out.Message.RecentBlockhash = hash
+
+	for _, inst := range msg.Instructions {
+		data, err := base58.Decode(inst.Data)
+		if err != nil {
+			return solana.BinaryTransaction{}, err
+		}
+		out.Message.Instructions = append(out.Message.Instructions, solanaGo.CompiledInstruction{
+			ProgramIDIndex: inst.ProgramIDIndex,
+			Accounts:       inst.Accounts,
+			Data:           data,
+		})
+	}
+
+	for _, lookup := range msg.AddressTableLookups {
+		key, err := solanaGo.PublicKeyFromBase58(lookup.AccountKey)
+		if err != nil {
+			return solana.BinaryTransaction{}, err
+		}
+		out.Message.AddressTableLookups = append(out.Message.AddressTableLookups, solanaGo.MessageAddressTableLookup{
+			AccountKey:      key,
+			WritableIndexes: lookup.WritableIndexes,
+			ReadonlyIndexes: lookup.ReadonlyIndexes,
+		})
+	}
+
+	raw, err := out.MarshalBinary()
+	if err != nil {
+		return solana.BinaryTransaction{}, err
+	}
+
+	return solana.BinaryTransaction{base64.StdEncoding.EncodeToString(raw), ENCODING_BASE64}, nil
+}
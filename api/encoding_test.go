@@ -0,0 +1,223 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	solanaGo "github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/mr-tron/base58"
+	"github.com/subquery/solana-takoyaki/solana"
+)
+
+const (
+	FEE_PAYER   = "5eykt4UsFv8P8NJdTREpY1vzqKqZKvdpKuc147dw2N9d"
+	RECIPIENT   = "EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v"
+	LOOKUP_KEY  = "JUP6LkbZbjS1jKKwapdHNy74zcZ3tLUZoi5QNyVTaV4"
+	TABLE_KEY   = "675kPX9MHTjS2zt1qfr1NYHuzeLXfQM9H24wFSUt1Mp8"
+	SYSTEM_ID   = "11111111111111111111111111111111"
+	BLOCK_HASH  = "5FqMrgbiEmh22E9puyX4RV2EnARvwYBHgsTKYQ9a52Er"
+	TEST_SIGNER = "2SB7fVzaUyU8knSbEa42c2BKQJXm1QPamtiFXKapX6YwLbbAm7dzezaGKyXfb6uGRH8a1xTeovSmWnbgav7jeKCS"
+)
+
+// A system transfer of 5000 lamports
+func testTransaction() solana.Transaction {
+	return solana.Transaction{
+		Slot:    327_347_682,
+		Version: 0,
+		Transaction: &solana.JSONTransaction{
+			Signatures: []string{TEST_SIGNER},
+			Message: solana.Message{
+				AccountKeys: []string{FEE_PAYER, RECIPIENT, SYSTEM_ID},
+				Header: solana.MessageHeader{
+					NumRequiredSignatures:       1,
+					NumReadonlyUnsignedAccounts: 1,
+				},
+				RecentBlockhash: BLOCK_HASH,
+				Instructions: []solana.CompiledInstruction{{
+					ProgramIDIndex: 2,
+					Accounts:       []uint16{0, 1},
+					Data:           base58.Encode([]byte{2, 0, 0, 0, 0x88, 0x13, 0, 0, 0, 0, 0, 0}),
+				}},
+				AddressTableLookups: []solana.MessageAddressTableLookup{{
+					AccountKey:      TABLE_KEY,
+					WritableIndexes: []uint8{},
+					ReadonlyIndexes: []uint8{3},
+				}},
+			},
+		},
+		Meta: &solana.TransactionMeta{
+			LoadedAddresses: solana.LoadedAddresses{
+				Readonly: []string{LOOKUP_KEY},
+				Writable: []string{},
+			},
+		},
+	}
+}
+
+func TestEncodeBinaryTransaction(t *testing.T) {
+	tx := testTransaction()
+
+	encoded, err := encodeBinaryTransaction(&tx)
+	if err != nil {
+		t.Fatalf("Failed to encode transaction: %v", err)
+	}
+
+	if encoded[1] != ENCODING_BASE64 {
+		t.Errorf("Expected encoding %v, got %v", ENCODING_BASE64, encoded[1])
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(encoded[0])
+	if err != nil {
+		t.Fatalf("Failed to decode base64: %v", err)
+	}
+
+	decoded, err := solanaGo.TransactionFromBytes(raw)
+	if err != nil {
+		t.Fatalf("Failed to decode transaction: %v", err)
+	}
+
+	if decoded.Signatures[0].String() != TEST_SIGNER {
+		t.Errorf("Expected signature %v, got %v", TEST_SIGNER, decoded.Signatures[0])
+	}
+	if !decoded.Message.IsVersioned() {
+		t.Error("Expected v0 message")
+	}
+	if decoded.Message.RecentBlockhash.String() != BLOCK_HASH {
+		t.Errorf("Expected blockhash %v, got %v", BLOCK_HASH, decoded.Message.RecentBlockhash)
+	}
+	if len(decoded.Message.AddressTableLookups) != 1 || decoded.Message.AddressTableLookups[0].AccountKey.String() != TABLE_KEY {
+		t.Errorf("Unexpected address table lookups: %v", decoded.Message.AddressTableLookups)
+	}
+	if len(decoded.Message.Instructions) != 1 || decoded.Message.Instructions[0].ProgramIDIndex != 2 {
+		t.Errorf("Unexpected instructions: %v", decoded.Message.Instructions)
+	}
+}
+
+func TestEncodeBinaryLegacyTransaction(t *testing.T) {
+	tx := testTransaction()
+	tx.Version = rpc.LegacyTransactionVersion
+	tx.Transaction.Message.AddressTableLookups = []solana.MessageAddressTableLookup{}
+
+	encoded, err := encodeBinaryTransaction(&tx)
+	if err != nil {
+		t.Fatalf("Failed to encode transaction: %v", err)
+	}
+
+	raw, _ := base64.StdEncoding.DecodeString(encoded[0])
+	decoded, err := solanaGo.TransactionFromBytes(raw)
+	if err != nil {
+		t.Fatalf("Failed to decode transaction: %v", err)
+	}
+	if decoded.Message.IsVersioned() {
+		t.Error("Expected legacy message")
+	}
+}
+
+// Without the recent blockhash the wire format can't be reconstructed
+func TestEncodeBinaryTransactionNoBlockhash(t *testing.T) {
+	tx := testTransaction()
+	tx.Transaction.Message.RecentBlockhash = ""
+
+	_, err := encodeBinaryTransaction(&tx)
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) || reqErr.Code != UNSUPPORTED_ENCODING_ERROR_CODE {
+		t.Fatalf("Expected unsupported encoding error, got %v", err)
+	}
+}
+
+func TestEncodeParsedTransaction(t *testing.T) {
+	block := &solana.Block{Transactions: []solana.Transaction{testTransaction()}}
+
+	if err := encodeBlock(context.Background(), block, ENCODING_JSON_PARSED, nil); err != nil {
+		t.Fatalf("Failed to encode block: %v", err)
+	}
+
+	raw, err := json.Marshal(block.Transactions[0])
+	if err != nil {
+		t.Fatalf("Failed to marshal transaction: %v", err)
+	}
+
+	var out struct {
+		Transaction solana.ParsedTransaction `json:"transaction"`
+	}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		t.Fatalf("Failed to unmarshal transaction: %v", err)
+	}
+
+	expectedKeys := []solana.ParsedAccountKey{
+		{Pubkey: FEE_PAYER, Signer: true, Writable: true, Source: "transaction"},
+		{Pubkey: RECIPIENT, Signer: false, Writable: true, Source: "transaction"},
+		{Pubkey: SYSTEM_ID, Signer: false, Writable: false, Source: "transaction"},
+		{Pubkey: LOOKUP_KEY, Signer: false, Writable: false, Source: "lookupTable"},
+	}
+	compareAsJson(t, expectedKeys, out.Transaction.Message.AccountKeys, "Account keys")
+
+	inst := out.Transaction.Message.Instructions[0]
+	if inst.Program != "system" || inst.Parsed == nil || inst.Parsed.Type != "transfer" {
+		t.Errorf("Expected parsed system transfer, got %+v", inst)
+	}
+
+	if !strings.Contains(string(raw), `"version":0`) {
+		t.Errorf("Expected version to be serialized: %s", raw)
+	}
+}
+
+func TestEncodeParsedInnerInstructions(t *testing.T) {
+	tx := testTransaction()
+	stackHeight := uint16(2)
+	tx.Meta.InnerInstructions = []solana.InnerInstruction{{
+		Index: 0,
+		Instructions: []solana.CompiledInstruction{
+			{ProgramIDIndex: 2, Accounts: []uint16{0, 1}, Data: tx.Transaction.Message.Instructions[0].Data, StackHeight: &stackHeight},
+			// An unsupported program without accounts
+			{ProgramIDIndex: 3, Accounts: []uint16{}, Data: "", StackHeight: &stackHeight},
+		},
+	}}
+	block := &solana.Block{Transactions: []solana.Transaction{tx}}
+
+	if err := encodeBlock(context.Background(), block, ENCODING_JSON_PARSED, nil); err != nil {
+		t.Fatalf("Failed to encode block: %v", err)
+	}
+
+	raw, err := json.Marshal(block.Transactions[0])
+	if err != nil {
+		t.Fatalf("Failed to marshal transaction: %v", err)
+	}
+
+	var out struct {
+		Meta struct {
+			InnerInstructions []struct {
+				Index        uint64                   `json:"index"`
+				Instructions []map[string]interface{} `json:"instructions"`
+			} `json:"innerInstructions"`
+		} `json:"meta"`
+	}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		t.Fatalf("Failed to unmarshal transaction: %v", err)
+	}
+
+	if len(out.Meta.InnerInstructions) != 1 || len(out.Meta.InnerInstructions[0].Instructions) != 2 {
+		t.Fatalf("Expected 2 inner instructions, got %s", raw)
+	}
+	parsed, unparsed := out.Meta.InnerInstructions[0].Instructions[0], out.Meta.InnerInstructions[0].Instructions[1]
+
+	if parsed["program"] != "system" || parsed["parsed"] == nil {
+		t.Errorf("Expected parsed system transfer, got %v", parsed)
+	}
+	if _, ok := parsed["accounts"]; ok {
+		t.Errorf("Parsed instructions shouldn't include accounts, got %v", parsed)
+	}
+
+	compareAsJson(t, map[string]interface{}{"programId": LOOKUP_KEY, "accounts": []string{}, "data": "", "stackHeight": 2}, unparsed, "Partially decoded inner instruction")
+}
+
+func TestEncodeUnsupported(t *testing.T) {
+	if err := encodeBlock(context.Background(), &solana.Block{}, "base58", nil); err == nil {
+		t.Error("Expected error for unsupported encoding")
+	}
+}
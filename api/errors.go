@@ -0,0 +1,132 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/subquery/solana-takoyaki/backend/sqd"
+)
+
+// JSON-RPC error codes, clients can use these to react to specific errors
+const (
+	// The request params are malformed or missing
+	INVALID_PARAMS_ERROR_CODE = -32602
+	// The requested range is not on the same fork as the parent hash
+	FORK_DETECTED_ERROR_CODE = -32010
+	// The requested range is invalid or outside of the available blocks
+	INVALID_RANGE_ERROR_CODE = -32011
+	// The limit is out of bounds
+	INVALID_LIMIT_ERROR_CODE = -32012
+	// A filter has too many entries or values
+	FILTER_TOO_LARGE_ERROR_CODE = -32013
+	// An account or program id is not a valid base58 public key
+	INVALID_PUBKEY_ERROR_CODE = -32014
+	// A discriminator is not valid hex or has an unsupported length
+	INVALID_DISCRIMINATOR_ERROR_CODE = -32015
+	// The requested encoding is not supported
+	UNSUPPORTED_ENCODING_ERROR_CODE = -32016
+	// The API key or address has used its quota for the current window
+	QUOTA_EXCEEDED_ERROR_CODE = -32017
+)
+
+// RequestError is a JSON-RPC error with a specific code and data describing the problem
+type RequestError struct {
+	Code    int
+	Message string
+	Data    interface{}
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
+}
+
+func (e *RequestError) ErrorCode() int {
+	return e.Code
+}
+
+func (e *RequestError) ErrorData() interface{} {
+	return e.Data
+}
+
+func newRequestError(code int, data interface{}, format string, args ...interface{}) *RequestError {
+	return &RequestError{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+		Data:    data,
+	}
+}
+
+type ForkErrorData struct {
+	// The slot of the first block that doesn't connect to the expected parent, 0 if reported by the portal
+	Slot uint64 `json:"slot,omitempty"`
+	// The expected parent block hash
+	ExpectedParentHash string `json:"expectedParentHash"`
+	// The parent block hash of the block at Slot
+	ParentHash string `json:"parentHash,omitempty"`
+	// Blocks on the canonical chain before the requested range, these can be used to find a common ancestor to roll back to
+	PreviousBlocks []sqd.BlockRef `json:"previousBlocks,omitempty"`
+}
+
+// ForkError indicates the returned blocks don't build on the blocks previously served, indexers should roll back and retry
+type ForkError struct {
+	Data ForkErrorData
+}
+
+func (e *ForkError) Error() string {
+	if e.Data.Slot != 0 {
+		return fmt.Sprintf("Fork detected at slot %d, expected parent hash %s got %s", e.Data.Slot, e.Data.ExpectedParentHash, e.Data.ParentHash)
+	}
+	return fmt.Sprintf("Fork detected, parent hash %s is not on the canonical chain", e.Data.ExpectedParentHash)
+}
+
+func (e *ForkError) ErrorCode() int {
+	return FORK_DETECTED_ERROR_CODE
+}
+
+func (e *ForkError) ErrorData() interface{} {
+	return e.Data
+}
+
+// toForkError converts a portal fork conflict to a ForkError, other errors are returned unchanged
+func toForkError(err error, parentHash *string) error {
+	var sqdForkErr *sqd.ForkError
+	if !errors.As(err, &sqdForkErr) {
+		return err
+	}
+
+	data := ForkErrorData{PreviousBlocks: sqdForkErr.PreviousBlocks}
+	if parentHash != nil {
+		data.ExpectedParentHash = *parentHash
+	}
+	return &ForkError{Data: data}
+}
+
+// checkContinuity ensures each block builds on the expected parent.
+// The first block must build on parentHash if provided, later blocks are only checked against the previous block when their slots are adjacent
+// as filtered results can skip blocks.
+func checkContinuity(parentHash *string, blocks []sqd.SolanaBlockResponse) error {
+	for i, block := range blocks {
+		var expected string
+		if i == 0 {
+			if parentHash == nil {
+				continue
+			}
+			expected = *parentHash
+		} else {
+			prev := blocks[i-1].Header
+			if block.Header.ParentSlot != prev.Slot {
+				continue
+			}
+			expected = prev.Hash
+		}
+
+		if block.Header.ParentHash != expected {
+			return &ForkError{Data: ForkErrorData{
+				Slot:               block.Header.Slot,
+				ExpectedParentHash: expected,
+				ParentHash:         block.Header.ParentHash,
+			}}
+		}
+	}
+	return nil
+}
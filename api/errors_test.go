@@ -0,0 +1,110 @@
+package api
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/subquery/solana-takoyaki/backend/sqd"
+)
+
+func testBlockResponse(slot uint64, parentSlot uint64, hash string, parentHash string) sqd.SolanaBlockResponse {
+	block := sqd.SolanaBlockResponse{}
+	block.Header.Slot = slot
+	block.Header.ParentSlot = parentSlot
+	block.Header.Hash = hash
+	block.Header.ParentHash = parentHash
+	return block
+}
+
+func TestCheckContinuity(t *testing.T) {
+	parentHash := "A"
+	otherHash := "X"
+
+	tests := []struct {
+		name       string
+		parentHash *string
+		blocks     []sqd.SolanaBlockResponse
+		forkSlot   uint64 // 0 means no fork
+	}{
+		{
+			name:       "continuous",
+			parentHash: &parentHash,
+			blocks: []sqd.SolanaBlockResponse{
+				testBlockResponse(2, 1, "B", "A"),
+				testBlockResponse(3, 2, "C", "B"),
+			},
+		},
+		{
+			name: "no parent hash",
+			blocks: []sqd.SolanaBlockResponse{
+				testBlockResponse(2, 1, "B", "A"),
+			},
+		},
+		{
+			name:       "filtered blocks are skipped",
+			parentHash: &parentHash,
+			blocks: []sqd.SolanaBlockResponse{
+				testBlockResponse(2, 1, "B", "A"),
+				testBlockResponse(10, 9, "J", "I"),
+			},
+		},
+		{
+			name:       "parent hash mismatch",
+			parentHash: &otherHash,
+			blocks: []sqd.SolanaBlockResponse{
+				testBlockResponse(2, 1, "B", "A"),
+			},
+			forkSlot: 2,
+		},
+		{
+			name:       "adjacent mismatch",
+			parentHash: &parentHash,
+			blocks: []sqd.SolanaBlockResponse{
+				testBlockResponse(2, 1, "B", "A"),
+				testBlockResponse(3, 2, "C", "B2"),
+			},
+			forkSlot: 3,
+		},
+	}
+
+	for _, test := range tests {
+		err := checkContinuity(test.parentHash, test.blocks)
+		if test.forkSlot == 0 {
+			if err != nil {
+				t.Errorf("%v: unexpected error %v", test.name, err)
+			}
+			continue
+		}
+
+		var forkErr *ForkError
+		if !errors.As(err, &forkErr) {
+			t.Fatalf("%v: expected fork error, got %v", test.name, err)
+		}
+		if forkErr.Data.Slot != test.forkSlot {
+			t.Errorf("%v: expected fork at slot %v, got %v", test.name, test.forkSlot, forkErr.Data.Slot)
+		}
+		if forkErr.ErrorCode() != FORK_DETECTED_ERROR_CODE {
+			t.Errorf("%v: unexpected error code %v", test.name, forkErr.ErrorCode())
+		}
+	}
+}
+
+func TestToForkError(t *testing.T) {
+	parentHash := "A"
+	err := toForkError(&sqd.ForkError{PreviousBlocks: []sqd.BlockRef{{Number: 1, Hash: "A2"}}}, &parentHash)
+
+	var forkErr *ForkError
+	if !errors.As(err, &forkErr) {
+		t.Fatalf("Expected fork error, got %v", err)
+	}
+
+	compareAsJson(t, ForkErrorData{
+		ExpectedParentHash: "A",
+		PreviousBlocks:     []sqd.BlockRef{{Number: 1, Hash: "A2"}},
+	}, forkErr.ErrorData(), "Fork error data")
+
+	other := errors.New("other")
+	if toForkError(other, &parentHash) != other {
+		t.Errorf("Expected other errors to be returned unchanged")
+	}
+}
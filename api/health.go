@@ -0,0 +1,168 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/subquery/solana-takoyaki/backend/sqd"
+	"github.com/subquery/solana-takoyaki/cache"
+	"github.com/subquery/solana-takoyaki/version"
+)
+
+// The default maximum head lag before the service isn't ready
+const DEFAULT_MAX_HEAD_LAG = time.Minute
+
+// How long health checks wait for the portal
+const HEALTH_CHECK_TIMEOUT = 5 * time.Second
+
+// Health serves the liveness, readiness and status endpoints used by probes and operators
+type Health struct {
+	service    *SubqlApiService
+	maxHeadLag time.Duration
+}
+
+func NewHealth(service *SubqlApiService, maxHeadLag time.Duration) *Health {
+	return &Health{service, maxHeadLag}
+}
+
+// ReadyChecks is the result of each readiness check, "ok" or the reason the check failed
+type ReadyChecks struct {
+	Portal   string `json:"portal"`
+	Metadata string `json:"metadata"`
+	HeadLag  string `json:"headLag"`
+}
+
+type ReadyStatus struct {
+	Ready  bool        `json:"ready"`
+	Checks ReadyChecks `json:"checks"`
+}
+
+type MetadataStatus struct {
+	Dataset     string   `json:"dataset"`
+	Aliases     []string `json:"aliases"`
+	RealTime    bool     `json:"realTime"`
+	StartBlock  uint     `json:"startBlock"`
+	ChainId     string   `json:"chainId"`
+	GenesisHash string   `json:"genesisHash"`
+}
+
+// ServiceStatus is served on /status, values that couldn't be fetched are null and the errors are included
+type ServiceStatus struct {
+	Version       version.Info    `json:"version"`
+	Metadata      *MetadataStatus `json:"metadata"`
+	Head          *sqd.BlockRef   `json:"head"`
+	FinalizedHead *sqd.BlockRef   `json:"finalizedHead"`
+	// Seconds between the latest block timestamp and now
+	HeadLag *int64       `json:"headLag"`
+	Cache   *cache.Stats `json:"cache,omitempty"`
+	Errors  []string     `json:"errors,omitempty"`
+}
+
+// Live responds if the process is up, it doesn't depend on the portal
+func (h *Health) Live(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Ready responds with 200 if the portal is reachable, metadata is loaded and the head lag is under the threshold, otherwise 503
+func (h *Health) Ready(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), HEALTH_CHECK_TIMEOUT)
+	defer cancel()
+
+	status := h.ready(ctx)
+	code := http.StatusOK
+	if !status.Ready {
+		slog.WarnContext(ctx, "Not ready", "checks", status.Checks)
+		code = http.StatusServiceUnavailable
+	}
+	writeJson(w, code, status)
+}
+
+func (h *Health) ready(ctx context.Context) ReadyStatus {
+	passing := ReadyChecks{Portal: "ok", Metadata: "ok", HeadLag: "ok"}
+	checks := passing
+
+	if _, err := h.service.sqdClient.Metadata(ctx); err != nil {
+		checks.Metadata = err.Error()
+	}
+
+	head, err := h.service.sqdClient.Head(ctx)
+	if err != nil {
+		checks.Portal = err.Error()
+		checks.HeadLag = "Head is not available"
+	} else {
+		lag, err := h.service.headLag(ctx, head)
+		if err != nil {
+			checks.HeadLag = err.Error()
+		} else if time.Duration(lag)*time.Second > h.maxHeadLag {
+			checks.HeadLag = fmt.Sprintf("Head lag %ds exceeds %v", lag, h.maxHeadLag)
+		}
+	}
+
+	return ReadyStatus{
+		Ready:  checks == passing,
+		Checks: checks,
+	}
+}
+
+// Status responds with the version, cached metadata and upstream heads. It always responds with 200 so it can be used to debug an unhealthy service
+func (h *Health) Status(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), HEALTH_CHECK_TIMEOUT)
+	defer cancel()
+
+	writeJson(w, http.StatusOK, h.status(ctx))
+}
+
+func (h *Health) status(ctx context.Context) ServiceStatus {
+	backend := h.service.sqdClient
+	status := ServiceStatus{
+		Version: version.Get(),
+	}
+
+	if meta, err := backend.Metadata(ctx); err != nil {
+		status.Errors = append(status.Errors, fmt.Sprintf("Metadata: %v", err))
+	} else {
+		status.Metadata = &MetadataStatus{
+			Dataset:     meta.Dataset,
+			Aliases:     meta.Aliases,
+			RealTime:    meta.RealTime,
+			StartBlock:  meta.StartBlock,
+			ChainId:     meta.ChainId,
+			GenesisHash: meta.GenesisHash,
+		}
+	}
+
+	if head, err := backend.Head(ctx); err != nil {
+		status.Errors = append(status.Errors, fmt.Sprintf("Head: %v", err))
+	} else {
+		status.Head = head
+		if lag, err := h.service.headLag(ctx, head); err != nil {
+			status.Errors = append(status.Errors, fmt.Sprintf("Head lag: %v", err))
+		} else {
+			status.HeadLag = &lag
+		}
+	}
+
+	if finalizedHead, err := backend.FinalizedHead(ctx); err != nil {
+		status.Errors = append(status.Errors, fmt.Sprintf("Finalized head: %v", err))
+	} else {
+		status.FinalizedHead = finalizedHead
+	}
+
+	if cached, ok := backend.(interface{ CacheStats() *cache.Stats }); ok {
+		status.Cache = cached.CacheStats()
+	}
+
+	return status
+}
+
+func writeJson(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Warn("Failed to write response", "error", err)
+	}
+}
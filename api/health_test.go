@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/subquery/solana-takoyaki/meta"
+)
+
+func TestHealth(t *testing.T) {
+	tests := []struct {
+		name         string
+		blockTime    int64
+		portalDown   bool
+		expectedCode int
+	}{
+		{"ready", time.Now().Unix() - 2, false, http.StatusOK},
+		{"head lag", time.Now().Unix() - 600, false, http.StatusServiceUnavailable},
+		{"portal down", time.Now().Unix(), true, http.StatusServiceUnavailable},
+	}
+
+	for _, test := range tests {
+		portal := newStatusPortal(test.blockTime, &atomic.Int32{})
+		service, err := NewSubqlApiService(meta.MAINNET, portal.URL, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.portalDown {
+			portal.Close()
+		}
+		health := NewHealth(service, DEFAULT_MAX_HEAD_LAG)
+
+		// Liveness doesn't depend on the portal
+		rec := httptest.NewRecorder()
+		health.Live(rec, httptest.NewRequest("GET", "/health", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%v: expected live to respond 200, got %v", test.name, rec.Code)
+		}
+
+		rec = httptest.NewRecorder()
+		health.Ready(rec, httptest.NewRequest("GET", "/ready", nil))
+		if rec.Code != test.expectedCode {
+			t.Errorf("%v: expected ready to respond %v, got %v %v", test.name, test.expectedCode, rec.Code, rec.Body.String())
+		}
+
+		var ready ReadyStatus
+		if err := json.NewDecoder(rec.Body).Decode(&ready); err != nil {
+			t.Fatal(err)
+		}
+		if ready.Ready != (test.expectedCode == http.StatusOK) {
+			t.Errorf("%v: unexpected ready status %+v", test.name, ready)
+		}
+
+		// Status always responds, with errors when the portal is unavailable
+		rec = httptest.NewRecorder()
+		health.Status(rec, httptest.NewRequest("GET", "/status", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%v: expected status to respond 200, got %v", test.name, rec.Code)
+		}
+
+		var status ServiceStatus
+		if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+		if status.Version.GoVersion == "" {
+			t.Errorf("%v: expected version info", test.name)
+		}
+		if test.portalDown {
+			if status.Head != nil || len(status.Errors) == 0 {
+				t.Errorf("%v: expected errors and no head, got %+v", test.name, status)
+			}
+		} else {
+			if status.Head == nil || status.Head.Number != 327347700 || status.FinalizedHead.Number != 327347668 {
+				t.Errorf("%v: unexpected heads %+v %+v", test.name, status.Head, status.FinalizedHead)
+			}
+			if status.Metadata == nil || !strings.HasPrefix(status.Metadata.Dataset, "solana") {
+				t.Errorf("%v: unexpected metadata %+v", test.name, status.Metadata)
+			}
+		}
+
+		portal.Close()
+	}
+}
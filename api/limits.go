@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/subquery/solana-takoyaki/server"
+)
+
+// Limits bound the work done for a single request
+type Limits struct {
+	// Maximum blocks per response, larger limits are truncated
+	MaxBlocks int
+	// Maximum JSON size of the blocks in a response, the response is truncated once reached. 0 is unlimited
+	MaxResponseBytes int
+	// Maximum slots searched per request, wider ranges are truncated. 0 is unlimited
+	MaxRangeWidth uint
+	// Maximum filters of each type per request
+	MaxFilters int
+}
+
+var DEFAULT_LIMITS = Limits{
+	MaxBlocks:        1000,
+	MaxResponseBytes: 64 * 1024 * 1024,
+	MaxRangeWidth:    10_000_000,
+	MaxFilters:       100,
+}
+
+type quotaErrorData struct {
+	Limit   uint64 `json:"limit"`
+	Used    uint64 `json:"used"`
+	ResetAt int64  `json:"resetAt"` // Unix timestamp when the quota resets
+}
+
+type quotaUsage struct {
+	used        uint64
+	windowStart time.Time
+}
+
+// Quotas limit the number of blocks served to each API key within a window.
+// Requests without an API key are tracked by their remote host, so reconnecting from another port shares the same quota
+type Quotas struct {
+	mu        sync.Mutex
+	limit     uint64            // The default limit, 0 is unlimited
+	keyLimits map[string]uint64 // Limits for specific API keys
+	window    time.Duration
+	usage     map[string]*quotaUsage
+}
+
+func NewQuotas(limit uint64, window time.Duration) *Quotas {
+	return &Quotas{
+		limit:     limit,
+		keyLimits: map[string]uint64{},
+		window:    window,
+		usage:     map[string]*quotaUsage{},
+	}
+}
+
+// SetKeyLimit overrides the default limit for an API key
+func (q *Quotas) SetKeyLimit(apiKey string, limit uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.keyLimits[apiKey] = limit
+}
+
+type remoteAddrContextKey struct{}
+
+// withRemoteAddr sets the caller's address for requests outside the JSON-RPC server such as /stream, the RPC peer info is used otherwise
+func withRemoteAddr(ctx context.Context, addr string) context.Context {
+	return context.WithValue(ctx, remoteAddrContextKey{}, addr)
+}
+
+// quotaKey returns the key usage is tracked by and the limit for the caller, it must be called with the lock held
+func (q *Quotas) quotaKey(ctx context.Context) (string, uint64) {
+	if key := server.APIKeyFromContext(ctx); key != "" {
+		if limit, ok := q.keyLimits[key]; ok {
+			return "key:" + key, limit
+		}
+		return "key:" + key, q.limit
+	}
+
+	addr, ok := ctx.Value(remoteAddrContextKey{}).(string)
+	if !ok {
+		addr = rpc.PeerInfoFromContext(ctx).RemoteAddr
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return "addr:" + addr, q.limit
+}
+
+// The number of tracked callers before expired usage is removed
+const QUOTA_PRUNE_SIZE = 10_000
+
+// current returns the usage for the current window, it must be called with the lock held
+func (q *Quotas) current(key string) *quotaUsage {
+	usage, ok := q.usage[key]
+	if !ok || time.Since(usage.windowStart) >= q.window {
+		if len(q.usage) >= QUOTA_PRUNE_SIZE {
+			for k, u := range q.usage {
+				if time.Since(u.windowStart) >= q.window {
+					delete(q.usage, k)
+				}
+			}
+		}
+		usage = &quotaUsage{windowStart: time.Now()}
+		q.usage[key] = usage
+	}
+	return usage
+}
+
+// Check returns an error if the caller has used their quota for the current window
+func (q *Quotas) Check(ctx context.Context) error {
+	if q == nil {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key, limit := q.quotaKey(ctx)
+	if limit == 0 {
+		return nil
+	}
+
+	usage := q.current(key)
+	if usage.used >= limit {
+		resetAt := usage.windowStart.Add(q.window)
+		return newRequestError(QUOTA_EXCEEDED_ERROR_CODE, quotaErrorData{limit, usage.used, resetAt.Unix()}, "Quota of %d blocks exceeded, resets at %v", limit, resetAt.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Record adds served blocks to the caller's usage
+func (q *Quotas) Record(ctx context.Context, blocks int) {
+	if q == nil {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key, _ := q.quotaKey(ctx)
+	q.current(key).used += uint64(blocks)
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/subquery/solana-takoyaki/backend/sqd"
+	"github.com/subquery/solana-takoyaki/server"
+)
+
+// memoryBackend returns every block in the requested range, head is the last block
+type memoryBackend struct {
+	blocks []sqd.SolanaBlockResponse
+}
+
+func newMemoryBackend(t *testing.T, from, to int) *memoryBackend {
+	blocks := []sqd.SolanaBlockResponse{}
+	for slot := from; slot <= to; slot++ {
+		raw := fmt.Sprintf(`{"header":{"number":%d,"height":%d,"hash":"hash%d","parentNumber":%d,"parentHash":"hash%d","timestamp":1700000000}}`, slot, slot, slot, slot-1, slot-1)
+		var block sqd.SolanaBlockResponse
+		if err := json.Unmarshal([]byte(raw), &block); err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, block)
+	}
+	return &memoryBackend{blocks}
+}
+
+func (m *memoryBackend) Query(ctx context.Context, solReq sqd.SolanaRequest, limit *int) ([]sqd.SolanaBlockResponse, error) {
+	res := []sqd.SolanaBlockResponse{}
+	for _, block := range m.blocks {
+		slot := uint(block.Header.Slot)
+		if slot >= solReq.FromBlock && slot <= solReq.ToBlock {
+			res = append(res, block)
+		}
+		if limit != nil && len(res) >= *limit {
+			break
+		}
+	}
+	return res, nil
+}
+
+func (m *memoryBackend) Head(ctx context.Context) (*sqd.BlockRef, error) {
+	last := m.blocks[len(m.blocks)-1].Header
+	return &sqd.BlockRef{Number: uint(last.Slot), Hash: last.Hash}, nil
+}
+
+func (m *memoryBackend) FinalizedHead(ctx context.Context) (*sqd.BlockRef, error) {
+	return m.Head(ctx)
+}
+
+func (m *memoryBackend) Metadata(ctx context.Context) (*sqd.NetworkMeta, error) {
+	return &sqd.NetworkMeta{ChainId: "solana-mainnet", StartBlock: uint(m.blocks[0].Header.Slot)}, nil
+}
+
+func TestFilterBlocksTruncation(t *testing.T) {
+	tests := []struct {
+		name          string
+		limits        Limits
+		limit         int64
+		expectedCount int
+		expectedRange string
+	}{
+		{"not truncated", DEFAULT_LIMITS, 1000, 100, "[100 199]"},
+		{"limit", DEFAULT_LIMITS, 5, 5, "[100 104]"},
+		{"max blocks", Limits{MaxBlocks: 3, MaxFilters: 1}, 1000, 3, "[100 102]"},
+		{"range width", Limits{MaxBlocks: 1000, MaxRangeWidth: 10, MaxFilters: 1}, 1000, 10, "[100 109]"},
+		{"response size", Limits{MaxBlocks: 1000, MaxResponseBytes: 1, MaxFilters: 1}, 1000, 1, "[100 100]"},
+	}
+
+	for _, test := range tests {
+		service := NewSubqlApiServiceWithBackend(newMemoryBackend(t, 100, 199), nil)
+		service.SetLimits(test.limits)
+
+		res, err := service.FilterBlocks(context.Background(), BlockRequest{
+			FromBlock: big.NewInt(100),
+			ToBlock:   big.NewInt(199),
+			Limit:     big.NewInt(test.limit),
+		})
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+
+		if len(res.Blocks) != test.expectedCount {
+			t.Errorf("%v: expected %v blocks, got %v", test.name, test.expectedCount, len(res.Blocks))
+		}
+		if fmt.Sprint(res.BlockRange) != test.expectedRange {
+			t.Errorf("%v: expected block range %v, got %v", test.name, test.expectedRange, res.BlockRange)
+		}
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	service := NewSubqlApiServiceWithBackend(newMemoryBackend(t, 100, 199), nil)
+	service.SetQuotas(NewQuotas(3, time.Hour))
+
+	req := BlockRequest{FromBlock: big.NewInt(100), Limit: big.NewInt(5)}
+
+	// The request that exceeds the quota is still served
+	if _, err := service.FilterBlocks(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := service.FilterBlocks(context.Background(), req)
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) || reqErr.ErrorCode() != QUOTA_EXCEEDED_ERROR_CODE {
+		t.Fatalf("Expected quota exceeded error, got %v", err)
+	}
+
+	data := reqErr.ErrorData().(quotaErrorData)
+	if data.Limit != 3 || data.Used != 5 {
+		t.Errorf("Unexpected quota data %+v", data)
+	}
+}
+
+func TestKeyQuotas(t *testing.T) {
+	quotas := NewQuotas(0, time.Hour)
+	quotas.SetKeyLimit("limited", 2)
+
+	var keyCtx context.Context
+	server.APIKey(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keyCtx = r.Context()
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/limited", nil))
+
+	quotas.Record(keyCtx, 2)
+	if err := quotas.Check(keyCtx); err == nil {
+		t.Errorf("Expected key quota to be exceeded")
+	}
+
+	// The default of 0 is unlimited
+	quotas.Record(context.Background(), 100)
+	if err := quotas.Check(context.Background()); err != nil {
+		t.Errorf("Expected no default quota, got %v", err)
+	}
+}
+
+func TestAddressQuotas(t *testing.T) {
+	quotas := NewQuotas(2, time.Hour)
+
+	// Reconnecting from another port doesn't reset the quota
+	quotas.Record(withRemoteAddr(context.Background(), "192.0.2.1:1000"), 2)
+	if err := quotas.Check(withRemoteAddr(context.Background(), "192.0.2.1:2000")); err == nil {
+		t.Errorf("Expected the quota to be shared between ports")
+	}
+	if err := quotas.Check(withRemoteAddr(context.Background(), "192.0.2.2:1000")); err != nil {
+		t.Errorf("Expected other hosts to have their own quota, got %v", err)
+	}
+}
+
+func TestStreamQuotas(t *testing.T) {
+	service := NewSubqlApiServiceWithBackend(newMemoryBackend(t, 100, 199), nil)
+	service.SetQuotas(NewQuotas(3, time.Hour))
+
+	stream := func(remoteAddr string) int {
+		req := httptest.NewRequest("POST", "/stream", strings.NewReader(`{"fromBlock":"0x64","limit":"0x5"}`))
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		service.Stream(rec, req)
+		return rec.Code
+	}
+
+	if code := stream("192.0.2.1:1000"); code != http.StatusOK {
+		t.Fatalf("Expected the first stream to be served, got %v", code)
+	}
+	if code := stream("192.0.2.1:2000"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the quota to be exceeded from another port, got %v", code)
+	}
+	if code := stream("192.0.2.2:1000"); code != http.StatusOK {
+		t.Errorf("Expected another host to be served, got %v", code)
+	}
+}
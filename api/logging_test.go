@@ -0,0 +1,61 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/subquery/solana-takoyaki/logging"
+	"github.com/subquery/solana-takoyaki/meta"
+)
+
+func TestRequestIdLogging(t *testing.T) {
+	var buf bytes.Buffer
+	prevLogger := slog.Default()
+	slog.SetDefault(slog.New(logging.NewHandler(&buf, slog.LevelDebug, "json")))
+	defer slog.SetDefault(prevLogger)
+
+	portal := newStatusPortal(time.Now().Unix(), &atomic.Int32{})
+	defer portal.Close()
+
+	service, err := NewSubqlApiService(meta.MAINNET, portal.URL, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		_, err = service.FilterBlocks(context.Background(), BlockRequest{FromBlock: big.NewInt(327347700), ToBlock: big.NewInt(327347700)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every record from a call has the same id, each call has a different id
+	ids := map[string][]string{}
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var record map[string]any
+		if err := dec.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		id, _ := record["requestId"].(string)
+		ids[id] = append(ids[id], record["msg"].(string))
+	}
+
+	if len(ids) != 2 {
+		t.Fatalf("Expected 2 request ids, got %v", ids)
+	}
+	for id, msgs := range ids {
+		if id == "" {
+			t.Errorf("Expected all records to have a request id, got %v", msgs)
+		}
+		if msgs[0] != "Filter Blocks" || len(msgs) < 3 {
+			t.Errorf("Expected API and portal records for request %v, got %v", id, msgs)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/subquery/solana-takoyaki/backend/sqd"
+	"github.com/subquery/solana-takoyaki/cache"
+	"github.com/subquery/solana-takoyaki/logging"
+	"github.com/subquery/solana-takoyaki/metrics"
+)
+
+type Status struct {
+	Dataset    string   `json:"dataset"`
+	Aliases    []string `json:"aliases"`
+	RealTime   bool     `json:"realTime"` // Whether the dataset includes unfinalized blocks
+	StartBlock uint     `json:"startBlock"`
+
+	LatestHeight uint `json:"latestHeight"`
+	// Nil if the portal has no finalized head yet
+	FinalizedHeight *uint `json:"finalizedHeight"`
+	// Seconds between the latest block timestamp and now
+	HeadLag int64 `json:"headLag"`
+	// Query cache hits and misses, nil if caching is disabled
+	Cache *cache.Stats `json:"cache,omitempty"`
+}
+
+// How often the head lag metric is updated
+const HEAD_LAG_INTERVAL = 15 * time.Second
+
+// Status returns the dataset status from the portal, available as `subql_status`
+func (s *SubqlApiService) Status(ctx context.Context) (_ *Status, err error) {
+	defer metrics.ObserveRPC("subql_status", time.Now(), &err)
+	ctx = logging.WithRequestId(ctx)
+
+	meta, err := s.sqdClient.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := s.sqdClient.Head(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lag, err := s.headLag(ctx, head)
+	if err != nil {
+		return nil, err
+	}
+
+	var cacheStats *cache.Stats
+	if cached, ok := s.sqdClient.(interface{ CacheStats() *cache.Stats }); ok {
+		cacheStats = cached.CacheStats()
+	}
+
+	return &Status{
+		Dataset:         meta.Dataset,
+		Aliases:         meta.Aliases,
+		RealTime:        meta.RealTime,
+		StartBlock:      meta.StartBlock,
+		LatestHeight:    head.Number,
+		FinalizedHeight: s.finalizedHeight(ctx),
+		HeadLag:         lag,
+		Cache:           cacheStats,
+	}, nil
+}
+
+// headLag returns the seconds between the head block timestamp and now
+func (s *SubqlApiService) headLag(ctx context.Context, head *sqd.BlockRef) (int64, error) {
+	blockTime, err := sqd.BlockTime(ctx, s.sqdClient, head.Number)
+	if err != nil {
+		return 0, err
+	}
+
+	lag := time.Now().Unix() - blockTime
+	metrics.SetHeadLag(lag)
+	return lag, nil
+}
+
+// TrackHeadLag updates the head lag metric every HEAD_LAG_INTERVAL until done is closed
+func (s *SubqlApiService) TrackHeadLag(done <-chan struct{}) {
+	ticker := time.NewTicker(HEAD_LAG_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), HEAD_LAG_INTERVAL)
+		head, err := s.sqdClient.Head(ctx)
+		if err == nil {
+			_, err = s.headLag(ctx, head)
+		}
+		cancel()
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to update head lag", "error", err)
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/subquery/solana-takoyaki/backend/sqd"
+	"github.com/subquery/solana-takoyaki/backend/sqd/sqdtest"
+	"github.com/subquery/solana-takoyaki/cache"
+	"github.com/subquery/solana-takoyaki/meta"
+)
+
+// newStatusPortal serves a fake portal where every block has blockTime, streamCalls counts stream requests
+func newStatusPortal(blockTime int64, streamCalls *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metadata":
+			fmt.Fprint(w, `{"dataset":"solana-mainnet","aliases":["solana-beta"],"real_time":true,"start_block":317617480}`)
+		case "/head":
+			fmt.Fprint(w, `{"number":327347700,"hash":"B"}`)
+		case "/finalized-head":
+			fmt.Fprint(w, `{"number":327347668,"hash":"A"}`)
+		case "/stream":
+			streamCalls.Add(1)
+			fmt.Fprintf(w, `{"header":{"number":327347700,"height":305000000,"hash":"B","timestamp":%d}}`+"\n", blockTime)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestStatus(t *testing.T) {
+	blockTime := time.Now().Unix() - 2
+
+	portal := newStatusPortal(blockTime, &atomic.Int32{})
+	defer portal.Close()
+
+	service, err := NewSubqlApiService(meta.MAINNET, portal.URL, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := service.Status(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+
+	if status.HeadLag < 2 || status.HeadLag > 10 {
+		t.Errorf("Unexpected head lag %v", status.HeadLag)
+	}
+	status.HeadLag = 0
+
+	finalizedHeight := uint(327347668)
+	compareAsJson(t, Status{
+		Dataset:         "solana-mainnet",
+		Aliases:         []string{"solana-beta"},
+		RealTime:        true,
+		StartBlock:      317617480,
+		LatestHeight:    327347700,
+		FinalizedHeight: &finalizedHeight,
+	}, status, "Status")
+}
+
+func TestStatusNoFinalizedHead(t *testing.T) {
+	portal := sqdtest.NewPortal(t)
+	portal.SetFinalizedHead(nil)
+
+	service, err := NewSubqlApiService(meta.MAINNET, portal.URL, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := service.Status(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+	if status.LatestHeight != sqdtest.LAST_SLOT || status.FinalizedHeight != nil {
+		t.Errorf("Unexpected heights %v, %v", status.LatestHeight, status.FinalizedHeight)
+	}
+}
+
+func TestQueryCache(t *testing.T) {
+	streamCalls := &atomic.Int32{}
+	portal := newStatusPortal(time.Now().Unix(), streamCalls)
+	defer portal.Close()
+
+	service, err := NewSubqlApiService(meta.MAINNET, portal.URL, nil, cache.New(cache.NewLRU(1<<20)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Finalized blocks are only fetched once
+	for i := 0; i < 2; i++ {
+		if _, err := sqd.BlockTime(context.Background(), service.sqdClient, 327347600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if streamCalls.Load() != 1 {
+		t.Errorf("Expected 1 stream call for finalized blocks, got %v", streamCalls.Load())
+	}
+
+	// Unfinalized blocks are never cached
+	for i := 0; i < 2; i++ {
+		if _, err := sqd.BlockTime(context.Background(), service.sqdClient, 327347700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if streamCalls.Load() != 3 {
+		t.Errorf("Expected 3 stream calls, got %v", streamCalls.Load())
+	}
+
+	stats := service.sqdClient.(*sqd.SoldexerClient).CacheStats()
+	if stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("Unexpected cache stats %+v", stats)
+	}
+}
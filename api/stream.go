@@ -0,0 +1,149 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/subquery/solana-takoyaki/backend/sqd"
+	"github.com/subquery/solana-takoyaki/logging"
+	"github.com/subquery/solana-takoyaki/metrics"
+	"github.com/subquery/solana-takoyaki/solana"
+)
+
+// The content type of streamed blocks, one JSON record per line
+const CONTENT_TYPE_NDJSON = "application/x-ndjson"
+
+// The maximum size of a stream request body, the same as the JSON-RPC server
+const MAX_STREAM_REQUEST_BYTES = 5 * 1024 * 1024
+
+// The error code of errors that aren't specific to the request, the same as the JSON-RPC server
+const INTERNAL_ERROR_CODE = -32000
+
+type streamBlock struct {
+	Block *solana.Block `json:"block"`
+}
+
+// streamTrailer is the last record of a successful stream, it is the BlockResult without the blocks
+type streamTrailer struct {
+	BlockRange  [2]*big.Int            `json:"blockRange"`
+	GenesisHash string                 `json:"genesisHash"`
+	Warnings    []sqd.TransformWarning `json:"warnings,omitempty"`
+}
+
+type streamErrorBody struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+type streamError struct {
+	Error streamErrorBody `json:"error"`
+}
+
+// Stream serves filterBlocks over HTTP, the body is the same as the filterBlocks params.
+// Blocks are written as newline delimited JSON records as they are transformed rather than building the whole response in memory.
+// The last record is the block range, or an error if the request failed after blocks were written
+func (s *SubqlApiService) Stream(w http.ResponseWriter, r *http.Request) {
+	var err error
+	defer metrics.ObserveRPC("subql_streamBlocks", time.Now(), &err)
+	ctx := withRemoteAddr(logging.WithRequestId(r.Context()), r.RemoteAddr)
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		err = newRequestError(INVALID_PARAMS_ERROR_CODE, nil, "Method %s is not allowed, use POST", r.Method)
+		writeJson(w, http.StatusMethodNotAllowed, newStreamError(err))
+		return
+	}
+
+	var blockReq BlockRequest
+	if err = json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_STREAM_REQUEST_BYTES)).Decode(&blockReq); err != nil {
+		err = newRequestError(INVALID_PARAMS_ERROR_CODE, nil, "Invalid request body: %v", err)
+		writeJson(w, http.StatusBadRequest, newStreamError(err))
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	started := false
+	result, err := s.filterBlocks(ctx, blockReq, func(block *solana.Block) error {
+		if err := s.extendWriteDeadline(rc); err != nil {
+			return err
+		}
+		if !started {
+			w.Header().Set("Content-Type", CONTENT_TYPE_NDJSON)
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		if err := enc.Encode(streamBlock{block}); err != nil {
+			return err
+		}
+		return rc.Flush()
+	})
+
+	if err != nil {
+		// The status can't change once blocks are written, the error is the last record instead
+		if started {
+			slog.WarnContext(ctx, "Block stream failed", "error", err)
+			if err := enc.Encode(newStreamError(err)); err != nil {
+				slog.DebugContext(ctx, "Failed to write stream error", "error", err)
+			}
+			return
+		}
+		writeJson(w, streamErrorStatus(err), newStreamError(err))
+		return
+	}
+
+	if !started {
+		w.Header().Set("Content-Type", CONTENT_TYPE_NDJSON)
+		w.WriteHeader(http.StatusOK)
+	} else if err := s.extendWriteDeadline(rc); err != nil {
+		slog.DebugContext(ctx, "Failed to extend stream write deadline", "error", err)
+	}
+	if err = enc.Encode(streamTrailer{result.BlockRange, result.GenesisHash, result.Warnings}); err != nil {
+		slog.DebugContext(ctx, "Failed to write stream trailer", "error", err)
+	}
+}
+
+// extendWriteDeadline allows streamWriteTimeout to write the next record, writers that don't support deadlines keep the server timeout
+func (s *SubqlApiService) extendWriteDeadline(rc *http.ResponseController) error {
+	if s.streamWriteTimeout <= 0 {
+		return nil
+	}
+	if err := rc.SetWriteDeadline(time.Now().Add(s.streamWriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+// newStreamError converts an error to the same code, message and data as a JSON-RPC error
+func newStreamError(err error) streamError {
+	body := streamErrorBody{Code: INTERNAL_ERROR_CODE, Message: err.Error()}
+	var coded interface{ ErrorCode() int }
+	if errors.As(err, &coded) {
+		body.Code = coded.ErrorCode()
+	}
+	var withData interface{ ErrorData() interface{} }
+	if errors.As(err, &withData) {
+		body.Data = withData.ErrorData()
+	}
+	return streamError{body}
+}
+
+func streamErrorStatus(err error) int {
+	var reqErr *RequestError
+	var forkErr *ForkError
+	switch {
+	case errors.As(err, &reqErr) && reqErr.Code == QUOTA_EXCEEDED_ERROR_CODE:
+		return http.StatusTooManyRequests
+	case errors.As(err, &reqErr):
+		return http.StatusBadRequest
+	case errors.As(err, &forkErr):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/subquery/solana-takoyaki/backend/sqd"
+)
+
+// streamingBackend streams blocks from memory, waiting before each block after the first until next is signalled or the delay passes
+type streamingBackend struct {
+	*memoryBackend
+	next  chan struct{}
+	delay time.Duration
+}
+
+func (b *streamingBackend) QueryStream(ctx context.Context, solReq sqd.SolanaRequest, limit *int, onBlock func(sqd.SolanaBlockResponse) error) error {
+	blocks, err := b.Query(ctx, solReq, limit)
+	if err != nil {
+		return err
+	}
+	for i, block := range blocks {
+		if i > 0 {
+			select {
+			case <-b.next:
+			case <-time.After(b.delay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if err := onBlock(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRecord reads the next stream record
+func readRecord(t *testing.T, scanner *bufio.Scanner) map[string]json.RawMessage {
+	if !scanner.Scan() {
+		t.Fatalf("Expected a record: %v", scanner.Err())
+	}
+	var record map[string]json.RawMessage
+	if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+		t.Fatalf("Invalid record %s: %v", scanner.Text(), err)
+	}
+	return record
+}
+
+func TestStream(t *testing.T) {
+	service := NewSubqlApiServiceWithBackend(newMemoryBackend(t, 100, 199), nil)
+	srv := httptest.NewServer(http.HandlerFunc(service.Stream))
+	defer srv.Close()
+
+	res, err := http.Post(srv.URL, "application/json", strings.NewReader(`{"fromBlock":"0x64","toBlock":"0x6d","limit":"0x5"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != CONTENT_TYPE_NDJSON {
+		t.Fatalf("Unexpected response %v %v", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+
+	records := []map[string]json.RawMessage{}
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		var record map[string]json.RawMessage
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Invalid record %s: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+
+	if len(records) != 6 {
+		t.Fatalf("Expected 5 blocks and a trailer, got %v records", len(records))
+	}
+	for i, record := range records[:5] {
+		var block struct {
+			BlockHeight uint64 `json:"blockHeight"`
+		}
+		if err := json.Unmarshal(record["block"], &block); err != nil || block.BlockHeight != uint64(100+i) {
+			t.Errorf("Expected block %v in order, got %s", 100+i, record["block"])
+		}
+	}
+
+	trailer := records[5]
+	if string(trailer["blockRange"]) != "[100,104]" {
+		t.Errorf("Expected trailer block range [100,104], got %s", trailer["blockRange"])
+	}
+	if _, ok := trailer["block"]; ok {
+		t.Errorf("Trailer shouldn't include a block")
+	}
+}
+
+func TestStreamErrors(t *testing.T) {
+	service := NewSubqlApiServiceWithBackend(newMemoryBackend(t, 100, 199), nil)
+	service.SetQuotas(NewQuotas(1, time.Hour))
+	srv := httptest.NewServer(http.HandlerFunc(service.Stream))
+	defer srv.Close()
+
+	tests := []struct {
+		name         string
+		method       string
+		body         string
+		expectedCode int
+		expectedErr  int
+	}{
+		{"method", http.MethodGet, "", http.StatusMethodNotAllowed, INVALID_PARAMS_ERROR_CODE},
+		{"body", http.MethodPost, "{", http.StatusBadRequest, INVALID_PARAMS_ERROR_CODE},
+		{"range", http.MethodPost, `{"fromBlock":"0x6e","toBlock":"0x64"}`, http.StatusBadRequest, INVALID_RANGE_ERROR_CODE},
+		// The first request uses the quota
+		{"within quota", http.MethodPost, `{"fromBlock":"0x64","limit":"0x2"}`, http.StatusOK, 0},
+		{"quota", http.MethodPost, `{"fromBlock":"0x64","limit":"0x2"}`, http.StatusTooManyRequests, QUOTA_EXCEEDED_ERROR_CODE},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, srv.URL, strings.NewReader(test.body))
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var body streamError
+		json.NewDecoder(res.Body).Decode(&body)
+		res.Body.Close()
+
+		if res.StatusCode != test.expectedCode {
+			t.Errorf("%v: expected status %v, got %v", test.name, test.expectedCode, res.StatusCode)
+		}
+		if test.expectedErr != 0 && body.Error.Code != test.expectedErr {
+			t.Errorf("%v: expected error code %v, got %v", test.name, test.expectedErr, body.Error.Code)
+		}
+	}
+}
+
+// Blocks are written as the backend reads them rather than once the query is complete
+func TestStreamIncremental(t *testing.T) {
+	backend := &streamingBackend{newMemoryBackend(t, 100, 199), make(chan struct{}), 5 * time.Second}
+	service := NewSubqlApiServiceWithBackend(backend, nil)
+	srv := httptest.NewServer(http.HandlerFunc(service.Stream))
+	defer srv.Close()
+
+	res, err := http.Post(srv.URL, "application/json", strings.NewReader(`{"fromBlock":"0x64","toBlock":"0x65","limit":"0x2"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	scanner := bufio.NewScanner(res.Body)
+
+	start := time.Now()
+	if _, ok := readRecord(t, scanner)["block"]; !ok {
+		t.Fatal("Expected the first record to be a block")
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatal("Expected the first block before the query was complete")
+	}
+	close(backend.next)
+
+	if _, ok := readRecord(t, scanner)["block"]; !ok {
+		t.Error("Expected the second record to be a block")
+	}
+	if _, ok := readRecord(t, scanner)["blockRange"]; !ok {
+		t.Error("Expected the stream to end with a trailer")
+	}
+}
+
+// Streams that take longer than the server write timeout aren't cut off as each block extends it
+func TestStreamWriteTimeout(t *testing.T) {
+	backend := &streamingBackend{newMemoryBackend(t, 100, 199), nil, 50 * time.Millisecond}
+	service := NewSubqlApiServiceWithBackend(backend, nil)
+	service.SetStreamWriteTimeout(200 * time.Millisecond)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(service.Stream))
+	srv.Config.WriteTimeout = 200 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	res, err := http.Post(srv.URL, "application/json", strings.NewReader(`{"fromBlock":"0x64","toBlock":"0x6d","limit":"0xa"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	scanner := bufio.NewScanner(res.Body)
+
+	for i := 0; i < 10; i++ {
+		if _, ok := readRecord(t, scanner)["block"]; !ok {
+			t.Fatalf("Expected record %v to be a block", i)
+		}
+	}
+	if _, ok := readRecord(t, scanner)["blockRange"]; !ok {
+		t.Error("Expected the stream to end with a trailer")
+	}
+}
@@ -6,11 +6,19 @@ import (
 	"fmt"
 	"log/slog"
 	"math/big"
+	"runtime"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/subquery/solana-takoyaki/anchor"
 	"github.com/subquery/solana-takoyaki/backend/sqd"
+	"github.com/subquery/solana-takoyaki/cache"
+	"github.com/subquery/solana-takoyaki/logging"
 	"github.com/subquery/solana-takoyaki/meta"
+	"github.com/subquery/solana-takoyaki/metrics"
 	"github.com/subquery/solana-takoyaki/solana"
+	"github.com/subquery/solana-takoyaki/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type TransactionsSelector struct {
@@ -20,10 +28,16 @@ type TransactionsSelector struct {
 
 type InstructionsSelector struct {
 	Transaction bool `json:"transaction"`
+	// Attach the decoded instruction name and args for programs with a registered IDL
+	Decoded bool `json:"decoded"`
+	// Attach the parsed instruction for builtin programs, matching the RPC jsonParsed format
+	Parsed bool `json:"parsed"`
 }
 
 type LogsSelector struct {
 	Transaction bool `json:"transaction"`
+	// Attach the decoded event for programs with a registered IDL
+	Decoded bool `json:"decoded"`
 }
 
 type FieldSelector struct {
@@ -59,6 +73,13 @@ type BlockRequest struct {
 	Limit         *big.Int
 	BlockFilter   *BlockFilter
 	FieldSelector *FieldSelector
+	Encoding      string // json (default), jsonParsed or base64
+	// Only return blocks up to the finalized head
+	FinalizedOnly bool
+	// The hash of the last block the client has before FromBlock, if the returned blocks don't build on it a ForkError is returned
+	ParentHash *string
+	// Skip transactions and rewards that can't be transformed and report them as warnings, by default the request fails
+	Lenient bool
 }
 
 // TODO BlockFilter json methods for bigints
@@ -67,25 +88,73 @@ type BlockResult struct {
 	Blocks      []*solana.Block `json:"blocks"`
 	BlockRange  [2]*big.Int     `json:"blockRange"` // Tuple [start, end]
 	GenesisHash string          `json:"genesisHash"`
+	// Transactions and rewards that were skipped because they couldn't be transformed
+	Warnings []sqd.TransformWarning `json:"warnings,omitempty"`
 }
 
 type SubqlApiService struct {
 	// networkMeta meta.NetworkMeta
-	sqdClient *sqd.SoldexerClient
+	sqdClient sqd.Backend
+	idls      *anchor.Registry // Optional, used to decode instructions and events
+	limits    Limits
+	quotas    *Quotas // Optional, limits the blocks served to each API key
+	// The number of blocks transformed at once for each request
+	transformConcurrency int
+	// The time allowed to write each streamed block, extending the server write timeout so long streams aren't cut off
+	streamWriteTimeout time.Duration
 }
 
 func NewSubqlApiService(
 	networkMeta meta.NetworkMeta,
 	sqdUrl string,
+	idls *anchor.Registry,
+	queryCache *cache.Cache, // Optional, caches finalized query results
 ) (*SubqlApiService, error) {
+	sqdClient := sqd.NewSoldexerClient(sqdUrl)
+	if queryCache != nil {
+		sqdClient.SetCache(queryCache)
+	}
+
+	return NewSubqlApiServiceWithBackend(sqdClient, idls), nil
+}
+
+// NewSubqlApiServiceWithBackend creates a service that reads blocks from any backend, such as a local archive
+func NewSubqlApiServiceWithBackend(backend sqd.Backend, idls *anchor.Registry) *SubqlApiService {
 	return &SubqlApiService{
 		// networkMeta,
-		sqd.NewSoldexerClient(sqdUrl),
-	}, nil
+		sqdClient:            backend,
+		idls:                 idls,
+		limits:               DEFAULT_LIMITS,
+		transformConcurrency: runtime.GOMAXPROCS(0),
+	}
+}
+
+func (s *SubqlApiService) SetLimits(limits Limits) {
+	s.limits = limits
+}
+
+func (s *SubqlApiService) SetQuotas(quotas *Quotas) {
+	s.quotas = quotas
+}
+
+// SetStreamWriteTimeout sets the time allowed to write each block of a stream, 0 leaves the server write timeout for the whole stream
+func (s *SubqlApiService) SetStreamWriteTimeout(timeout time.Duration) {
+	s.streamWriteTimeout = timeout
+}
+
+// SetTransformConcurrency sets the number of blocks transformed at once for each request, less than 1 uses GOMAXPROCS
+func (s *SubqlApiService) SetTransformConcurrency(concurrency int) {
+	if concurrency < 1 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	s.transformConcurrency = concurrency
 }
 
-func (s *SubqlApiService) FilterBlocksCapabilities(ctx context.Context) (*Capability, error) {
-	currentHeight, err := s.sqdClient.CurrentHeight(ctx)
+func (s *SubqlApiService) FilterBlocksCapabilities(ctx context.Context) (_ *Capability, err error) {
+	defer metrics.ObserveRPC("subql_filterBlocksCapabilities", time.Now(), &err)
+	ctx = logging.WithRequestId(ctx)
+
+	head, err := s.sqdClient.Head(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -99,8 +168,10 @@ func (s *SubqlApiService) FilterBlocksCapabilities(ctx context.Context) (*Capabi
 		AvailableBlocks: []AvailableBlocks{{
 			meta.StartBlock,
 			// s.networkMeta.EarliestSQDBlock,
-			currentHeight,
+			head.Number,
 		}},
+		LatestHeight:       head.Number,
+		FinalizedHeight:    s.finalizedHeight(ctx),
 		SupportedResponses: []string{"basic", "complete"},
 		GenesisHash:        meta.GenesisHash,
 		ChainId:            meta.ChainId,
@@ -113,8 +184,46 @@ func (s *SubqlApiService) FilterBlocksCapabilities(ctx context.Context) (*Capabi
 	return capabilities, nil
 }
 
-func (s *SubqlApiService) FilterBlocks(ctx context.Context, blockReq BlockRequest) (*BlockResult, error) {
-	slog.Debug("Filter Blocks")
+// finalizedHeight returns the finalized head number, or nil if the portal doesn't have a finalized head yet
+func (s *SubqlApiService) finalizedHeight(ctx context.Context) *uint {
+	finalizedHead, err := s.sqdClient.FinalizedHead(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to get finalized head", "error", err)
+		return nil
+	}
+	height := finalizedHead.Number
+	return &height
+}
+
+func (s *SubqlApiService) FilterBlocks(ctx context.Context, blockReq BlockRequest) (_ *BlockResult, err error) {
+	defer metrics.ObserveRPC("subql_filterBlocks", time.Now(), &err)
+	ctx = logging.WithRequestId(ctx)
+	return s.filterBlocks(ctx, blockReq, nil)
+}
+
+// filterBlocks runs a filterBlocks request. If onBlock is set each block is passed to it in order once transformed rather than being included in the result
+func (s *SubqlApiService) filterBlocks(ctx context.Context, blockReq BlockRequest, onBlock func(*solana.Block) error) (_ *BlockResult, err error) {
+	ctx, span := tracing.Start(ctx, "FilterBlocks")
+	defer tracing.End(span, &err)
+	slog.DebugContext(ctx, "Filter Blocks", "fromBlock", blockReq.FromBlock, "toBlock", blockReq.ToBlock)
+
+	if err := s.validateRequest(ctx, &blockReq); err != nil {
+		return nil, err
+	}
+
+	if err := s.quotas.Check(ctx); err != nil {
+		return nil, err
+	}
+
+	// Wide ranges are truncated, the block range of the result ends at the last slot searched
+	rangeTruncated := false
+	if s.limits.MaxRangeWidth > 0 {
+		maxTo := new(big.Int).Add(blockReq.FromBlock, big.NewInt(int64(s.limits.MaxRangeWidth-1)))
+		if blockReq.ToBlock.Cmp(maxTo) > 0 {
+			blockReq.ToBlock = maxTo
+			rangeTruncated = true
+		}
+	}
 
 	meta, err := s.sqdClient.Metadata(ctx)
 	if err != nil {
@@ -125,31 +234,45 @@ func (s *SubqlApiService) FilterBlocks(ctx context.Context, blockReq BlockReques
 		GenesisHash: meta.ChainId,
 	}
 
-	req := sqd.SolanaRequest{
-		Type:      "solana",
-		FromBlock: uint(blockReq.FromBlock.Uint64()),
-		ToBlock:   uint(blockReq.ToBlock.Uint64()),
-		Fields:    s.sqdClient.GetAllFields(),
-		// Empty item means no filter, these will get updated based on the block filters
-		Transactions:  []sqd.TransactionRequest{},
-		Instructions:  []sqd.InstructionRequest{},
-		Rewards:       []sqd.RewardRequest{},
-		TokenBalances: []sqd.TokenBalanceRequest{},
-		Balances:      []sqd.BalancesRequest{},
-		Logs:          []sqd.LogRequest{},
+	if blockReq.FinalizedOnly {
+		finalizedHead, err := s.sqdClient.FinalizedHead(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		// Nothing is finalized in the range yet, the end being before the start indicates there is nothing to search
+		if blockReq.FromBlock.Uint64() > uint64(finalizedHead.Number) {
+			blockResult.Blocks = []*solana.Block{}
+			blockResult.BlockRange = [2]*big.Int{
+				blockReq.FromBlock,
+				big.NewInt(int64(finalizedHead.Number)),
+			}
+			return blockResult, nil
+		}
+
+		if blockReq.ToBlock.Uint64() > uint64(finalizedHead.Number) {
+			blockReq.ToBlock = big.NewInt(int64(finalizedHead.Number))
+		}
 	}
 
-	err = ApplyFiltersToSQDRequest(&req, *blockReq.BlockFilter)
+	req, err := s.buildSQDRequest(ctx, blockReq)
 	if err != nil {
-		slog.Error("Failed to apply filters", "error", err)
 		return nil, err
 	}
 
+	span.SetAttributes(
+		attribute.Int64("subql.from_block", blockReq.FromBlock.Int64()),
+		attribute.Int64("subql.to_block", blockReq.ToBlock.Int64()),
+		attribute.Int64("subql.limit", blockReq.Limit.Int64()),
+	)
+
 	// Create channels to receive results from goroutines
 	type queryResult struct {
-		res    []sqd.SolanaBlockResponse
-		blocks []*solana.Block
-		err    error
+		refs      []sqd.BlockRef
+		blocks    []*solana.Block
+		warnings  []sqd.TransformWarning
+		truncated bool
+		err       error
 	}
 	type heightResult struct {
 		height uint
@@ -161,40 +284,19 @@ func (s *SubqlApiService) FilterBlocks(ctx context.Context, blockReq BlockReques
 
 	// Launch goroutines for parallel execution
 	go func() {
-		limit := int(blockReq.Limit.Int64())
-		res, err := s.sqdClient.Query(ctx, req, &limit)
-		if err != nil {
-			queryChan <- queryResult{err: err}
-			return
-		}
-
-		// Transform blocks within the goroutine
-		blocks := make([]*solana.Block, 0, len(res))
-		for _, block := range res {
-			rpcBlock, err := sqd.TransformBlock(block)
-			if err != nil {
-				slog.Error("Failed to transform block", "error", err, "block num", block.Header.Slot)
-				queryChan <- queryResult{err: err}
-				return
-			}
-			if rpcBlock == nil {
-				queryChan <- queryResult{err: fmt.Errorf("Block %d is nil", block.Header.Slot)}
-				return
-			}
-			blocks = append(blocks, rpcBlock)
-		}
-		queryChan <- queryResult{res: res, blocks: blocks}
+		refs, blocks, warnings, truncated, err := s.queryBlocks(ctx, req, blockReq, onBlock)
+		queryChan <- queryResult{refs, blocks, warnings, truncated, err}
 	}()
 
 	go func() {
-		height, err := s.sqdClient.CurrentHeight(ctx)
+		height, err := s.currentHeight(ctx, blockReq.FinalizedOnly)
 		heightChan <- heightResult{height, err}
 	}()
 
 	// Wait for both results
 	queryRes := <-queryChan
 	if queryRes.err != nil {
-		slog.Error("Failed to run filter query", "error", queryRes.err)
+		slog.ErrorContext(ctx, "Failed to run filter query", "error", queryRes.err)
 		return nil, queryRes.err
 	}
 
@@ -204,15 +306,227 @@ func (s *SubqlApiService) FilterBlocks(ctx context.Context, blockReq BlockReques
 	}
 
 	// This response always returns the first and last block in the range even if there is no match as a way to indicate the blocks searched.
-	blockResult.BlockRange = [2]*big.Int{
-		big.NewInt(int64(queryRes.res[0].Header.Slot)),
-		big.NewInt(int64(heightRes.height)),
+	start := blockReq.FromBlock
+	if len(queryRes.refs) > 0 {
+		start = big.NewInt(int64(queryRes.refs[0].Number))
 	}
+	end := big.NewInt(int64(heightRes.height))
+	if queryRes.truncated {
+		// Only the blocks up to the last one returned were searched
+		end = big.NewInt(int64(queryRes.refs[len(queryRes.refs)-1].Number))
+	} else if rangeTruncated && blockReq.ToBlock.Cmp(end) < 0 {
+		end = blockReq.ToBlock
+	}
+	blockResult.BlockRange = [2]*big.Int{start, end}
 
+	// Streamed blocks aren't kept, the SQD blocks match the blocks returned
 	blockResult.Blocks = queryRes.blocks
+	blockResult.Warnings = queryRes.warnings
+	span.SetAttributes(attribute.Int("subql.blocks", len(queryRes.refs)))
+	s.quotas.Record(ctx, len(queryRes.refs))
 	return blockResult, nil
 }
 
+// currentHeight returns the latest height, or the finalized height if finalized is true
+func (s *SubqlApiService) currentHeight(ctx context.Context, finalized bool) (uint, error) {
+	if !finalized {
+		head, err := s.sqdClient.Head(ctx)
+		if err != nil {
+			return 0, err
+		}
+		return head.Number, nil
+	}
+
+	head, err := s.sqdClient.FinalizedHead(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return head.Number, nil
+}
+
+func (s *SubqlApiService) buildSQDRequest(ctx context.Context, blockReq BlockRequest) (sqd.SolanaRequest, error) {
+	req := sqd.SolanaRequest{
+		Type:            "solana",
+		FromBlock:       uint(blockReq.FromBlock.Uint64()),
+		ToBlock:         uint(blockReq.ToBlock.Uint64()),
+		ParentBlockHash: blockReq.ParentHash,
+		Fields:          sqd.ALL_SOLDEXER_FIELDS,
+		// Empty item means no filter, these will get updated based on the block filters
+		Transactions:  []sqd.TransactionRequest{},
+		Instructions:  []sqd.InstructionRequest{},
+		Rewards:       []sqd.RewardRequest{},
+		TokenBalances: []sqd.TokenBalanceRequest{},
+		Balances:      []sqd.BalancesRequest{},
+		Logs:          []sqd.LogRequest{},
+	}
+
+	err := ApplyFiltersToSQDRequest(&req, *blockReq.BlockFilter)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to apply filters", "error", err)
+		return req, err
+	}
+
+	return req, nil
+}
+
+// queryBlocks runs the SQD query and transforms the blocks as they are read.
+// refs are the SQD blocks searched, they match the transformed blocks. truncated is true if the results were cut short by the limit or the maximum response size.
+// If onBlock is set the transformed blocks are passed to it in order instead of being returned
+func (s *SubqlApiService) queryBlocks(ctx context.Context, req sqd.SolanaRequest, blockReq BlockRequest, onBlock func(*solana.Block) error) (refs []sqd.BlockRef, blocks []*solana.Block, warnings []sqd.TransformWarning, truncated bool, err error) {
+	limit := int(blockReq.Limit.Int64())
+	size := 0
+
+	// Blocks are transformed concurrently and collected in order, blocks after an error or the size limit are cancelled.
+	// A slot is only freed once its block is collected, so at most transformConcurrency blocks are being transformed or waiting to be collected
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type pendingBlock struct {
+		ref    sqd.BlockRef
+		result chan transformResult
+	}
+	pending := make(chan pendingBlock, s.transformConcurrency)
+	queryErr := make(chan error, 1)
+	sem := make(chan struct{}, s.transformConcurrency)
+
+	go func() {
+		defer close(pending)
+
+		var prev *sqd.SolanaBlockResponse
+		queryErr <- sqd.QueryStream(ctx, s.sqdClient, req, &limit, func(block sqd.SolanaBlockResponse) error {
+			// Each block must build on the parent hash or the previous block
+			var err error
+			if prev == nil {
+				err = checkContinuity(blockReq.ParentHash, []sqd.SolanaBlockResponse{block})
+			} else {
+				err = checkContinuity(nil, []sqd.SolanaBlockResponse{*prev, block})
+			}
+			if err != nil {
+				slog.WarnContext(ctx, "Fork detected", "error", err)
+				return err
+			}
+			prev = &sqd.SolanaBlockResponse{Header: block.Header}
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			result := make(chan transformResult, 1)
+			go func() {
+				result <- s.transformSizedBlock(ctx, block, blockReq)
+			}()
+
+			select {
+			case pending <- pendingBlock{sqd.BlockRef{Number: uint(block.Header.Slot), Hash: block.Header.Hash}, result}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	blocks = []*solana.Block{}
+	for p := range pending {
+		var result transformResult
+		select {
+		case result = <-p.result:
+		case <-ctx.Done():
+			return nil, nil, nil, false, ctx.Err()
+		}
+		if result.err != nil {
+			return nil, nil, nil, false, result.err
+		}
+
+		// At least one block is always returned so requests can make progress
+		if s.limits.MaxResponseBytes > 0 {
+			size += result.size
+			if size > s.limits.MaxResponseBytes && len(refs) > 0 {
+				slog.DebugContext(ctx, "Response size limit reached", "blocks", len(refs), "bytes", size)
+				return refs, blocks, warnings, true, nil
+			}
+		}
+
+		if onBlock != nil {
+			if err := onBlock(result.block); err != nil {
+				return nil, nil, nil, false, err
+			}
+		} else {
+			blocks = append(blocks, result.block)
+		}
+		refs = append(refs, p.ref)
+		warnings = append(warnings, result.warnings...)
+		<-sem
+	}
+
+	if err := <-queryErr; err != nil {
+		return nil, nil, nil, false, toForkError(err, blockReq.ParentHash)
+	}
+
+	return refs, blocks, warnings, len(refs) >= limit, nil
+}
+
+type transformResult struct {
+	block    *solana.Block
+	warnings []sqd.TransformWarning
+	size     int // The JSON size of the block, only set if there is a response size limit
+	err      error
+}
+
+// transformSizedBlock transforms the block and measures its size when responses are limited, so encoding also happens concurrently
+func (s *SubqlApiService) transformSizedBlock(ctx context.Context, block sqd.SolanaBlockResponse, blockReq BlockRequest) transformResult {
+	rpcBlock, warnings, err := s.transformBlock(ctx, block, blockReq)
+	if err != nil {
+		return transformResult{err: err}
+	}
+
+	result := transformResult{block: rpcBlock, warnings: warnings}
+	if s.limits.MaxResponseBytes > 0 {
+		encoded, err := json.Marshal(rpcBlock)
+		if err != nil {
+			return transformResult{err: err}
+		}
+		result.size = len(encoded)
+	}
+	return result
+}
+
+// transformBlock converts an SQD block to a solana block then decodes and encodes it as requested.
+// If the request is lenient, transactions and rewards that fail to transform are skipped and returned as warnings
+func (s *SubqlApiService) transformBlock(ctx context.Context, block sqd.SolanaBlockResponse, blockReq BlockRequest) (_ *solana.Block, warnings []sqd.TransformWarning, err error) {
+	ctx, span := tracing.Start(ctx, "TransformBlock", attribute.Int64("solana.slot", int64(block.Header.Slot)))
+	defer tracing.End(span, &err)
+
+	var rpcBlock *solana.Block
+	if blockReq.Lenient {
+		rpcBlock, warnings, err = sqd.TransformBlockLenient(block)
+	} else {
+		rpcBlock, err = sqd.TransformBlock(block)
+	}
+	if err != nil {
+		metrics.IncTransformErrors()
+		slog.ErrorContext(ctx, "Failed to transform block", "error", err, "block num", block.Header.Slot)
+		return nil, nil, err
+	}
+	if rpcBlock == nil {
+		return nil, nil, fmt.Errorf("Block %d is nil", block.Header.Slot)
+	}
+	for _, warning := range warnings {
+		metrics.IncTransformWarnings()
+		slog.WarnContext(ctx, "Skipped item that failed to transform", "block num", warning.Slot, "transactionIndex", warning.TransactionIndex, "reward", warning.RewardPubkey, "error", warning.Message)
+	}
+	span.SetAttributes(attribute.Int("solana.transform_warnings", len(warnings)))
+
+	decodeBlock(ctx, rpcBlock, s.idls, blockReq.FieldSelector)
+	if err := encodeBlock(ctx, rpcBlock, blockReq.Encoding, s.idls); err != nil {
+		slog.ErrorContext(ctx, "Failed to encode block", "error", err, "block num", block.Header.Slot)
+		return nil, nil, err
+	}
+
+	return rpcBlock, warnings, nil
+}
+
 func ApplyFiltersToSQDRequest(req *sqd.SolanaRequest, blockFilter BlockFilter) error {
 	if len(blockFilter.Transactions) > 0 {
 		req.Transactions = []sqd.TransactionRequest{}
@@ -280,6 +594,10 @@ func (b *BlockRequest) UnmarshalJSON(data []byte) error {
 		Limit         *hexutil.Big   `json:"limit"`
 		BlockFilter   *BlockFilter   `json:"blockFilter"`
 		FieldSelector *FieldSelector `json:"fieldSelector"`
+		Encoding      string         `json:"encoding"`
+		FinalizedOnly bool           `json:"finalizedOnly"`
+		ParentHash    *string        `json:"parentHash"`
+		Lenient       bool           `json:"lenient"`
 	}
 
 	var raw rawBlockFilter
@@ -298,6 +616,10 @@ func (b *BlockRequest) UnmarshalJSON(data []byte) error {
 	}
 	b.BlockFilter = raw.BlockFilter
 	b.FieldSelector = raw.FieldSelector
+	b.Encoding = raw.Encoding
+	b.FinalizedOnly = raw.FinalizedOnly
+	b.ParentHash = raw.ParentHash
+	b.Lenient = raw.Lenient
 
 	return nil
 }
@@ -4,84 +4,76 @@ import (
 	"context"
 	"encoding/json"
 	"math/big"
+	"runtime"
+	"slices"
 	"testing"
 
 	"github.com/subquery/solana-takoyaki/backend/sqd"
+	"github.com/subquery/solana-takoyaki/backend/sqd/sqdtest"
 	"github.com/subquery/solana-takoyaki/meta"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const SLOT = sqdtest.FIRST_SLOT
 const BLOCK = 305_604_799
 
-var BLOCK_BN = big.NewInt(BLOCK)
+// https://solscan.io/tx/2ampeuTVonhonmpbwnpDVZA5NhLKnzzFRFFjdHZfZDNp8CaGZZA7tFC8FoobMyvaxSMxUyzQRAPFkCsKhhdSCYGK
+// A failed Raydium swap using lookup tables
+const RAYDIUM_TX = "2ampeuTVonhonmpbwnpDVZA5NhLKnzzFRFFjdHZfZDNp8CaGZZA7tFC8FoobMyvaxSMxUyzQRAPFkCsKhhdSCYGK"
+
+var SLOT_BN = big.NewInt(SLOT)
 
 func compareAsJson(t *testing.T, expected, got interface{}, errorPrefix string) {
 	aStr, _ := json.Marshal(expected)
 	bStr, _ := json.Marshal(got)
 	if string(aStr) != string(bStr) {
-		// t.Errorf("%s Data missmatch", errorPrefix)
 		t.Errorf("%s Mismatch\nexpected: %v\ngot: %v", errorPrefix, string(aStr), string(bStr))
 	}
 }
 
 func TestFilterFullBlock(t *testing.T) {
+	portal := sqdtest.NewPortal(t)
 
-	sqdUrl, err := sqd.GetSquidUrl(context.Background(), "solana-mainnet")
-	if err != nil {
-		t.Fatalf("Failed to get SQD url: %v", err)
-	}
-
-	apiService, err := NewSubqlApiService(meta.MAINNET, sqdUrl)
+	apiService, err := NewSubqlApiService(meta.MAINNET, portal.URL, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	res, err := apiService.FilterBlocks(context.Background(), BlockFilter{
-		FromBlock: BLOCK_BN,
-		ToBlock:   BLOCK_BN,
-		Limit:     1,
-		Instructions: []InstFilterQuery{
-			{ProgramIds: []string{"675kPX9MHTjS2zt1qfr1NYHuzeLXfQM9H24wFSUt1Mp8"}},
-		},
-		Logs: []LogFilterQuery{
-			{ProgramIds: []string{"675kPX9MHTjS2zt1qfr1NYHuzeLXfQM9H24wFSUt1Mp8"}},
+	res, err := apiService.FilterBlocks(context.Background(), BlockRequest{
+		FromBlock: SLOT_BN,
+		ToBlock:   SLOT_BN,
+		Limit:     big.NewInt(1),
+		BlockFilter: &BlockFilter{
+			Instructions: []InstFilterQuery{
+				{ProgramIds: []string{"675kPX9MHTjS2zt1qfr1NYHuzeLXfQM9H24wFSUt1Mp8"}},
+			},
+			Logs: []LogFilterQuery{
+				{ProgramIds: []string{"675kPX9MHTjS2zt1qfr1NYHuzeLXfQM9H24wFSUt1Mp8"}},
+			},
 		},
 	})
-
 	if err != nil {
 		t.Fatalf("Failed to filter blocks: %v", err)
 	}
 
-	rawFullBlock, err := apiService.sqdClient.Query(context.Background(), sqd.SolanaRequest{
-		Type:          "solana",
-		FromBlock:     BLOCK,
-		ToBlock:       BLOCK,
-		Fields:        sqd.ALL_FIELDS,
-		Transactions:  []sqd.TransactionRequest{{}}, // Empty item means no filter
-		Instructions:  []sqd.InstructionRequest{{}},
-		Rewards:       []sqd.RewardRequest{{}},
-		TokenBalances: []sqd.TokenBalanceRequest{{}},
-		Balances:      []sqd.BalancesRequest{{}},
-		Logs:          []sqd.LogRequest{{}},
-	})
-	if err != nil {
-		t.Fatalf("Failed to get full block to compare: %v", err)
-	}
-
-	fullBlock, err := sqd.TransformBlock(rawFullBlock[0])
+	fullBlock, err := sqd.TransformBlock(sqdtest.Block(t, SLOT))
 	if err != nil {
 		t.Fatalf("Failed to parse full block to compare: %v", err)
 	}
 
 	if len(res.Blocks) != 1 {
-		t.Errorf("Expected 1 block, got %v", len(res.Blocks))
+		t.Fatalf("Expected 1 block, got %v", len(res.Blocks))
 	}
 
-	if res.BlockRange[0] != BLOCK_BN {
-		t.Errorf("Expected block range start %v, got %v", BLOCK, res.BlockRange[0].String())
+	if res.BlockRange[0].Cmp(SLOT_BN) != 0 {
+		t.Errorf("Expected block range start %v, got %v", SLOT, res.BlockRange[0].String())
 	}
 
-	if res.BlockRange[1] != BLOCK_BN {
-		t.Errorf("Expected block range end %v, got %v", BLOCK, res.BlockRange[1].String())
+	if res.BlockRange[1].Cmp(SLOT_BN) != 0 {
+		t.Errorf("Expected block range end %v, got %v", SLOT, res.BlockRange[1].String())
 	}
 
 	block := res.Blocks[0]
@@ -90,27 +82,129 @@ func TestFilterFullBlock(t *testing.T) {
 		t.Errorf("Expected block height %v, got %v", BLOCK, block.BlockHeight)
 	}
 
-	// fmt.Printf("NUM TX expected %v, got %v\n", len(fullBlock.Transactions), len(block.Transactions))
+	// Only the matching transaction is included, in full
+	if len(block.Transactions) != 1 {
+		t.Fatalf("Expected 1 transaction, got %v", len(block.Transactions))
+	}
 
-	// for idx, tx := range fullBlock.Transactions {
-	// 	if tx.Transaction.Signatures[0] == "2SB7fVzaUyU8knSbEa42c2BKQJXm1QPamtiFXKapX6YwLbbAm7dzezaGKyXfb6uGRH8a1xTeovSmWnbgav7jeKCS" {
-	// 		fmt.Println("FULL BLOCK INDEX", idx)
-	// 		break
-	// 	}
-	// }
+	compareAsJson(t, fullBlock.Transactions[1], block.Transactions[0], "Transaction "+RAYDIUM_TX)
+}
 
-	// for idx, tx := range block.Transactions {
-	// 	if tx.Transaction.Signatures[0] == "2SB7fVzaUyU8knSbEa42c2BKQJXm1QPamtiFXKapX6YwLbbAm7dzezaGKyXfb6uGRH8a1xTeovSmWnbgav7jeKCS" {
-	// 		fmt.Println("Filter BLOCK INDEX", idx)
-	// 		break
-	// 	}
-	// }
+// recordSpans records the spans started during the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	prevProvider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(prevProvider) })
+	return recorder
+}
 
-	if len(fullBlock.Transactions) != len(block.Transactions) {
-		t.Errorf("Expected %v transactions, got %v", len(fullBlock.Transactions), len(block.Transactions))
+// transformSpans returns the TransformBlock spans of the recorded FilterBlocks request.
+// Spans of other traces are left out, blocks still being transformed after a previous request was cancelled can end during the test
+func transformSpans(recorder *tracetest.SpanRecorder) []sdktrace.ReadOnlySpan {
+	var traceId trace.TraceID
+	for _, span := range recorder.Ended() {
+		if span.Name() == "FilterBlocks" {
+			traceId = span.SpanContext().TraceID()
+		}
 	}
 
-	compareAsJson(t, fullBlock.Transactions[5], block.Transactions[5], "Transaction 2SB7fVzaUyU8knSbEa42c2BKQJXm1QPamtiFXKapX6YwLbbAm7dzezaGKyXfb6uGRH8a1xTeovSmWnbgav7jeKCS")
+	spans := []sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		if span.Name() == "TransformBlock" && span.SpanContext().TraceID() == traceId {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func TestFilterBlocksCapabilitiesNoFinalizedHead(t *testing.T) {
+	portal := sqdtest.NewPortal(t)
+	portal.SetFinalizedHead(nil)
 
-	// compareAsJson(t, fullBlock, block, fmt.Sprintf("Block %v", BLOCK))
+	apiService, err := NewSubqlApiService(meta.MAINNET, portal.URL, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	capabilities, err := apiService.FilterBlocksCapabilities(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get capabilities: %v", err)
+	}
+	if capabilities.LatestHeight != sqdtest.LAST_SLOT || capabilities.FinalizedHeight != nil {
+		t.Errorf("Unexpected heights %v, %v", capabilities.LatestHeight, capabilities.FinalizedHeight)
+	}
+}
+
+func TestQueryBlocksOrder(t *testing.T) {
+	service := NewSubqlApiServiceWithBackend(newMemoryBackend(t, 100, 199), nil)
+	service.SetTransformConcurrency(8)
+
+	res, err := service.FilterBlocks(context.Background(), BlockRequest{FromBlock: big.NewInt(100), ToBlock: big.NewInt(199), Limit: big.NewInt(100)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Blocks) != 100 {
+		t.Fatalf("Expected 100 blocks, got %v", len(res.Blocks))
+	}
+	for i, block := range res.Blocks {
+		if block.BlockHeight != uint64(100+i) {
+			t.Fatalf("Expected block %v at %v, got %v", 100+i, i, block.BlockHeight)
+		}
+	}
+}
+
+func TestQueryBlocksTransformError(t *testing.T) {
+	recorder := recordSpans(t)
+
+	backend := newMemoryBackend(t, 100, 199)
+	var invalid sqd.SolanaBlockResponse
+	raw := `{"transactions":[{"transactionIndex":0,"signatures":["sig0"],"accountKeys":["payer"],"fee":"invalid","computeUnitsConsumed":"100","loadedAddresses":{"readonly":[],"writable":[]}}]}`
+	if err := json.Unmarshal([]byte(raw), &invalid); err != nil {
+		t.Fatal(err)
+	}
+	backend.blocks[10].Transactions = invalid.Transactions
+
+	service := NewSubqlApiServiceWithBackend(backend, nil)
+	service.SetTransformConcurrency(1)
+
+	_, err := service.FilterBlocks(context.Background(), BlockRequest{FromBlock: big.NewInt(100), ToBlock: big.NewInt(199), Limit: big.NewInt(100)})
+	if err == nil {
+		t.Fatal("Expected the transform error to fail the request")
+	}
+
+	// Blocks after the error aren't transformed
+	if spans := transformSpans(recorder); len(spans) != 11 {
+		t.Errorf("Expected the blocks after the error to be cancelled, got %v transformed", len(spans))
+	}
+}
+
+func TestSetTransformConcurrency(t *testing.T) {
+	recorder := recordSpans(t)
+
+	service := NewSubqlApiServiceWithBackend(newMemoryBackend(t, 100, 199), nil)
+	service.SetTransformConcurrency(0)
+	if service.transformConcurrency != runtime.GOMAXPROCS(0) {
+		t.Errorf("Expected a concurrency of 0 to use GOMAXPROCS, got %v", service.transformConcurrency)
+	}
+
+	// Blocks are transformed one at a time
+	service.SetTransformConcurrency(1)
+	if _, err := service.FilterBlocks(context.Background(), BlockRequest{FromBlock: big.NewInt(100), ToBlock: big.NewInt(149), Limit: big.NewInt(50)}); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := transformSpans(recorder)
+	if len(spans) != 50 {
+		t.Fatalf("Expected 50 transformed blocks, got %v", len(spans))
+	}
+	slices.SortFunc(spans, func(a, b sdktrace.ReadOnlySpan) int {
+		return a.StartTime().Compare(b.StartTime())
+	})
+	for i := 1; i < len(spans); i++ {
+		if spans[i].StartTime().Before(spans[i-1].EndTime()) {
+			t.Fatalf("Expected blocks to be transformed one at a time, block %v started before the previous one ended", i)
+		}
+	}
 }
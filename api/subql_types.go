@@ -46,6 +46,8 @@ type AvailableBlocks struct {
 
 type Capability struct {
 	AvailableBlocks    []AvailableBlocks   `json:"availableBlocks"`
+	LatestHeight       uint                `json:"latestHeight"`              // The latest slot, this could still be reorged
+	FinalizedHeight    *uint               `json:"finalizedHeight,omitempty"` // The latest finalized slot, omitted if the portal has no finalized head
 	Filters            map[string][]string `json:"filters"`
 	SupportedResponses []string            `json:"supportedResponses"`
 	GenesisHash        string              `json:"genesisHash"`
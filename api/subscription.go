@@ -0,0 +1,203 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/subquery/solana-takoyaki/backend/sqd"
+	"github.com/subquery/solana-takoyaki/logging"
+	"github.com/subquery/solana-takoyaki/metrics"
+)
+
+// How often the portal head is checked for new blocks
+const SUBSCRIPTION_POLL_INTERVAL = time.Second
+
+// The default number of blocks per notification when the request doesn't specify a limit
+const SUBSCRIPTION_DEFAULT_LIMIT = 100
+
+// The number of blocks sent by a subscription that are kept to find a common ancestor when a fork is detected
+const SUBSCRIPTION_TRACKED_BLOCKS = 1000
+
+// ForkNotification is the final notification of a subscription when a fork is detected.
+// The client should roll back any blocks from ResumeFrom onwards and resubscribe with `fromBlock` set to ResumeFrom
+type ForkNotification struct {
+	Fork ForkErrorData `json:"fork"`
+	// The slot after the last block sent that is still on the canonical chain, or the first slot of the subscription if none are known to be
+	ResumeFrom uint64 `json:"resumeFrom"`
+}
+
+// FilteredBlocks subscribes to new blocks matching the request filter, available as `subql_subscribe("filteredBlocks", request)`.
+// Each notification is a BlockResult covering the searched range, the block range end can be used as `fromBlock` to resume after reconnecting.
+// If `fromBlock` is not set the subscription starts at the current head, `toBlock` is ignored.
+// The hash of the last block sent is used as the parent hash of the next query, if a fork is detected a final ForkNotification is sent
+// and the subscription stops sending notifications, the client should roll back and resubscribe.
+func (s *SubqlApiService) FilteredBlocks(ctx context.Context, blockReq BlockRequest) (_ *rpc.Subscription, err error) {
+	defer metrics.ObserveRPC("subql_subscribe_filteredBlocks", time.Now(), &err)
+	ctx = logging.WithRequestId(ctx)
+
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+
+	next := uint(0)
+	if blockReq.FromBlock != nil {
+		next = uint(blockReq.FromBlock.Uint64())
+	} else {
+		height, err := s.currentHeight(ctx, blockReq.FinalizedOnly)
+		if err != nil {
+			return nil, err
+		}
+		next = height
+	}
+
+	if blockReq.Limit == nil {
+		blockReq.Limit = big.NewInt(SUBSCRIPTION_DEFAULT_LIMIT)
+	}
+
+	// Validate the request before creating the subscription
+	if err := s.validateOptions(&blockReq); err != nil {
+		return nil, err
+	}
+	blockReq.FromBlock = big.NewInt(int64(next))
+	blockReq.ToBlock = big.NewInt(int64(next))
+	if _, err := s.buildSQDRequest(ctx, blockReq); err != nil {
+		return nil, err
+	}
+
+	sub := notifier.CreateSubscription()
+	start := next
+
+	// The request context is cancelled once the subscription is created, keep its values for quotas
+	subCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+
+	go func() {
+		defer cancel()
+
+		ticker := time.NewTicker(SUBSCRIPTION_POLL_INTERVAL)
+		defer ticker.Stop()
+
+		sent := []sqd.BlockRef{}
+
+		for {
+			if err := s.quotas.Check(subCtx); err != nil {
+				slog.DebugContext(subCtx, "Subscription quota exceeded", "subscription", sub.ID, "error", err)
+				select {
+				case <-sub.Err():
+					return
+				case <-ticker.C:
+					continue
+				}
+			}
+
+			res, refs, behind, err := s.nextFilteredBlocks(subCtx, blockReq, next)
+			var forkErr *ForkError
+			if errors.As(err, &forkErr) {
+				notification := &ForkNotification{
+					Fork:       forkErr.Data,
+					ResumeFrom: resumeSlot(sent, forkErr.Data.PreviousBlocks, start),
+				}
+				slog.WarnContext(subCtx, "Fork detected, ending subscription", "subscription", sub.ID, "resumeFrom", notification.ResumeFrom, "error", forkErr)
+				if err := notifier.Notify(sub.ID, notification); err != nil {
+					slog.DebugContext(subCtx, "Failed to notify subscription", "subscription", sub.ID, "error", err)
+				}
+				return
+			} else if err != nil {
+				slog.WarnContext(subCtx, "Failed to get filtered blocks for subscription", "subscription", sub.ID, "from", next, "error", err)
+			} else if res != nil {
+				if err := notifier.Notify(sub.ID, res); err != nil {
+					slog.DebugContext(subCtx, "Failed to notify subscription", "subscription", sub.ID, "error", err)
+					return
+				}
+				s.quotas.Record(subCtx, len(res.Blocks))
+				sent = append(sent, refs...)
+				sent = sent[max(0, len(sent)-SUBSCRIPTION_TRACKED_BLOCKS):]
+				last := refs[len(refs)-1]
+				next = last.Number + 1
+				blockReq.ParentHash = &last.Hash
+			}
+
+			// Catch up to the head without waiting
+			if behind {
+				select {
+				case <-sub.Err():
+					return
+				default:
+					continue
+				}
+			}
+
+			select {
+			case <-sub.Err():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return sub, nil
+}
+
+// resumeSlot finds the slot to resume from after a fork, the slot after the latest block sent that is in the portal's previous blocks.
+// A matching hash means every earlier block is also canonical
+func resumeSlot(sent, previous []sqd.BlockRef, start uint) uint64 {
+	canonical := map[sqd.BlockRef]bool{}
+	for _, ref := range previous {
+		canonical[ref] = true
+	}
+	for i := len(sent) - 1; i >= 0; i-- {
+		if canonical[sent[i]] {
+			return uint64(sent[i].Number) + 1
+		}
+	}
+	return uint64(start)
+}
+
+// nextFilteredBlocks queries from the next block to the current head, nil is returned if there are no new blocks.
+// refs are the blocks searched in order, the last being the end of the result, and behind is true if the results didn't reach the head
+func (s *SubqlApiService) nextFilteredBlocks(ctx context.Context, blockReq BlockRequest, next uint) (res *BlockResult, refs []sqd.BlockRef, behind bool, err error) {
+	height, err := s.currentHeight(ctx, blockReq.FinalizedOnly)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if next > height {
+		return nil, nil, false, nil
+	}
+
+	meta, err := s.sqdClient.Metadata(ctx)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	blockReq.FromBlock = big.NewInt(int64(next))
+	blockReq.ToBlock = big.NewInt(int64(height))
+
+	req, err := s.buildSQDRequest(ctx, blockReq)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	refs, blocks, warnings, _, err := s.queryBlocks(ctx, req, blockReq, nil)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if len(refs) == 0 {
+		return nil, nil, false, nil
+	}
+
+	// The stream can end before the requested range, resume from the last block returned
+	last := refs[len(refs)-1]
+	return &BlockResult{
+		Blocks: blocks,
+		BlockRange: [2]*big.Int{
+			big.NewInt(int64(next)),
+			big.NewInt(int64(last.Number)),
+		},
+		GenesisHash: meta.ChainId,
+		Warnings:    warnings,
+	}, refs, last.Number < height, nil
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/subquery/solana-takoyaki/backend/sqd"
+	"github.com/subquery/solana-takoyaki/backend/sqd/sqdtest"
+	"github.com/subquery/solana-takoyaki/meta"
+)
+
+// subscribe subscribes to filtered blocks on the portal, notifications are received on the returned channel
+func subscribe(t *testing.T, portal *sqdtest.Portal, req map[string]interface{}) chan json.RawMessage {
+	service, err := NewSubqlApiService(meta.MAINNET, portal.URL, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpcServer := rpc.NewServer()
+	if err := rpcServer.RegisterName("subql", service); err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.DialInProc(rpcServer)
+	t.Cleanup(client.Close)
+
+	notifications := make(chan json.RawMessage, 10)
+	sub, err := client.Subscribe(context.Background(), "subql", notifications, "filteredBlocks", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sub.Unsubscribe)
+	return notifications
+}
+
+func nextNotification(t *testing.T, notifications chan json.RawMessage, out interface{}) {
+	select {
+	case raw := <-notifications:
+		if err := json.Unmarshal(raw, out); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a notification")
+	}
+}
+
+func TestFilteredBlocksForkParentHash(t *testing.T) {
+	portal := sqdtest.NewPortal(t)
+
+	// The portal responds with a conflict as the parent hash isn't on its chain
+	parentHash := "unknown"
+	notifications := subscribe(t, portal, map[string]interface{}{
+		"fromBlock":  hexutil.Uint64(sqdtest.FIRST_SLOT + 1),
+		"parentHash": parentHash,
+	})
+
+	var notification ForkNotification
+	nextNotification(t, notifications, &notification)
+
+	first := sqdtest.Block(t, sqdtest.FIRST_SLOT).Header
+	compareAsJson(t, ForkNotification{
+		Fork: ForkErrorData{
+			ExpectedParentHash: parentHash,
+			PreviousBlocks:     []sqd.BlockRef{{Number: sqdtest.FIRST_SLOT, Hash: first.Hash}},
+		},
+		ResumeFrom: sqdtest.FIRST_SLOT + 1,
+	}, notification, "Fork notification")
+
+	// The subscription ends after the fork
+	select {
+	case raw := <-notifications:
+		t.Errorf("Expected no more notifications, got %s", raw)
+	case <-time.After(2 * SUBSCRIPTION_POLL_INTERVAL):
+	}
+}
+
+func TestFilteredBlocksForkResume(t *testing.T) {
+	portal := sqdtest.NewPortal(t)
+	notifications := subscribe(t, portal, map[string]interface{}{"fromBlock": hexutil.Uint64(sqdtest.FIRST_SLOT)})
+
+	var res BlockResult
+	nextNotification(t, notifications, &res)
+	if res.BlockRange[1].Uint64() != sqdtest.LAST_SLOT {
+		t.Fatalf("Expected blocks up to %v, got %v", sqdtest.LAST_SLOT, res.BlockRange[1])
+	}
+
+	// The last block is replaced and a new block builds on the replacement
+	blocks := sqdtest.Blocks(t)
+	lastHash := blocks[len(blocks)-1].Header.Hash
+	forked := blocks[len(blocks)-1]
+	forked.Header.Hash = "forked"
+	next := forked
+	next.Header.Slot, next.Header.ParentSlot = sqdtest.LAST_SLOT+1, sqdtest.LAST_SLOT
+	next.Header.Hash, next.Header.ParentHash = "next", forked.Header.Hash
+	portal.SetBlocks(append(blocks[:len(blocks)-1], forked, next))
+	portal.SetHead(&sqd.BlockRef{Number: sqdtest.LAST_SLOT + 1, Hash: next.Header.Hash})
+
+	var notification ForkNotification
+	nextNotification(t, notifications, &notification)
+
+	// Only the first and last blocks were searched, the first is the latest that is still canonical
+	if notification.ResumeFrom != sqdtest.FIRST_SLOT+1 {
+		t.Errorf("Expected to resume from %v, got %v", sqdtest.FIRST_SLOT+1, notification.ResumeFrom)
+	}
+	if notification.Fork.ExpectedParentHash != lastHash {
+		t.Errorf("Expected parent hash %v, got %v", lastHash, notification.Fork.ExpectedParentHash)
+	}
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/subquery/solana-takoyaki/tracing"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestFilterBlocksTracing(t *testing.T) {
+	recorder := recordSpans(t)
+
+	service := NewSubqlApiServiceWithBackend(newMemoryBackend(t, 100, 199), nil)
+	rpcServer := rpc.NewServer()
+	if err := rpcServer.RegisterName("subql", service); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(tracing.Handler(rpcServer))
+	defer srv.Close()
+
+	traceId := "4bf92f3577b34da6a3ce929d0e0e4736"
+	body := `{"jsonrpc":"2.0","id":1,"method":"subql_filterBlocks","params":[{"fromBlock":"0x64","toBlock":"0x68","limit":"0xa"}]}`
+	req, _ := http.NewRequest("POST", srv.URL, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", "00-"+traceId+"-00f067aa0ba902b7-01")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	var filterSpan sdktrace.ReadOnlySpan
+	transformSpans := []sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		switch span.Name() {
+		case "FilterBlocks":
+			filterSpan = span
+		case "TransformBlock":
+			transformSpans = append(transformSpans, span)
+		}
+	}
+
+	if filterSpan == nil {
+		t.Fatal("Expected a FilterBlocks span")
+	}
+	// The trace context of the client is propagated through the RPC handler
+	if filterSpan.SpanContext().TraceID().String() != traceId {
+		t.Errorf("Expected trace id %v, got %v", traceId, filterSpan.SpanContext().TraceID())
+	}
+
+	if len(transformSpans) != 5 {
+		t.Fatalf("Expected 5 TransformBlock spans, got %v", len(transformSpans))
+	}
+	for _, span := range transformSpans {
+		if span.Parent().SpanID() != filterSpan.SpanContext().SpanID() {
+			t.Errorf("Expected TransformBlock to be a child of FilterBlocks")
+		}
+	}
+}
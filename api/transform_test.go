@@ -0,0 +1,46 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/subquery/solana-takoyaki/backend/sqd"
+)
+
+func TestFilterBlocksWarnings(t *testing.T) {
+	backend := newMemoryBackend(t, 100, 100)
+	raw := `{
+		"header":{"number":101,"height":101,"hash":"hash101","parentNumber":100,"parentHash":"hash100","timestamp":1700000000},
+		"transactions":[
+			{"transactionIndex":0,"signatures":["sig0"],"accountKeys":["payer"],"fee":"5000","computeUnitsConsumed":"100","loadedAddresses":{"readonly":[],"writable":[]}},
+			{"transactionIndex":1,"signatures":["sig1"],"accountKeys":["payer"],"fee":"invalid","computeUnitsConsumed":"100","loadedAddresses":{"readonly":[],"writable":[]}}
+		]
+	}`
+	var block sqd.SolanaBlockResponse
+	if err := json.Unmarshal([]byte(raw), &block); err != nil {
+		t.Fatal(err)
+	}
+	backend.blocks = append(backend.blocks, block)
+
+	service := NewSubqlApiServiceWithBackend(backend, nil)
+	req := BlockRequest{FromBlock: big.NewInt(100), ToBlock: big.NewInt(101)}
+
+	// Requests fail on the first error by default
+	if _, err := service.FilterBlocks(context.Background(), req); err == nil {
+		t.Error("Expected request to fail")
+	}
+
+	req.Lenient = true
+	res, err := service.FilterBlocks(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Blocks) != 2 || len(res.Blocks[1].Transactions) != 1 {
+		t.Fatalf("Expected the failing transaction to be skipped, got %v", res.Blocks)
+	}
+	if len(res.Warnings) != 1 || res.Warnings[0].Signature != "sig1" || res.Warnings[0].Slot != 101 {
+		t.Errorf("Unexpected warnings %+v", res.Warnings)
+	}
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"context"
+	"encoding/hex"
+	"math/big"
+	"slices"
+	"strings"
+
+	"github.com/mr-tron/base58"
+)
+
+// The default number of blocks returned when the request doesn't specify a limit
+const DEFAULT_LIMIT = 100
+
+// The maximum number of values in a single filter field, e.g. program ids
+const MAX_FILTER_VALUES = 1000
+
+// The portal supports filtering the accounts of the first 16 instruction accounts
+const MAX_ACCOUNT_FILTERS = 16
+
+// Discriminator lengths in bytes supported by the portal
+var SUPPORTED_DISCRIMINATOR_LENGTHS = []int{1, 2, 4, 8}
+
+type rangeErrorData struct {
+	FromBlock   *big.Int `json:"fromBlock"`
+	ToBlock     *big.Int `json:"toBlock,omitempty"`
+	StartHeight uint     `json:"startHeight"`
+	EndHeight   uint     `json:"endHeight"`
+}
+
+type limitErrorData struct {
+	Limit *big.Int `json:"limit"`
+	Max   int      `json:"max"`
+}
+
+type filterErrorData struct {
+	Field string `json:"field"`
+	Size  int    `json:"size"`
+	Max   int    `json:"max"`
+}
+
+type valueErrorData struct {
+	Field string `json:"field"`
+	Value string `json:"value"`
+}
+
+type encodingErrorData struct {
+	Encoding  string   `json:"encoding"`
+	Supported []string `json:"supported"`
+}
+
+// validateRequest sets defaults for optional fields and validates the request against the available blocks
+func (s *SubqlApiService) validateRequest(ctx context.Context, blockReq *BlockRequest) error {
+	if blockReq.FromBlock == nil {
+		return newRequestError(INVALID_PARAMS_ERROR_CODE, valueErrorData{Field: "fromBlock"}, "fromBlock is required")
+	}
+
+	meta, err := s.sqdClient.Metadata(ctx)
+	if err != nil {
+		return err
+	}
+	head, err := s.sqdClient.Head(ctx)
+	if err != nil {
+		return err
+	}
+
+	if blockReq.ToBlock == nil {
+		blockReq.ToBlock = big.NewInt(int64(head.Number))
+	}
+
+	rangeData := rangeErrorData{blockReq.FromBlock, blockReq.ToBlock, meta.StartBlock, head.Number}
+	if blockReq.FromBlock.Sign() < 0 || blockReq.ToBlock.Sign() < 0 {
+		return newRequestError(INVALID_RANGE_ERROR_CODE, rangeData, "Block numbers must be positive")
+	}
+	if blockReq.FromBlock.Cmp(blockReq.ToBlock) > 0 {
+		return newRequestError(INVALID_RANGE_ERROR_CODE, rangeData, "fromBlock %v must be less than or equal to toBlock %v", blockReq.FromBlock, blockReq.ToBlock)
+	}
+	if blockReq.FromBlock.Uint64() < uint64(meta.StartBlock) || blockReq.FromBlock.Uint64() > uint64(head.Number) {
+		return newRequestError(INVALID_RANGE_ERROR_CODE, rangeData, "fromBlock %v is outside of the available blocks %d-%d", blockReq.FromBlock, meta.StartBlock, head.Number)
+	}
+
+	return s.validateOptions(blockReq)
+}
+
+// validateOptions sets defaults and validates the limit, filters and encoding of a request.
+// Limits larger than the maximum blocks per response are truncated
+func (s *SubqlApiService) validateOptions(blockReq *BlockRequest) error {
+	if blockReq.Limit == nil {
+		blockReq.Limit = big.NewInt(DEFAULT_LIMIT)
+	}
+	if blockReq.Limit.Sign() <= 0 {
+		return newRequestError(INVALID_LIMIT_ERROR_CODE, limitErrorData{blockReq.Limit, s.limits.MaxBlocks}, "Limit must be between 1 and %d", s.limits.MaxBlocks)
+	}
+	if blockReq.Limit.Cmp(big.NewInt(int64(s.limits.MaxBlocks))) > 0 {
+		blockReq.Limit = big.NewInt(int64(s.limits.MaxBlocks))
+	}
+
+	if blockReq.Encoding != "" && !slices.Contains(SUPPORTED_ENCODINGS, blockReq.Encoding) {
+		return newRequestError(UNSUPPORTED_ENCODING_ERROR_CODE, encodingErrorData{blockReq.Encoding, SUPPORTED_ENCODINGS}, "Unsupported encoding: %v. supported encodings: %v", blockReq.Encoding, SUPPORTED_ENCODINGS)
+	}
+
+	if blockReq.BlockFilter == nil {
+		blockReq.BlockFilter = &BlockFilter{}
+	}
+	return validateBlockFilter(blockReq.BlockFilter, s.limits.MaxFilters)
+}
+
+func validateBlockFilter(filter *BlockFilter, maxFilters int) error {
+	if err := validateSize("transactions", len(filter.Transactions), maxFilters); err != nil {
+		return err
+	}
+	for _, tx := range filter.Transactions {
+		if err := validatePubkeys("transactions.signerAccountKeys", tx.SignerAccountKeys); err != nil {
+			return err
+		}
+	}
+
+	if err := validateSize("instructions", len(filter.Instructions), maxFilters); err != nil {
+		return err
+	}
+	for _, inst := range filter.Instructions {
+		if err := validatePubkeys("instructions.programIds", inst.ProgramIds); err != nil {
+			return err
+		}
+		if err := validateSize("instructions.accounts", len(inst.Accounts), MAX_ACCOUNT_FILTERS); err != nil {
+			return err
+		}
+		for _, accounts := range inst.Accounts {
+			if err := validatePubkeys("instructions.accounts", accounts); err != nil {
+				return err
+			}
+		}
+		if err := validateDiscriminators(inst.Discriminators); err != nil {
+			return err
+		}
+	}
+
+	if err := validateSize("logs", len(filter.Logs), maxFilters); err != nil {
+		return err
+	}
+	for _, log := range filter.Logs {
+		if err := validatePubkeys("logs.programIds", log.ProgramIds); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateSize(field string, size int, max int) error {
+	if size > max {
+		return newRequestError(FILTER_TOO_LARGE_ERROR_CODE, filterErrorData{field, size, max}, "Too many %s filters: %d, the maximum is %d", field, size, max)
+	}
+	return nil
+}
+
+func validatePubkeys(field string, keys []string) error {
+	if err := validateSize(field, len(keys), MAX_FILTER_VALUES); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		decoded, err := base58.Decode(key)
+		if err != nil || len(decoded) != 32 {
+			return newRequestError(INVALID_PUBKEY_ERROR_CODE, valueErrorData{field, key}, "Invalid public key in %s: %s", field, key)
+		}
+	}
+	return nil
+}
+
+func validateDiscriminators(discriminators []string) error {
+	if err := validateSize("instructions.discriminators", len(discriminators), MAX_FILTER_VALUES); err != nil {
+		return err
+	}
+	for _, d := range discriminators {
+		decoded, err := hex.DecodeString(strings.TrimPrefix(d, "0x"))
+		if err != nil {
+			return newRequestError(INVALID_DISCRIMINATOR_ERROR_CODE, valueErrorData{"instructions.discriminators", d}, "Invalid discriminator %s: %v", d, err)
+		}
+		if !slices.Contains(SUPPORTED_DISCRIMINATOR_LENGTHS, len(decoded)) {
+			return newRequestError(INVALID_DISCRIMINATOR_ERROR_CODE, valueErrorData{"instructions.discriminators", d}, "Invalid discriminator length: %v. supported lengths: %v bytes", len(decoded), SUPPORTED_DISCRIMINATOR_LENGTHS)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/subquery/solana-takoyaki/meta"
+)
+
+// Ensure errors include codes and data in JSON-RPC responses
+var _ rpc.Error = (*RequestError)(nil)
+var _ rpc.DataError = (*RequestError)(nil)
+var _ rpc.DataError = (*ForkError)(nil)
+
+func TestValidateRequest(t *testing.T) {
+	portal := newStatusPortal(0, &atomic.Int32{})
+	defer portal.Close()
+
+	service, err := NewSubqlApiService(meta.MAINNET, portal.URL, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Available blocks are 317617480-327347700
+	tests := []struct {
+		name string
+		req  BlockRequest
+		code int // 0 means valid
+	}{
+		{"defaults", BlockRequest{FromBlock: big.NewInt(327347682)}, 0},
+		{"missing from", BlockRequest{}, INVALID_PARAMS_ERROR_CODE},
+		{"from after to", BlockRequest{FromBlock: big.NewInt(327347682), ToBlock: big.NewInt(327347600)}, INVALID_RANGE_ERROR_CODE},
+		{"before start", BlockRequest{FromBlock: big.NewInt(100)}, INVALID_RANGE_ERROR_CODE},
+		{"after head", BlockRequest{FromBlock: big.NewInt(327347701), ToBlock: big.NewInt(327347800)}, INVALID_RANGE_ERROR_CODE},
+		{"zero limit", BlockRequest{FromBlock: big.NewInt(327347682), Limit: big.NewInt(0)}, INVALID_LIMIT_ERROR_CODE},
+		{"negative limit", BlockRequest{FromBlock: big.NewInt(327347682), Limit: big.NewInt(-1)}, INVALID_LIMIT_ERROR_CODE},
+		{"encoding", BlockRequest{FromBlock: big.NewInt(327347682), Encoding: "base58"}, UNSUPPORTED_ENCODING_ERROR_CODE},
+		{"valid filter", BlockRequest{FromBlock: big.NewInt(327347682), BlockFilter: &BlockFilter{
+			Instructions: []InstFilterQuery{{
+				ProgramIds:     []string{"JUP6LkbZbjS1jKKwapdHNy74zcZ3tLUZoi5QNyVTaV4"},
+				Accounts:       [][]string{{}, {"EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v"}},
+				Discriminators: []string{"0xe517cb977ae3ad2a", "02"},
+			}},
+		}}, 0},
+		{"invalid pubkey", BlockRequest{FromBlock: big.NewInt(327347682), BlockFilter: &BlockFilter{
+			Transactions: []TxFilterQuery{{SignerAccountKeys: []string{"not-a-key"}}},
+		}}, INVALID_PUBKEY_ERROR_CODE},
+		{"short pubkey", BlockRequest{FromBlock: big.NewInt(327347682), BlockFilter: &BlockFilter{
+			Logs: []LogFilterQuery{{ProgramIds: []string{"1111"}}},
+		}}, INVALID_PUBKEY_ERROR_CODE},
+		{"discriminator length", BlockRequest{FromBlock: big.NewInt(327347682), BlockFilter: &BlockFilter{
+			Instructions: []InstFilterQuery{{Discriminators: []string{"0x010203"}}},
+		}}, INVALID_DISCRIMINATOR_ERROR_CODE},
+		{"discriminator hex", BlockRequest{FromBlock: big.NewInt(327347682), BlockFilter: &BlockFilter{
+			Instructions: []InstFilterQuery{{Discriminators: []string{"0xzz"}}},
+		}}, INVALID_DISCRIMINATOR_ERROR_CODE},
+		{"too many accounts", BlockRequest{FromBlock: big.NewInt(327347682), BlockFilter: &BlockFilter{
+			Instructions: []InstFilterQuery{{Accounts: make([][]string, MAX_ACCOUNT_FILTERS+1)}},
+		}}, FILTER_TOO_LARGE_ERROR_CODE},
+		{"too many filters", BlockRequest{FromBlock: big.NewInt(327347682), BlockFilter: &BlockFilter{
+			Logs: make([]LogFilterQuery, DEFAULT_LIMITS.MaxFilters+1),
+		}}, FILTER_TOO_LARGE_ERROR_CODE},
+	}
+
+	for _, test := range tests {
+		req := test.req
+		err := service.validateRequest(context.Background(), &req)
+		if test.code == 0 {
+			if err != nil {
+				t.Errorf("%v: unexpected error %v", test.name, err)
+			}
+			continue
+		}
+
+		var reqErr *RequestError
+		if !errors.As(err, &reqErr) {
+			t.Errorf("%v: expected request error, got %v", test.name, err)
+			continue
+		}
+		if reqErr.ErrorCode() != test.code {
+			t.Errorf("%v: expected code %v, got %v (%v)", test.name, test.code, reqErr.ErrorCode(), reqErr)
+		}
+	}
+}
+
+func TestValidateDefaults(t *testing.T) {
+	service := NewSubqlApiServiceWithBackend(nil, nil)
+
+	req := BlockRequest{FromBlock: big.NewInt(1)}
+	if err := service.validateOptions(&req); err != nil {
+		t.Fatal(err)
+	}
+
+	if req.Limit.Int64() != DEFAULT_LIMIT {
+		t.Errorf("Expected default limit %v, got %v", DEFAULT_LIMIT, req.Limit)
+	}
+	if req.BlockFilter == nil {
+		t.Errorf("Expected empty block filter")
+	}
+
+	// Large limits are truncated
+	req.Limit = big.NewInt(int64(DEFAULT_LIMITS.MaxBlocks + 1))
+	if err := service.validateOptions(&req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Limit.Int64() != int64(DEFAULT_LIMITS.MaxBlocks) {
+		t.Errorf("Expected limit to be truncated to %v, got %v", DEFAULT_LIMITS.MaxBlocks, req.Limit)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/subquery/solana-takoyaki/backend/archive"
+	"github.com/subquery/solana-takoyaki/backend/sqd"
+	"github.com/subquery/solana-takoyaki/config"
+)
+
+// runArchive pulls finalized blocks from the portal into a local archive, usage: `takoyaki archive -dir ./archive -from 327000000`
+func runArchive(args []string) {
+	flags := flag.NewFlagSet("archive", flag.ExitOnError)
+	dir := flags.String("dir", "", "Directory to write the archive to")
+	from := flags.Uint("from", 0, "First slot to archive, defaults to the dataset start block")
+	to := flags.Uint("to", 0, "Last slot to archive, defaults to the finalized head")
+	chunkSize := flags.Uint("chunkSize", archive.DEFAULT_CHUNK_SIZE, "Number of slots per archive file")
+	sqdEndpoint := flags.String("sqdEndpoint", config.DEFAULT_SQD_ENDPOINT, "SQD portal dataset url")
+
+	flags.Parse(args)
+
+	if *dir == "" {
+		fatal("An archive directory is required", nil)
+	}
+
+	err := archive.Pull(context.Background(), sqd.NewSoldexerClient(*sqdEndpoint), *dir, *from, *to, *chunkSize)
+	if err != nil {
+		fatal("Error archiving blocks", err)
+	}
+}
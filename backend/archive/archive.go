@@ -0,0 +1,210 @@
+package archive
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/subquery/solana-takoyaki/backend/sqd"
+)
+
+// Archives are a directory of zstd compressed NDJSON files of portal blocks, partitioned by slot range
+// along with the portal metadata at the time of archiving.
+
+const METADATA_FILE = "metadata.json"
+
+var chunkFileRegex = regexp.MustCompile(`^(\d+)-(\d+)\.ndjson\.zst$`)
+
+type chunk struct {
+	from uint
+	to   uint
+	path string
+}
+
+func chunkFileName(from, to uint) string {
+	return fmt.Sprintf("%012d-%012d.ndjson.zst", from, to)
+}
+
+// listChunks returns the chunk files in the directory ordered by slot
+func listChunks(dir string) ([]chunk, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := []chunk{}
+	for _, entry := range entries {
+		matches := chunkFileRegex.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			continue
+		}
+		from, _ := strconv.ParseUint(matches[1], 10, 64)
+		to, _ := strconv.ParseUint(matches[2], 10, 64)
+		chunks = append(chunks, chunk{uint(from), uint(to), filepath.Join(dir, entry.Name())})
+	}
+
+	slices.SortFunc(chunks, func(a, b chunk) int {
+		return int(a.from) - int(b.from)
+	})
+
+	return chunks, nil
+}
+
+// readChunk calls fn with each block in the chunk until it returns false
+func readChunk(c chunk, fn func(block sqd.SolanaBlockResponse) bool) error {
+	f, err := os.Open(c.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(zr))
+	for {
+		var block sqd.SolanaBlockResponse
+		if err := dec.Decode(&block); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("Failed to read block from %s: %w", c.path, err)
+		}
+		if !fn(block) {
+			return nil
+		}
+	}
+}
+
+// Archive serves blocks from a local archive, it implements sqd.Backend.
+// Archives only contain finalized blocks so the latest and finalized heads are the same.
+// The chunks are listed when opened, new chunks require reopening the archive
+type Archive struct {
+	dir    string
+	meta   *sqd.NetworkMeta
+	chunks []chunk
+	head   *sqd.BlockRef
+}
+
+func Open(dir string) (*Archive, error) {
+	rawMeta, err := os.ReadFile(filepath.Join(dir, METADATA_FILE))
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &sqd.NetworkMeta{}
+	if err := json.Unmarshal(rawMeta, meta); err != nil {
+		return nil, fmt.Errorf("Failed to parse archive metadata: %w", err)
+	}
+
+	chunks, err := listChunks(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("Archive %s has no blocks", dir)
+	}
+	for i := 1; i < len(chunks); i++ {
+		if chunks[i].from <= chunks[i-1].to {
+			return nil, fmt.Errorf("Archive %s has overlapping chunks %s and %s", dir, filepath.Base(chunks[i-1].path), filepath.Base(chunks[i].path))
+		}
+	}
+
+	var head *sqd.BlockRef
+	err = readChunk(chunks[len(chunks)-1], func(block sqd.SolanaBlockResponse) bool {
+		head = &sqd.BlockRef{Number: uint(block.Header.Slot), Hash: block.Header.Hash}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if head == nil {
+		return nil, fmt.Errorf("Archive %s has no blocks", dir)
+	}
+
+	return &Archive{dir, meta, chunks, head}, nil
+}
+
+func (a *Archive) Head(ctx context.Context) (*sqd.BlockRef, error) {
+	return a.head, nil
+}
+
+func (a *Archive) FinalizedHead(ctx context.Context) (*sqd.BlockRef, error) {
+	return a.head, nil
+}
+
+func (a *Archive) Metadata(ctx context.Context) (*sqd.NetworkMeta, error) {
+	return a.meta, nil
+}
+
+// Query evaluates the request against the archived blocks.
+// Like the portal the first and last blocks in the range are always returned even if they don't match
+func (a *Archive) Query(ctx context.Context, solReq sqd.SolanaRequest, limit *int) ([]sqd.SolanaBlockResponse, error) {
+	res := []sqd.SolanaBlockResponse{}
+
+	// The last block in the range, only added if it wasn't already included
+	var last *sqd.SolanaBlockResponse
+	done := false
+
+	for _, c := range a.chunks {
+		if c.to < solReq.FromBlock || c.from > solReq.ToBlock {
+			continue
+		}
+
+		err := readChunk(c, func(block sqd.SolanaBlockResponse) bool {
+			if ctx.Err() != nil {
+				return false
+			}
+
+			slot := uint(block.Header.Slot)
+			if slot < solReq.FromBlock {
+				return true
+			}
+			if slot > solReq.ToBlock {
+				done = true
+				return false
+			}
+
+			filtered, ok := sqd.FilterBlock(block, solReq)
+			if ok || len(res) == 0 {
+				res = append(res, filtered)
+				last = nil
+			} else {
+				last = &filtered
+			}
+
+			if limit != nil && len(res) >= *limit {
+				done = true
+				last = nil
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if done {
+			break
+		}
+	}
+
+	if last != nil {
+		res = append(res, *last)
+	}
+
+	return res, nil
+}
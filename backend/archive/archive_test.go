@@ -0,0 +1,206 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/subquery/solana-takoyaki/backend/sqd"
+)
+
+const PROGRAM_ID = "JUP6LkbZbjS1jKKwapdHNy74zcZ3tLUZoi5QNyVTaV4"
+
+// memoryBackend serves blocks from memory, returning at most 2 blocks per query like a portal ending a stream early
+type memoryBackend struct {
+	blocks []sqd.SolanaBlockResponse
+}
+
+func (m *memoryBackend) Query(ctx context.Context, solReq sqd.SolanaRequest, limit *int) ([]sqd.SolanaBlockResponse, error) {
+	res := []sqd.SolanaBlockResponse{}
+	for _, block := range m.blocks {
+		if uint(block.Header.Slot) >= solReq.FromBlock && uint(block.Header.Slot) <= solReq.ToBlock && len(res) < 2 {
+			res = append(res, block)
+		}
+	}
+	return res, nil
+}
+
+func (m *memoryBackend) Head(ctx context.Context) (*sqd.BlockRef, error) {
+	last := m.blocks[len(m.blocks)-1].Header
+	return &sqd.BlockRef{Number: uint(last.Slot), Hash: last.Hash}, nil
+}
+
+func (m *memoryBackend) FinalizedHead(ctx context.Context) (*sqd.BlockRef, error) {
+	return m.Head(ctx)
+}
+
+func (m *memoryBackend) Metadata(ctx context.Context) (*sqd.NetworkMeta, error) {
+	return &sqd.NetworkMeta{ChainId: "solana-mainnet", Dataset: "solana-mainnet", StartBlock: 100}, nil
+}
+
+// testBlocks creates blocks for slots 100 to 109, even slots contain an instruction for PROGRAM_ID
+func testBlocks(t *testing.T) []sqd.SolanaBlockResponse {
+	blocks := []sqd.SolanaBlockResponse{}
+	for slot := 100; slot < 110; slot++ {
+		programId := "11111111111111111111111111111111"
+		if slot%2 == 0 {
+			programId = PROGRAM_ID
+		}
+		raw := fmt.Sprintf(`{
+			"header":{"number":%d,"height":%d,"hash":"hash%d","parentNumber":%d,"parentHash":"hash%d","timestamp":1700000000},
+			"transactions":[{"transactionIndex":0,"signatures":["sig%d"],"accountKeys":["payer",%q]}],
+			"instructions":[{"transactionIndex":0,"instructionAddress":[0],"programId":%q,"accounts":[],"data":"3Bxs4h24hBtQy9rw"}],
+			"logs":[],"balances":[],"tokenBalances":[],"rewards":[]
+		}`, slot, slot-10, slot, slot-1, slot-1, slot, programId, programId)
+
+		var block sqd.SolanaBlockResponse
+		if err := json.Unmarshal([]byte(raw), &block); err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+func TestPullAndQuery(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	if err := Pull(ctx, &memoryBackend{testBlocks(t)}, dir, 0, 0, 4); err != nil {
+		t.Fatalf("Failed to pull: %v", err)
+	}
+
+	chunks, err := listChunks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 3 || chunks[0].from != 100 || chunks[2].to != 109 {
+		t.Fatalf("Unexpected chunks %+v", chunks)
+	}
+
+	archive, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+
+	head, _ := archive.Head(ctx)
+	if head.Number != 109 || head.Hash != "hash109" {
+		t.Errorf("Unexpected head %+v", head)
+	}
+
+	res, err := archive.Query(ctx, sqd.SolanaRequest{
+		FromBlock:    101,
+		ToBlock:      107,
+		Instructions: []sqd.InstructionRequest{{ProgramId: []string{PROGRAM_ID}, Transaction: true}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to query: %v", err)
+	}
+
+	// The first and last blocks are always included
+	slots := []uint64{}
+	for _, block := range res {
+		slots = append(slots, block.Header.Slot)
+	}
+	if fmt.Sprint(slots) != "[101 102 104 106 107]" {
+		t.Fatalf("Unexpected slots %v", slots)
+	}
+	if len(res[0].Instructions) != 0 || len(res[1].Instructions) != 1 || len(res[1].Transactions) != 1 {
+		t.Errorf("Unexpected items in block %+v", res[1])
+	}
+
+	limit := 2
+	res, err = archive.Query(ctx, sqd.SolanaRequest{
+		FromBlock:    100,
+		ToBlock:      109,
+		Instructions: []sqd.InstructionRequest{{ProgramId: []string{PROGRAM_ID}}},
+	}, &limit)
+	if err != nil {
+		t.Fatalf("Failed to query: %v", err)
+	}
+	if len(res) != 2 || res[1].Header.Slot != 102 {
+		t.Errorf("Expected limit to be applied, got %v blocks", len(res))
+	}
+}
+
+func TestPullResume(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	backend := &memoryBackend{testBlocks(t)}
+
+	// Archive a partial chunk then extend it
+	if err := Pull(ctx, backend, dir, 100, 105, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := Pull(ctx, backend, dir, 100, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	chunks, err := listChunks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 1 || chunks[0].from != 100 || chunks[0].to != 109 {
+		t.Fatalf("Expected partial chunk to be replaced, got %+v", chunks)
+	}
+}
+
+// A pull starting inside an archived chunk merges it rather than archiving the overlap twice
+func TestPullOverlap(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	backend := &memoryBackend{testBlocks(t)}
+
+	if err := Pull(ctx, backend, dir, 100, 105, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := Pull(ctx, backend, dir, 103, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	chunks, err := listChunks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 1 || chunks[0].from != 100 || chunks[0].to != 109 {
+		t.Fatalf("Expected the chunks to be merged, got %+v", chunks)
+	}
+
+	archive, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	res, err := archive.Query(ctx, sqd.FullBlockRequest(100, 109), nil)
+	if err != nil {
+		t.Fatalf("Failed to query: %v", err)
+	}
+	slots := []uint64{}
+	for _, block := range res {
+		slots = append(slots, block.Header.Slot)
+	}
+	if fmt.Sprint(slots) != "[100 101 102 103 104 105 106 107 108 109]" {
+		t.Errorf("Unexpected slots %v", slots)
+	}
+}
+
+func TestOpenOverlap(t *testing.T) {
+	dir := t.TempDir()
+	if err := Pull(context.Background(), &memoryBackend{testBlocks(t)}, dir, 100, 105, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, chunkFileName(100, 105)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, chunkFileName(103, 109)), raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(dir); err == nil {
+		t.Error("Expected overlapping chunks to be rejected")
+	}
+}
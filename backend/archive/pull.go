@@ -0,0 +1,163 @@
+package archive
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/subquery/solana-takoyaki/backend/sqd"
+)
+
+// The default number of slots per archive file
+const DEFAULT_CHUNK_SIZE = 10_000
+
+// Pull archives the finalized blocks between from and to (inclusive) from the backend into dir.
+// If to is 0 or after the finalized head, blocks are archived up to the finalized head.
+// Chunks that are already archived are skipped so an interrupted pull can be resumed.
+func Pull(ctx context.Context, backend sqd.Backend, dir string, from, to, chunkSize uint) error {
+	if chunkSize == 0 {
+		return fmt.Errorf("Chunk size must be greater than 0")
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	meta, err := backend.Metadata(ctx)
+	if err != nil {
+		return err
+	}
+	if err := writeMetadata(dir, meta); err != nil {
+		return err
+	}
+
+	finalized, err := backend.FinalizedHead(ctx)
+	if err != nil {
+		return err
+	}
+	if to == 0 || to > finalized.Number {
+		to = finalized.Number
+	}
+	from = max(from, meta.StartBlock)
+	if from > to {
+		return fmt.Errorf("Nothing to archive, from %d is after to %d", from, to)
+	}
+
+	for chunkStart := from - from%chunkSize; chunkStart <= to; chunkStart += chunkSize {
+		start := max(chunkStart, from)
+		end := min(chunkStart+chunkSize-1, to)
+
+		existing, err := listChunks(dir)
+		if err != nil {
+			return err
+		}
+		if covered(existing, start, end) {
+			slog.Debug("Chunk already archived", "from", start, "to", end)
+			continue
+		}
+
+		// Chunks can't overlap, partially archived chunks are merged into the new one
+		start, end = widen(existing, start, end)
+
+		slog.Info("Archiving chunk", "from", start, "to", end)
+		if err := pullChunk(ctx, backend, dir, start, end); err != nil {
+			return err
+		}
+
+		// Remove partial chunks that have been replaced
+		for _, c := range existing {
+			if c.from >= start && c.to <= end && !(c.from == start && c.to == end) {
+				if err := os.Remove(c.path); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func covered(chunks []chunk, from, to uint) bool {
+	for _, c := range chunks {
+		if c.from <= from && c.to >= to {
+			return true
+		}
+	}
+	return false
+}
+
+// widen extends the range until it includes every chunk that overlaps it
+func widen(chunks []chunk, from, to uint) (uint, uint) {
+	for changed := true; changed; {
+		changed = false
+		for _, c := range chunks {
+			if c.from <= to && c.to >= from && (c.from < from || c.to > to) {
+				from, to = min(from, c.from), max(to, c.to)
+				changed = true
+			}
+		}
+	}
+	return from, to
+}
+
+func writeMetadata(dir string, meta *sqd.NetworkMeta) error {
+	raw, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, METADATA_FILE), raw, 0o644)
+}
+
+// pullChunk writes all blocks from start to end to a single chunk file
+func pullChunk(ctx context.Context, backend sqd.Backend, dir string, start, end uint) error {
+	// Write to a temp file first so partial chunks are never read
+	tmp, err := os.CreateTemp(dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	zw, err := zstd.NewWriter(tmp)
+	if err != nil {
+		return err
+	}
+	buf := bufio.NewWriter(zw)
+	enc := json.NewEncoder(buf)
+
+	// The portal can end the stream before the end of the range, continue from the last block
+	next := start
+	for next <= end {
+		blocks, err := backend.Query(ctx, sqd.FullBlockRequest(next, end), nil)
+		if err != nil {
+			return err
+		}
+		if len(blocks) == 0 {
+			break
+		}
+
+		for _, block := range blocks {
+			if err := enc.Encode(block); err != nil {
+				return err
+			}
+		}
+		next = uint(blocks[len(blocks)-1].Header.Slot) + 1
+	}
+
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(dir, chunkFileName(start, end)))
+}
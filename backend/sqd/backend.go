@@ -0,0 +1,66 @@
+package sqd
+
+import (
+	"context"
+	"fmt"
+)
+
+// Backend provides blocks in the portal format, it is implemented by the portal client and local archives
+type Backend interface {
+	Query(ctx context.Context, solReq SolanaRequest, limit *int) ([]SolanaBlockResponse, error)
+	// Head returns the latest block available, this block could still be reorged
+	Head(ctx context.Context) (*BlockRef, error)
+	FinalizedHead(ctx context.Context) (*BlockRef, error)
+	Metadata(ctx context.Context) (*NetworkMeta, error)
+}
+
+// Streamer is implemented by backends that can pass on blocks as they are read rather than once the whole response is read
+type Streamer interface {
+	QueryStream(ctx context.Context, solReq SolanaRequest, limit *int, onBlock func(SolanaBlockResponse) error) error
+}
+
+// QueryStream passes the blocks of the query to onBlock in order, as they are read if the backend is a Streamer.
+// An error from onBlock stops the query and is returned
+func QueryStream(ctx context.Context, backend Backend, solReq SolanaRequest, limit *int, onBlock func(SolanaBlockResponse) error) error {
+	if streamer, ok := backend.(Streamer); ok {
+		return streamer.QueryStream(ctx, solReq, limit, onBlock)
+	}
+
+	blocks, err := backend.Query(ctx, solReq, limit)
+	if err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		if err := onBlock(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BlockTime returns the unix timestamp of the block at the given slot
+func BlockTime(ctx context.Context, backend Backend, number uint) (int64, error) {
+	limit := 1
+	res, err := backend.Query(ctx, SolanaRequest{
+		Type:      "solana",
+		FromBlock: number,
+		ToBlock:   number,
+		Fields: Fields{
+			Block: map[string]bool{
+				"number":    true,
+				"height":    true,
+				"hash":      true,
+				"timestamp": true,
+			},
+		},
+	}, &limit)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(res) == 0 {
+		return 0, fmt.Errorf("Block %d not found", number)
+	}
+
+	return res[0].Header.Timestamp, nil
+}
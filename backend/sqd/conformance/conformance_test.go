@@ -0,0 +1,372 @@
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/subquery/solana-takoyaki/backend/sqd"
+	"github.com/subquery/solana-takoyaki/backend/sqd/sqdtest"
+	"github.com/subquery/solana-takoyaki/programs"
+)
+
+// Pairs built by hand from the sqdtest fixtures rather than recorded, so they only show the transformer matches our reading of the RPC format.
+// They are modelled on mainnet blocks, with v0 transactions using lookup tables, a failed transaction, Token-2022 balances, vote transactions and rewards
+const SYNTHETIC_TESTDATA = "testdata/synthetic"
+
+// Pairs recorded from a portal and an RPC node with `takoyaki conformance -sqdEndpoint <url> -rpcEndpoint <url> -slots <slots>`
+const RECORDED_TESTDATA = "testdata/recorded"
+
+// The cases the recorded pairs need to cover between them
+var RECORDED_CASES = map[string]func(block recordedBlock) bool{
+	"v0 transaction with lookup tables": func(block recordedBlock) bool {
+		return slices.ContainsFunc(block.Transactions, func(tx recordedTransaction) bool {
+			return string(tx.Version) == "0" && len(tx.Transaction.Message.AddressTableLookups) > 0
+		})
+	},
+	"failed transaction": func(block recordedBlock) bool {
+		return slices.ContainsFunc(block.Transactions, func(tx recordedTransaction) bool {
+			return tx.Meta.Err != nil && string(tx.Meta.Err) != "null"
+		})
+	},
+	"Token-2022 balance": func(block recordedBlock) bool {
+		return slices.ContainsFunc(block.Transactions, func(tx recordedTransaction) bool {
+			return slices.ContainsFunc(slices.Concat(tx.Meta.PreTokenBalances, tx.Meta.PostTokenBalances), func(b recordedTokenBalance) bool {
+				return b.ProgramId == programs.TOKEN_2022_PROGRAM_ID
+			})
+		})
+	},
+	"rewards": func(block recordedBlock) bool {
+		return len(block.Rewards) > 0
+	},
+	"more than one inner instruction group": func(block recordedBlock) bool {
+		return slices.ContainsFunc(block.Transactions, func(tx recordedTransaction) bool {
+			return len(tx.Meta.InnerInstructions) > 1
+		})
+	},
+}
+
+// The parts of a getBlock result RECORDED_CASES look at
+type recordedBlock struct {
+	Rewards      []json.RawMessage     `json:"rewards"`
+	Transactions []recordedTransaction `json:"transactions"`
+}
+
+type recordedTransaction struct {
+	Version     json.RawMessage `json:"version"`
+	Transaction struct {
+		Message struct {
+			AddressTableLookups []json.RawMessage `json:"addressTableLookups"`
+		} `json:"message"`
+	} `json:"transaction"`
+	Meta struct {
+		Err               json.RawMessage        `json:"err"`
+		InnerInstructions []json.RawMessage      `json:"innerInstructions"`
+		PreTokenBalances  []recordedTokenBalance `json:"preTokenBalances"`
+		PostTokenBalances []recordedTokenBalance `json:"postTokenBalances"`
+	} `json:"meta"`
+}
+
+type recordedTokenBalance struct {
+	ProgramId string `json:"programId"`
+}
+
+func TestConformance(t *testing.T) {
+	for _, dir := range []string{SYNTHETIC_TESTDATA, RECORDED_TESTDATA} {
+		pairs, err := LoadPairs(dir)
+		if os.IsNotExist(err) && dir == RECORDED_TESTDATA {
+			t.Fatalf("No recorded pairs in %v, record them with `takoyaki conformance -rpcEndpoint <url> -slots <slots>`", dir)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pairs) == 0 {
+			t.Fatalf("No conformance pairs in %v", dir)
+		}
+		if dir == RECORDED_TESTDATA {
+			checkRecordedCases(t, pairs)
+		}
+
+		for _, pair := range pairs {
+			t.Run(filepath.Base(dir)+"/"+pair.Name, func(t *testing.T) {
+				diffs, err := pair.Diff()
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, diff := range diffs {
+					t.Error(diff)
+				}
+			})
+		}
+	}
+}
+
+// checkRecordedCases checks every case in RECORDED_CASES is in at least one of the recorded pairs
+func checkRecordedCases(t *testing.T, pairs []Pair) {
+	blocks := []recordedBlock{}
+	for _, pair := range pairs {
+		var block recordedBlock
+		if err := json.Unmarshal(pair.RPC, &block); err != nil {
+			t.Fatalf("Invalid getBlock result in %v: %v", pair.Name, err)
+		}
+		blocks = append(blocks, block)
+	}
+
+	for name, covered := range RECORDED_CASES {
+		if !slices.ContainsFunc(blocks, covered) {
+			t.Errorf("No recorded pair has a %v, record a slot with one", name)
+		}
+	}
+}
+
+// mutateRPC changes the getBlock result of a pair
+func mutateRPC(t *testing.T, pair Pair, fn func(block map[string]interface{})) Pair {
+	var block map[string]interface{}
+	if err := decode(pair.RPC, &block); err != nil {
+		t.Fatal(err)
+	}
+	fn(block)
+
+	raw, err := json.Marshal(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair.RPC = raw
+	return pair
+}
+
+func rpcTransaction(block map[string]interface{}, sig string) map[string]interface{} {
+	for _, tx := range block["transactions"].([]interface{}) {
+		tx := tx.(map[string]interface{})
+		if signature(tx) == sig {
+			return tx
+		}
+	}
+	return nil
+}
+
+func TestDiff(t *testing.T) {
+	pairs, err := LoadPairs(SYNTHETIC_TESTDATA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair := pairs[0]
+
+	// The swap in the first block
+	const swap = "3rv9JYwLPLkbsZFobpuFGFBPpnYZzJQtvaxSNCMn9QJEepcfnJ173XCMNhC5xbHAbG4GKytu7AiSYeswT85tCmVN"
+
+	tests := []struct {
+		name   string
+		mutate func(block map[string]interface{})
+		paths  []string // The paths of the expected differences
+	}{
+		{
+			name: "Field",
+			mutate: func(block map[string]interface{}) {
+				rpcTransaction(block, swap)["meta"].(map[string]interface{})["fee"] = json.Number("6000")
+			},
+			paths: []string{"transactions[" + swap + "].meta.fee"},
+		},
+		{
+			name: "Whole float",
+			mutate: func(block map[string]interface{}) {
+				tx := rpcTransaction(block, swap)
+				tx["meta"].(map[string]interface{})["computeUnitsConsumed"] = json.Number("81544.0")
+			},
+		},
+		{
+			name: "Changed balance",
+			mutate: func(block map[string]interface{}) {
+				meta := rpcTransaction(block, swap)["meta"].(map[string]interface{})
+				meta["postBalances"].([]interface{})[0] = json.Number("1")
+			},
+			paths: []string{"transactions[" + swap + "].meta.postBalances[0]"},
+		},
+		{
+			name: "Unchanged balance",
+			mutate: func(block map[string]interface{}) {
+				meta := rpcTransaction(block, swap)["meta"].(map[string]interface{})
+				meta["preBalances"].([]interface{})[1] = json.Number("1")
+				meta["postBalances"].([]interface{})[1] = json.Number("1")
+			},
+		},
+		{
+			name: "Program log",
+			mutate: func(block map[string]interface{}) {
+				meta := rpcTransaction(block, swap)["meta"].(map[string]interface{})
+				meta["logMessages"] = append(meta["logMessages"].([]interface{}), "Program log: Extra")
+			},
+			paths: []string{"transactions[" + swap + "].meta.logMessages"},
+		},
+		{
+			name: "Runtime log",
+			mutate: func(block map[string]interface{}) {
+				meta := rpcTransaction(block, swap)["meta"].(map[string]interface{})
+				meta["logMessages"] = append(meta["logMessages"].([]interface{}), "Program 11111111111111111111111111111111 invoke [1]")
+			},
+		},
+		{
+			name: "Known gap",
+			mutate: func(block map[string]interface{}) {
+				rpcTransaction(block, swap)["meta"].(map[string]interface{})["returnData"] = map[string]interface{}{"programId": "JUP6LkbZbjS1jKKwapdHNy74zcZ3tLUZoi5QNyVTaV4", "data": []interface{}{"", "base64"}}
+			},
+		},
+		{
+			name: "Missing field",
+			mutate: func(block map[string]interface{}) {
+				block["blockTime"] = nil
+				delete(rpcTransaction(block, swap)["meta"].(map[string]interface{}), "loadedAddresses")
+			},
+			paths: []string{"blockTime", "transactions[" + swap + "].meta.loadedAddresses"},
+		},
+		{
+			name: "Missing transaction",
+			mutate: func(block map[string]interface{}) {
+				txs := block["transactions"].([]interface{})
+				extra := map[string]interface{}{"transaction": map[string]interface{}{"signatures": []interface{}{"missing"}}}
+				block["transactions"] = append(txs, extra)
+			},
+			paths: []string{"transactions[missing]"},
+		},
+		{
+			name: "Vote transaction",
+			mutate: func(block map[string]interface{}) {
+				txs := block["transactions"].([]interface{})
+				vote := map[string]interface{}{"transaction": map[string]interface{}{
+					"signatures": []interface{}{"vote"},
+					"message": map[string]interface{}{
+						"accountKeys":  []interface{}{"validator", VOTE_PROGRAM_ID},
+						"instructions": []interface{}{map[string]interface{}{"programIdIndex": json.Number("1")}},
+					},
+				}}
+				block["transactions"] = append(txs, vote)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			diffs, err := mutateRPC(t, pair, test.mutate).Diff()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			paths := []string{}
+			for _, diff := range diffs {
+				paths = append(paths, diff.Path)
+			}
+			if strings.Join(paths, ",") != strings.Join(test.paths, ",") {
+				t.Errorf("Expected differences %v, got %v", test.paths, diffs)
+			}
+		})
+	}
+}
+
+func TestRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	pairs, err := LoadPairs(SYNTHETIC_TESTDATA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Recording the recorded pairs again produces the same pairs
+	fixtures := t.TempDir()
+	for _, pair := range pairs {
+		rawBlock, err := json.Marshal(pair.Block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// getBlock results are recorded from whole JSON-RPC responses
+		rawRes, err := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "result": pair.RPC})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(fixtures, pair.Name+".ndjson"), rawBlock, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(fixtures, pair.Name+".json"), rawRes, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recorded, err := Record(fixtures, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != len(pairs) {
+		t.Fatalf("Expected %v pairs, got %v", len(pairs), len(recorded))
+	}
+
+	reloaded, err := LoadPairs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, pair := range reloaded {
+		if pair.Name != pairs[i].Name || pair.Block.Header.Hash != pairs[i].Block.Header.Hash {
+			t.Errorf("Expected pair %v, got %v", pairs[i].Name, pair.Name)
+		}
+		diffs, err := pair.Diff()
+		if err != nil || len(diffs) != 0 {
+			t.Errorf("Expected the recorded pair %v to match, got %v %v", pair.Name, diffs, err)
+		}
+	}
+}
+
+func TestFetch(t *testing.T) {
+	pairs, err := LoadPairs(SYNTHETIC_TESTDATA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpcBlocks := map[uint64]json.RawMessage{}
+	for _, pair := range pairs {
+		rpcBlocks[pair.Block.Header.Slot] = pair.RPC
+	}
+
+	portal := sqdtest.NewPortal(t)
+	rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Method != "getBlock" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		var slot uint64
+		json.Unmarshal(req.Params[0], &slot)
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "result": rpcBlocks[slot]})
+	}))
+	defer rpc.Close()
+
+	fixtures := t.TempDir()
+	slots := []uint64{sqdtest.FIRST_SLOT, sqdtest.LAST_SLOT}
+	if err := Fetch(context.Background(), sqd.NewSoldexerClient(portal.URL), rpc.URL, slots, fixtures); err != nil {
+		t.Fatal(err)
+	}
+
+	recorded, err := Record(fixtures, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != len(slots) {
+		t.Fatalf("Expected %v pairs, got %v", len(slots), len(recorded))
+	}
+	for _, pair := range recorded {
+		diffs, err := pair.Diff()
+		if err != nil || len(diffs) != 0 {
+			t.Errorf("Expected the fetched pair %v to match, got %v %v", pair.Name, diffs, err)
+		}
+	}
+
+	// Slots the portal doesn't have aren't recorded
+	if err := Fetch(context.Background(), sqd.NewSoldexerClient(portal.URL), rpc.URL, []uint64{sqdtest.LAST_SLOT - 1}, t.TempDir()); err == nil {
+		t.Error("Expected an error for a skipped slot")
+	}
+}
@@ -0,0 +1,329 @@
+// Package conformance compares transformed blocks with the getBlock results of the Solana RPC.
+// Pairs of portal blocks and getBlock results recorded from live endpoints are in testdata/recorded, testdata/synthetic has pairs built by hand.
+// Fields the portal can't provide are expected to differ and aren't compared
+package conformance
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"regexp"
+	"slices"
+
+	"github.com/subquery/solana-takoyaki/solana"
+)
+
+const VOTE_PROGRAM_ID = "Vote111111111111111111111111111111111111111"
+
+// Fields of the getBlock result that the portal doesn't provide, they aren't compared. Paths use [] for any array index
+var KNOWN_GAPS = map[string]string{
+	"transactions[].transaction.message.recentBlockhash": "The portal doesn't provide the recent blockhash",
+	"transactions[].meta.status":                         "Deprecated, the same as err",
+	"transactions[].meta.costUnits":                      "The portal doesn't provide cost units",
+	"transactions[].meta.returnData":                     "The portal doesn't provide return data",
+	"transactions[].meta.logMessages":                    "The portal only provides program logs, they are compared with meta.logs instead",
+}
+
+// Fields that are only in transformed blocks
+var EXTENSIONS = map[string]string{
+	"signatures":                  "Only included by the RPC when transactionDetails is signatures",
+	"transactions[].slot":         "Included for indexing",
+	"transactions[].blockTime":    "Included for indexing",
+	"transactions[].meta.logs":    "Program logs with the program id and kind, compared with meta.logMessages",
+	"transactions[].meta.rewards": "Older RPC versions omit the rewards of transactions",
+}
+
+// Fields that the RPC omits rather than including empty
+var OMITTED_WHEN_EMPTY = map[string]bool{
+	"transactions[].transaction.message.addressTableLookups": true, // Legacy transactions
+}
+
+// Logs written by the runtime rather than programs, the portal doesn't include them
+var runtimeLog = regexp.MustCompile(`^Program \w+ (invoke \[\d+\]|success|failed: .*|consumed \d+ of \d+ compute units)$|^Program return: `)
+
+var arrayIndex = regexp.MustCompile(`\[[^\]]*\]`)
+
+// Difference is a field where a transformed block doesn't match the getBlock result
+type Difference struct {
+	Path     string
+	Expected interface{} // From the RPC, nil if the field is only in the transformed block
+	Got      interface{} // From the transformed block, nil if the field is missing
+}
+
+func (d Difference) String() string {
+	expected, _ := json.Marshal(d.Expected)
+	got, _ := json.Marshal(d.Got)
+	return fmt.Sprintf("%s: expected %s, got %s", d.Path, expected, got)
+}
+
+// Diff compares a transformed block with a getBlock result field by field.
+// Vote transactions, balances of accounts that don't change and runtime logs aren't in the portal so they are removed from the RPC result before comparing
+func Diff(block *solana.Block, rpcBlock json.RawMessage) ([]Difference, error) {
+	raw, err := json.Marshal(block)
+	if err != nil {
+		return nil, err
+	}
+
+	var got, expected map[string]interface{}
+	if err := decode(raw, &got); err != nil {
+		return nil, err
+	}
+	if err := decode(rpcBlock, &expected); err != nil {
+		return nil, fmt.Errorf("Invalid getBlock result: %w", err)
+	}
+
+	d := &differ{}
+
+	gotTxs, _ := got["transactions"].([]interface{})
+	expectedTxs, _ := expected["transactions"].([]interface{})
+	delete(got, "transactions")
+	delete(expected, "transactions")
+
+	d.compare("", expected, got)
+	d.compareTransactions(expectedTxs, gotTxs)
+
+	return d.diffs, nil
+}
+
+func decode(raw []byte, out interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	return dec.Decode(out)
+}
+
+type differ struct {
+	diffs []Difference
+}
+
+func (d *differ) add(path string, expected, got interface{}) {
+	d.diffs = append(d.diffs, Difference{path, expected, got})
+}
+
+// compareTransactions matches transactions by signature as the transaction indexes of the portal don't include vote transactions
+func (d *differ) compareTransactions(expected, got []interface{}) {
+	gotBySig := map[string]map[string]interface{}{}
+	gotIndex := map[string]int{}
+	for i, tx := range got {
+		tx := tx.(map[string]interface{})
+		gotBySig[signature(tx)] = tx
+		gotIndex[signature(tx)] = i
+	}
+
+	last := -1
+	for i, tx := range expected {
+		tx := tx.(map[string]interface{})
+		if isVote(tx) {
+			continue
+		}
+
+		sig := signature(tx)
+		path := fmt.Sprintf("transactions[%s]", sig)
+		gotTx, ok := gotBySig[sig]
+		if !ok {
+			d.add(path, fmt.Sprintf("transaction %d", i), nil)
+			continue
+		}
+		delete(gotBySig, sig)
+
+		// Transactions should be in the same order as the block
+		if gotIndex[sig] < last {
+			d.add(path, fmt.Sprintf("after transaction %d", last), fmt.Sprintf("transaction %d", gotIndex[sig]))
+		}
+		last = gotIndex[sig]
+
+		expectedMeta, _ := tx["meta"].(map[string]interface{})
+		gotMeta, _ := gotTx["meta"].(map[string]interface{})
+		if expectedMeta != nil && gotMeta != nil {
+			d.compareBalances(path+".meta", expectedMeta, gotMeta)
+			d.compareLogs(path+".meta", expectedMeta, gotMeta)
+		}
+
+		d.compare(path, tx, gotTx)
+	}
+
+	for sig := range gotBySig {
+		d.add(fmt.Sprintf("transactions[%s]", sig), nil, "transaction")
+	}
+}
+
+// compareBalances compares the balances of accounts that change, the portal only includes those.
+// The balances are removed from the transactions so they aren't compared again
+func (d *differ) compareBalances(path string, expected, got map[string]interface{}) {
+	pre, _ := expected["preBalances"].([]interface{})
+	post, _ := expected["postBalances"].([]interface{})
+	if len(pre) != len(post) {
+		d.add(path+".preBalances", pre, post)
+		return
+	}
+
+	changedPre, changedPost := []interface{}{}, []interface{}{}
+	for i := range pre {
+		if !equalNumbers(pre[i], post[i]) {
+			changedPre = append(changedPre, pre[i])
+			changedPost = append(changedPost, post[i])
+		}
+	}
+
+	d.compare(path+".preBalances", changedPre, got["preBalances"])
+	d.compare(path+".postBalances", changedPost, got["postBalances"])
+
+	for _, m := range []map[string]interface{}{expected, got} {
+		delete(m, "preBalances")
+		delete(m, "postBalances")
+	}
+}
+
+// compareLogs compares the program logs of the RPC with the structured logs of the portal
+func (d *differ) compareLogs(path string, expected, got map[string]interface{}) {
+	messages, _ := expected["logMessages"].([]interface{})
+	programLogs := []interface{}{}
+	for _, message := range messages {
+		if message, ok := message.(string); ok && !runtimeLog.MatchString(message) {
+			programLogs = append(programLogs, message)
+		}
+	}
+
+	logs, _ := got["logs"].([]interface{})
+	gotLogs := []interface{}{}
+	for _, log := range logs {
+		log, _ := log.(map[string]interface{})
+		message, _ := log["message"].(string)
+		switch log["kind"] {
+		case "log":
+			message = "Program log: " + message
+		case "data":
+			message = "Program data: " + message
+		}
+		gotLogs = append(gotLogs, message)
+	}
+
+	d.compare(path+".logMessages", programLogs, gotLogs)
+}
+
+func (d *differ) compare(path string, expected, got interface{}) {
+	switch expected := expected.(type) {
+	case map[string]interface{}:
+		gotObject, ok := got.(map[string]interface{})
+		if !ok {
+			d.add(path, expected, got)
+			return
+		}
+		d.compareObjects(path, expected, gotObject)
+	case []interface{}:
+		gotArray, ok := got.([]interface{})
+		if !ok || len(gotArray) != len(expected) {
+			d.add(path, expected, got)
+			return
+		}
+		for i := range expected {
+			d.compare(fmt.Sprintf("%s[%d]", path, i), expected[i], gotArray[i])
+		}
+	case json.Number:
+		if !equalNumbers(expected, got) {
+			d.add(path, expected, got)
+		}
+	default:
+		if !reflect.DeepEqual(expected, got) {
+			d.add(path, expected, got)
+		}
+	}
+}
+
+func (d *differ) compareObjects(path string, expected, got map[string]interface{}) {
+	keys := []string{}
+	for key := range expected {
+		keys = append(keys, key)
+	}
+	for key := range got {
+		if _, ok := expected[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		fieldPath := key
+		if path != "" {
+			fieldPath = path + "." + key
+		}
+		normalized := arrayIndex.ReplaceAllString(fieldPath, "[]")
+		if _, ok := KNOWN_GAPS[normalized]; ok {
+			continue
+		}
+
+		expectedValue, inExpected := expected[key]
+		gotValue, inGot := got[key]
+
+		switch {
+		// A missing field is the same as null
+		case !inExpected && gotValue == nil, !inGot && expectedValue == nil:
+			continue
+		case !inExpected:
+			if _, ok := EXTENSIONS[normalized]; ok {
+				continue
+			}
+			if OMITTED_WHEN_EMPTY[normalized] && isEmpty(gotValue) {
+				continue
+			}
+			d.add(fieldPath, nil, gotValue)
+		case !inGot:
+			d.add(fieldPath, expectedValue, nil)
+		default:
+			d.compare(fieldPath, expectedValue, gotValue)
+		}
+	}
+}
+
+// equalNumbers compares numbers by value, the RPC encodes whole floats with a trailing .0
+func equalNumbers(a, b interface{}) bool {
+	aNum, aOk := a.(json.Number)
+	bNum, bOk := b.(json.Number)
+	if !aOk || !bOk {
+		return false
+	}
+	if aNum == bNum {
+		return true
+	}
+
+	aRat, aOk := new(big.Rat).SetString(aNum.String())
+	bRat, bOk := new(big.Rat).SetString(bNum.String())
+	return aOk && bOk && aRat.Cmp(bRat) == 0
+}
+
+func isEmpty(v interface{}) bool {
+	arr, ok := v.([]interface{})
+	return v == nil || (ok && len(arr) == 0)
+}
+
+func signature(tx map[string]interface{}) string {
+	inner, _ := tx["transaction"].(map[string]interface{})
+	sigs, _ := inner["signatures"].([]interface{})
+	if len(sigs) == 0 {
+		return ""
+	}
+	sig, _ := sigs[0].(string)
+	return sig
+}
+
+// isVote checks if any instruction is for the vote program, the portal doesn't include vote transactions
+func isVote(tx map[string]interface{}) bool {
+	inner, _ := tx["transaction"].(map[string]interface{})
+	message, _ := inner["message"].(map[string]interface{})
+	keys, _ := message["accountKeys"].([]interface{})
+	instructions, _ := message["instructions"].([]interface{})
+
+	for _, inst := range instructions {
+		inst, _ := inst.(map[string]interface{})
+		index, ok := inst["programIdIndex"].(json.Number)
+		if !ok {
+			continue
+		}
+		i, err := index.Int64()
+		if err == nil && i >= 0 && int(i) < len(keys) && keys[i] == VOTE_PROGRAM_ID {
+			return true
+		}
+	}
+	return false
+}
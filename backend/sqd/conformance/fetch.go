@@ -0,0 +1,93 @@
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/subquery/solana-takoyaki/backend/sqd"
+)
+
+// The getBlock options the pairs are recorded with, transactions are compared in the json encoding
+var GET_BLOCK_CONFIG = map[string]interface{}{
+	"encoding":                       "json",
+	"transactionDetails":             "full",
+	"rewards":                        true,
+	"maxSupportedTransactionVersion": 0,
+}
+
+// Fetch downloads the portal block and the getBlock response of each slot to fixtureDir, in the format read by Record
+func Fetch(ctx context.Context, portal sqd.Backend, rpcEndpoint string, slots []uint64, fixtureDir string) error {
+	if err := os.MkdirAll(fixtureDir, 0o755); err != nil {
+		return err
+	}
+
+	for _, slot := range slots {
+		name := strconv.FormatUint(slot, 10)
+
+		blocks, err := portal.Query(ctx, sqd.FullBlockRequest(uint(slot), uint(slot)), nil)
+		if err != nil {
+			return fmt.Errorf("Failed to get portal block %d: %w", slot, err)
+		}
+		if len(blocks) == 0 || blocks[0].Header.Slot != slot {
+			return fmt.Errorf("No portal block at slot %d", slot)
+		}
+		rawBlock, err := json.Marshal(blocks[0])
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(fixtureDir, name+".ndjson"), rawBlock, 0o644); err != nil {
+			return err
+		}
+
+		rawRPC, err := getBlock(ctx, rpcEndpoint, slot)
+		if err != nil {
+			return fmt.Errorf("Failed to get block %d from the RPC: %w", slot, err)
+		}
+		if err := os.WriteFile(filepath.Join(fixtureDir, name+".json"), rawRPC, 0o644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getBlock returns the whole JSON-RPC response of getBlock for the slot
+func getBlock(ctx context.Context, rpcEndpoint string, slot uint64) ([]byte, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  "getBlock",
+		"params":  []interface{}{slot, GET_BLOCK_CONFIG},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", rpcEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	raw, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Bad response code: %s\n%s", res.Status, raw)
+	}
+	return raw, nil
+}
@@ -0,0 +1,188 @@
+package conformance
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/subquery/solana-takoyaki/backend/sqd"
+)
+
+// The file names of a pair in its directory
+const (
+	SQD_FILE = "sqd.json"
+	RPC_FILE = "rpc.json"
+)
+
+// Pair is a portal block and the getBlock result for the same slot
+type Pair struct {
+	Name  string
+	Block sqd.SolanaBlockResponse
+	RPC   json.RawMessage
+}
+
+// Diff transforms the portal block and compares it with the getBlock result
+func (p Pair) Diff() ([]Difference, error) {
+	block, err := sqd.TransformBlock(p.Block)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to transform block: %w", err)
+	}
+	return Diff(block, p.RPC)
+}
+
+// LoadPairs reads every pair in dir, each pair is a directory with sqd.json and rpc.json
+func LoadPairs(dir string) ([]Pair, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	pairs := []Pair{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		pair := Pair{Name: entry.Name()}
+		rawBlock, err := os.ReadFile(filepath.Join(dir, entry.Name(), SQD_FILE))
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(rawBlock, &pair.Block); err != nil {
+			return nil, fmt.Errorf("Invalid portal block in %v: %w", entry.Name(), err)
+		}
+		if pair.RPC, err = os.ReadFile(filepath.Join(dir, entry.Name(), RPC_FILE)); err != nil {
+			return nil, err
+		}
+
+		pairs = append(pairs, pair)
+	}
+
+	return pairs, nil
+}
+
+// Record pairs the portal blocks and getBlock results in fixtureDir by slot and writes them to outDir.
+// Portal blocks are read from *.ndjson files, like /stream responses. getBlock results are read from <slot>.json files, either the result or the whole JSON-RPC response.
+// Slots that are only in one of them are skipped, the recorded pairs are returned
+func Record(fixtureDir, outDir string) ([]Pair, error) {
+	blocks, err := readPortalBlocks(fixtureDir)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(fixtureDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	pairs := []Pair{}
+	for _, file := range files {
+		slot, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(file), ".json"), 10, 64)
+		if err != nil {
+			continue // Not a getBlock result
+		}
+		rawBlock, ok := blocks[slot]
+		if !ok {
+			continue
+		}
+
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		result, err := getBlockResult(raw)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid getBlock result in %v: %w", file, err)
+		}
+
+		pair := Pair{Name: strconv.FormatUint(slot, 10), RPC: result}
+		if err := json.Unmarshal(rawBlock, &pair.Block); err != nil {
+			return nil, err
+		}
+		if err := writePair(outDir, pair.Name, rawBlock, result); err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, pair)
+	}
+
+	slices.SortFunc(pairs, func(a, b Pair) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return pairs, nil
+}
+
+// readPortalBlocks reads the portal blocks in dir by slot, they are kept as is so no fields are lost when recording
+func readPortalBlocks(dir string) (map[uint64]json.RawMessage, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.ndjson"))
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := map[uint64]json.RawMessage{}
+	for _, file := range files {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		for dec.More() {
+			var rawBlock json.RawMessage
+			var block sqd.SolanaBlockResponse
+			if err := dec.Decode(&rawBlock); err != nil {
+				return nil, fmt.Errorf("Invalid portal block in %v: %w", file, err)
+			}
+			if err := json.Unmarshal(rawBlock, &block); err != nil {
+				return nil, fmt.Errorf("Invalid portal block in %v: %w", file, err)
+			}
+			blocks[block.Header.Slot] = rawBlock
+		}
+	}
+
+	return blocks, nil
+}
+
+// getBlockResult unwraps a JSON-RPC response, a getBlock result is returned as is
+func getBlockResult(raw []byte) (json.RawMessage, error) {
+	var res struct {
+		Result json.RawMessage `json:"result"`
+		Error  json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return nil, err
+	}
+	if res.Error != nil {
+		return nil, fmt.Errorf("JSON-RPC error: %s", res.Error)
+	}
+	if res.Result != nil {
+		if bytes.Equal(res.Result, []byte("null")) {
+			return nil, fmt.Errorf("Block not available")
+		}
+		return res.Result, nil
+	}
+	return raw, nil
+}
+
+func writePair(outDir, name string, rawBlock, rawRPC json.RawMessage) error {
+	dir := filepath.Join(outDir, name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	for file, raw := range map[string]json.RawMessage{SQD_FILE: rawBlock, RPC_FILE: rawRPC} {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, raw, "", "  "); err != nil {
+			return err
+		}
+		indented.WriteByte('\n')
+		if err := os.WriteFile(filepath.Join(dir, file), indented.Bytes(), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,345 @@
+package sqd
+
+import (
+	"bytes"
+	"slices"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/mr-tron/base58"
+)
+
+// FilterBlock applies the item requests of a SolanaRequest to a block the same way the portal does.
+// Matching items and their requested relations are kept, false is returned if nothing matched and the request doesn't include all blocks.
+// Field selection is not applied, all fields of the block are kept
+func FilterBlock(block SolanaBlockResponse, req SolanaRequest) (SolanaBlockResponse, bool) {
+	s := newSelection(block)
+
+	for _, txReq := range req.Transactions {
+		for _, tx := range block.Transactions {
+			if txReq.matches(tx) {
+				s.selectTransaction(tx.TransactionIndex, txReq.Instructions, txReq.Logs, txReq.Balances, txReq.TokenBalances)
+			}
+		}
+	}
+
+	for _, instReq := range req.Instructions {
+		for i, inst := range block.Instructions {
+			if !instReq.matches(inst) {
+				continue
+			}
+			s.instructions[i] = true
+			if instReq.Transaction {
+				s.transactions[inst.TransactionIndex] = true
+			}
+			if instReq.TransactionInstructions {
+				s.selectTransaction(inst.TransactionIndex, true, false, false, false)
+			}
+			if instReq.TransactionBalances {
+				s.selectTransaction(inst.TransactionIndex, false, false, true, false)
+			}
+			if instReq.TransactionTokenBalances {
+				s.selectTransaction(inst.TransactionIndex, false, false, false, true)
+			}
+			if instReq.InnerInstructions {
+				for j, inner := range block.Instructions {
+					if inner.TransactionIndex == inst.TransactionIndex && isInnerAddress(inst.InstructionAddress, inner.InstructionAddress) {
+						s.instructions[j] = true
+					}
+				}
+			}
+			if instReq.Logs {
+				for j, log := range block.Logs {
+					if log.TransactionIndex == inst.TransactionIndex && sameAddress(inst.InstructionAddress, log.InstructionAddress) {
+						s.logs[j] = true
+					}
+				}
+			}
+		}
+	}
+
+	for _, logReq := range req.Logs {
+		for i, log := range block.Logs {
+			if !logReq.matches(log) {
+				continue
+			}
+			s.logs[i] = true
+			if logReq.Transaction {
+				s.transactions[log.TransactionIndex] = true
+			}
+			if logReq.Instruction {
+				for j, inst := range block.Instructions {
+					if inst.TransactionIndex == log.TransactionIndex && sameAddress(inst.InstructionAddress, log.InstructionAddress) {
+						s.instructions[j] = true
+					}
+				}
+			}
+		}
+	}
+
+	for _, balanceReq := range req.Balances {
+		for i, balance := range block.Balances {
+			if !matchesAny(balanceReq.Account, balance.Account) {
+				continue
+			}
+			s.balances[i] = true
+			if balanceReq.Transaction {
+				s.transactions[balance.TransactionIndex] = true
+			}
+			if balanceReq.TransactionInstructions {
+				s.selectTransaction(balance.TransactionIndex, true, false, false, false)
+			}
+		}
+	}
+
+	for _, tokenReq := range req.TokenBalances {
+		for i, tokenBalance := range block.TokenBalances {
+			if !tokenReq.matches(tokenBalance) {
+				continue
+			}
+			s.tokenBalances[i] = true
+			if tokenReq.Transaction != nil && *tokenReq.Transaction {
+				s.transactions[tokenBalance.TransactionIndex] = true
+			}
+			if tokenReq.TransactionInstructions != nil && *tokenReq.TransactionInstructions {
+				s.selectTransaction(tokenBalance.TransactionIndex, true, false, false, false)
+			}
+		}
+	}
+
+	for _, rewardReq := range req.Rewards {
+		for i, reward := range block.Rewards {
+			if matchesAny(rewardReq.PubKey, reward.Pubkey) {
+				s.rewards[i] = true
+			}
+		}
+	}
+
+	if s.empty() && (req.IncludeAllBlocks == nil || !*req.IncludeAllBlocks) {
+		return SolanaBlockResponse{Header: block.Header}, false
+	}
+
+	return s.apply(), true
+}
+
+// selection tracks the selected items of a block, transactions are keyed by transaction index and other items by their position in the block
+type selection struct {
+	block         SolanaBlockResponse
+	transactions  map[uint]bool
+	instructions  map[int]bool
+	logs          map[int]bool
+	balances      map[int]bool
+	tokenBalances map[int]bool
+	rewards       map[int]bool
+}
+
+func newSelection(block SolanaBlockResponse) *selection {
+	return &selection{
+		block:         block,
+		transactions:  map[uint]bool{},
+		instructions:  map[int]bool{},
+		logs:          map[int]bool{},
+		balances:      map[int]bool{},
+		tokenBalances: map[int]bool{},
+		rewards:       map[int]bool{},
+	}
+}
+
+// selectTransaction selects a transaction and optionally all of its instructions, logs, balances and token balances
+func (s *selection) selectTransaction(txIndex uint, instructions, logs, balances, tokenBalances bool) {
+	s.transactions[txIndex] = true
+
+	if instructions {
+		for i, inst := range s.block.Instructions {
+			if inst.TransactionIndex == txIndex {
+				s.instructions[i] = true
+			}
+		}
+	}
+	if logs {
+		for i, log := range s.block.Logs {
+			if log.TransactionIndex == txIndex {
+				s.logs[i] = true
+			}
+		}
+	}
+	if balances {
+		for i, balance := range s.block.Balances {
+			if balance.TransactionIndex == txIndex {
+				s.balances[i] = true
+			}
+		}
+	}
+	if tokenBalances {
+		for i, tokenBalance := range s.block.TokenBalances {
+			if tokenBalance.TransactionIndex == txIndex {
+				s.tokenBalances[i] = true
+			}
+		}
+	}
+}
+
+func (s *selection) empty() bool {
+	return len(s.transactions) == 0 &&
+		len(s.instructions) == 0 &&
+		len(s.logs) == 0 &&
+		len(s.balances) == 0 &&
+		len(s.tokenBalances) == 0 &&
+		len(s.rewards) == 0
+}
+
+// apply builds a block with only the selected items, keeping the original order
+func (s *selection) apply() SolanaBlockResponse {
+	res := SolanaBlockResponse{
+		Header:        s.block.Header,
+		Transactions:  []transaction{},
+		Instructions:  []instruction{},
+		Logs:          []logMessage{},
+		Balances:      []balance{},
+		TokenBalances: []tokenBalance{},
+		Rewards:       []reward{},
+	}
+
+	for _, tx := range s.block.Transactions {
+		if s.transactions[tx.TransactionIndex] {
+			res.Transactions = append(res.Transactions, tx)
+		}
+	}
+	for i, inst := range s.block.Instructions {
+		if s.instructions[i] {
+			res.Instructions = append(res.Instructions, inst)
+		}
+	}
+	for i, log := range s.block.Logs {
+		if s.logs[i] {
+			res.Logs = append(res.Logs, log)
+		}
+	}
+	for i, balance := range s.block.Balances {
+		if s.balances[i] {
+			res.Balances = append(res.Balances, balance)
+		}
+	}
+	for i, tokenBalance := range s.block.TokenBalances {
+		if s.tokenBalances[i] {
+			res.TokenBalances = append(res.TokenBalances, tokenBalance)
+		}
+	}
+	for i, reward := range s.block.Rewards {
+		if s.rewards[i] {
+			res.Rewards = append(res.Rewards, reward)
+		}
+	}
+
+	return res
+}
+
+func (tr *TransactionRequest) matches(tx transaction) bool {
+	feePayer := tx.FeePayer
+	if feePayer == "" && len(tx.AccountKeys) > 0 {
+		feePayer = tx.AccountKeys[0]
+	}
+	if !matchesAny(tr.FeePayer, feePayer) {
+		return false
+	}
+
+	if len(tr.MentionsAccount) > 0 {
+		mentioned := slices.ContainsFunc(tx.AccountKeys, func(key string) bool { return slices.Contains(tr.MentionsAccount, key) }) ||
+			slices.ContainsFunc(tx.LoadedAddresses.Readonly, func(key string) bool { return slices.Contains(tr.MentionsAccount, key) }) ||
+			slices.ContainsFunc(tx.LoadedAddresses.Writable, func(key string) bool { return slices.Contains(tr.MentionsAccount, key) })
+		if !mentioned {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (ir *InstructionRequest) matches(inst instruction) bool {
+	if !matchesAny(ir.ProgramId, inst.ProgramId) {
+		return false
+	}
+
+	if ir.IsCommitted != nil && *ir.IsCommitted != inst.IsCommitted {
+		return false
+	}
+
+	if len(ir.MentionsAccount) > 0 && !slices.ContainsFunc(inst.Accounts, func(key string) bool { return slices.Contains(ir.MentionsAccount, key) }) {
+		return false
+	}
+
+	accountFields := [][]string{
+		ir.A0, ir.A1, ir.A2, ir.A3, ir.A4,
+		ir.A5, ir.A6, ir.A7, ir.A8, ir.A9,
+		ir.A10, ir.A11, ir.A12, ir.A13, ir.A14, ir.A15,
+	}
+	for i, accounts := range accountFields {
+		if len(accounts) == 0 {
+			continue
+		}
+		if i >= len(inst.Accounts) || !slices.Contains(accounts, inst.Accounts[i]) {
+			return false
+		}
+	}
+
+	discriminators := [][]string{ir.D1, ir.D2, ir.D3, ir.D4, ir.D8}
+	hasDiscriminators := slices.ContainsFunc(discriminators, func(d []string) bool { return len(d) > 0 })
+	if hasDiscriminators {
+		data, err := base58.Decode(inst.Data)
+		if err != nil {
+			return false
+		}
+		for _, ds := range discriminators {
+			if len(ds) == 0 {
+				continue
+			}
+			if !slices.ContainsFunc(ds, func(d string) bool { return bytes.HasPrefix(data, common.FromHex(d)) }) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (lr *LogRequest) matches(log logMessage) bool {
+	return matchesAny(lr.ProgramId, log.ProgramId) && matchesAny(lr.Kind, log.Kind)
+}
+
+func (tr *TokenBalanceRequest) matches(tb tokenBalance) bool {
+	return matchesAny(tr.Account, tb.Account) &&
+		matchesAny(tr.PreMint, tb.PreMint) &&
+		matchesAny(tr.PostMint, tb.PostMint) &&
+		matchesAnyPtr(tr.PreOwner, tb.PreOwner) &&
+		matchesAnyPtr(tr.PostOwner, tb.PostOwner) &&
+		matchesAnyPtr(tr.PreProgramId, tb.PreProgramId) &&
+		matchesAnyPtr(tr.PostProgramId, tb.PostProgramId)
+}
+
+// matchesAny returns true if the filter is empty or contains the value
+func matchesAny(filter []string, value string) bool {
+	return len(filter) == 0 || slices.Contains(filter, value)
+}
+
+func matchesAnyPtr(filter []string, value *string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	return value != nil && slices.Contains(filter, *value)
+}
+
+// isInnerAddress returns true if address is nested under parent
+func isInnerAddress(parent []uint64, address []uint64) bool {
+	return len(address) > len(parent) && slices.Equal(parent, address[:len(parent)])
+}
+
+func sameAddress(instAddress []uint64, logAddress []uint) bool {
+	if len(instAddress) != len(logAddress) {
+		return false
+	}
+	for i := range instAddress {
+		if instAddress[i] != uint64(logAddress[i]) {
+			return false
+		}
+	}
+	return true
+}
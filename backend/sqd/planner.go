@@ -0,0 +1,165 @@
+package sqd
+
+import (
+	"context"
+
+	"github.com/subquery/solana-takoyaki/cache"
+)
+
+// The default number of slots per chunk when splitting queries
+const DEFAULT_PLANNER_CHUNK_SIZE = 100_000
+
+// The default number of chunks queried at once
+const DEFAULT_PLANNER_CONCURRENCY = 4
+
+// Planner splits wide queries into chunks that are queried concurrently and reassembled in order.
+// All other methods are passed through to the wrapped backend
+type Planner struct {
+	Backend
+	chunkSize   uint
+	concurrency int
+}
+
+func NewPlanner(backend Backend, chunkSize uint, concurrency int) *Planner {
+	return &Planner{backend, chunkSize, concurrency}
+}
+
+func (p *Planner) CacheStats() *cache.Stats {
+	if cached, ok := p.Backend.(interface{ CacheStats() *cache.Stats }); ok {
+		return cached.CacheStats()
+	}
+	return nil
+}
+
+type chunkResult struct {
+	blocks []SolanaBlockResponse
+	err    error
+}
+
+// Query splits the range into chunks, each chunk is queried until it is complete or the limit is reached.
+// Chunks after the limit is reached are cancelled.
+// Like the portal, the first and last blocks in the range are always returned
+func (p *Planner) Query(ctx context.Context, solReq SolanaRequest, limit *int) ([]SolanaBlockResponse, error) {
+	res := []SolanaBlockResponse{}
+	err := p.QueryStream(ctx, solReq, limit, func(block SolanaBlockResponse) error {
+		res = append(res, block)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// QueryStream is Query passing blocks to onBlock in order. Narrow ranges are streamed from the backend, wide ranges are passed on a chunk at a time
+func (p *Planner) QueryStream(ctx context.Context, solReq SolanaRequest, limit *int, onBlock func(SolanaBlockResponse) error) error {
+	if p.concurrency <= 1 || p.chunkSize == 0 || solReq.ToBlock-solReq.FromBlock < p.chunkSize {
+		return QueryStream(ctx, p.Backend, solReq, limit, onBlock)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ranges := [][2]uint{}
+	for start := solReq.FromBlock; start <= solReq.ToBlock; start += p.chunkSize {
+		ranges = append(ranges, [2]uint{start, min(start+p.chunkSize-1, solReq.ToBlock)})
+	}
+
+	results := make([]chan chunkResult, len(ranges))
+	for i := range results {
+		results[i] = make(chan chunkResult, 1)
+	}
+
+	// Start chunks in order with bounded concurrency
+	go func() {
+		sem := make(chan struct{}, p.concurrency)
+		for i, r := range ranges {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			go func() {
+				defer func() { <-sem }()
+				blocks, err := p.queryChunk(ctx, solReq, r[0], r[1], limit)
+				results[i] <- chunkResult{blocks, err}
+			}()
+		}
+	}()
+
+	includeAll := solReq.IncludeAllBlocks != nil && *solReq.IncludeAllBlocks
+	count := 0
+	for i := range ranges {
+		var result chunkResult
+		select {
+		case result = <-results[i]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if result.err != nil {
+			return result.err
+		}
+
+		lastChunk := i == len(ranges)-1
+		for j, block := range result.blocks {
+			isFirst := count == 0
+			isLast := lastChunk && j == len(result.blocks)-1
+			// Drop the boundary blocks of each chunk that didn't match
+			if !includeAll && !isFirst && !isLast && !hasItems(block) {
+				continue
+			}
+
+			if err := onBlock(block); err != nil {
+				return err
+			}
+			count++
+			if limit != nil && count >= *limit {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+// queryChunk queries from start to end, continuing from the last block if the stream ends early
+func (p *Planner) queryChunk(ctx context.Context, solReq SolanaRequest, start, end uint, limit *int) ([]SolanaBlockResponse, error) {
+	res := []SolanaBlockResponse{}
+
+	next := start
+	for next <= end {
+		chunkReq := solReq
+		chunkReq.FromBlock = next
+		chunkReq.ToBlock = end
+		// The parent hash only applies to the start of the range
+		if next != solReq.FromBlock {
+			chunkReq.ParentBlockHash = nil
+		}
+
+		blocks, err := p.Backend.Query(ctx, chunkReq, limit)
+		if err != nil {
+			return nil, err
+		}
+		if len(blocks) == 0 {
+			break
+		}
+
+		res = append(res, blocks...)
+		if limit != nil && len(res) >= *limit {
+			break
+		}
+		next = uint(blocks[len(blocks)-1].Header.Slot) + 1
+	}
+
+	return res, nil
+}
+
+func hasItems(block SolanaBlockResponse) bool {
+	return len(block.Transactions) > 0 ||
+		len(block.Instructions) > 0 ||
+		len(block.Logs) > 0 ||
+		len(block.Balances) > 0 ||
+		len(block.TokenBalances) > 0 ||
+		len(block.Rewards) > 0
+}
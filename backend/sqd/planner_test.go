@@ -0,0 +1,114 @@
+package sqd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+const PLANNER_PROGRAM_ID = "JUP6LkbZbjS1jKKwapdHNy74zcZ3tLUZoi5QNyVTaV4"
+
+// portalBackend filters in memory blocks like the portal, ending streams after 3 blocks
+type portalBackend struct {
+	blocks  []SolanaBlockResponse
+	queries atomic.Int32
+}
+
+func (b *portalBackend) Query(ctx context.Context, solReq SolanaRequest, limit *int) ([]SolanaBlockResponse, error) {
+	b.queries.Add(1)
+	res := []SolanaBlockResponse{}
+	for _, block := range b.blocks {
+		slot := uint(block.Header.Slot)
+		if slot < solReq.FromBlock || slot > solReq.ToBlock {
+			continue
+		}
+		filtered, ok := FilterBlock(block, solReq)
+		if ok || len(res) == 0 || slot == solReq.ToBlock {
+			res = append(res, filtered)
+		}
+		if len(res) >= 3 || (limit != nil && len(res) >= *limit) {
+			break
+		}
+	}
+	return res, ctx.Err()
+}
+
+func (b *portalBackend) Head(ctx context.Context) (*BlockRef, error)          { return nil, nil }
+func (b *portalBackend) FinalizedHead(ctx context.Context) (*BlockRef, error) { return nil, nil }
+func (b *portalBackend) Metadata(ctx context.Context) (*NetworkMeta, error)   { return nil, nil }
+
+// plannerBlocks creates blocks for slots 1 to 99, every 10th slot has an instruction for PLANNER_PROGRAM_ID
+func plannerBlocks(t *testing.T) []SolanaBlockResponse {
+	blocks := []SolanaBlockResponse{}
+	for slot := 1; slot < 100; slot++ {
+		programId := "11111111111111111111111111111111"
+		if slot%10 == 5 {
+			programId = PLANNER_PROGRAM_ID
+		}
+		raw := fmt.Sprintf(`{
+			"header":{"number":%d,"height":%d,"hash":"hash%d","parentNumber":%d,"parentHash":"hash%d"},
+			"instructions":[{"transactionIndex":0,"instructionAddress":[0],"programId":%q}]
+		}`, slot, slot, slot, slot-1, slot-1, programId)
+
+		var block SolanaBlockResponse
+		if err := json.Unmarshal([]byte(raw), &block); err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+func plannerSlots(blocks []SolanaBlockResponse) string {
+	slots := []uint64{}
+	for _, block := range blocks {
+		slots = append(slots, block.Header.Slot)
+	}
+	return fmt.Sprint(slots)
+}
+
+func TestPlannerQuery(t *testing.T) {
+	backend := &portalBackend{blocks: plannerBlocks(t)}
+	planner := NewPlanner(backend, 20, 3)
+
+	req := SolanaRequest{
+		FromBlock:    1,
+		ToBlock:      98,
+		Instructions: []InstructionRequest{{ProgramId: []string{PLANNER_PROGRAM_ID}}},
+	}
+
+	res, err := planner.Query(context.Background(), req, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Chunk boundaries are removed, only the first and last blocks of the whole range are kept
+	expected := "[1 5 15 25 35 45 55 65 75 85 95 98]"
+	if plannerSlots(res) != expected {
+		t.Errorf("Expected slots %v, got %v", expected, plannerSlots(res))
+	}
+
+	limit := 4
+	res, err = planner.Query(context.Background(), req, &limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plannerSlots(res) != "[1 5 15 25]" {
+		t.Errorf("Unexpected limited slots %v", plannerSlots(res))
+	}
+}
+
+func TestPlannerNarrowRange(t *testing.T) {
+	backend := &portalBackend{blocks: plannerBlocks(t)}
+	planner := NewPlanner(backend, 20, 3)
+
+	_, err := planner.Query(context.Background(), SolanaRequest{FromBlock: 0, ToBlock: 10}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backend.queries.Load() != 1 {
+		t.Errorf("Expected narrow ranges to be passed through, got %v queries", backend.queries.Load())
+	}
+}
@@ -9,8 +9,15 @@ import (
 	"log/slog"
 	"net/http"
 	"net/url"
+	"sync"
+	"time"
 
+	"github.com/subquery/solana-takoyaki/cache"
 	"github.com/subquery/solana-takoyaki/meta"
+	"github.com/subquery/solana-takoyaki/metrics"
+	"github.com/subquery/solana-takoyaki/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // The legacy version had much more default to true values
@@ -36,6 +43,7 @@ var ALL_SOLDEXER_FIELDS = Fields{
 		"numRequiredSignatures":       true,
 		"addressTableLookups":         true,
 		"computeUnitsConsumed":        true,
+		"version":                     true,
 		// "recentBlockHash":             true, // Waiting on fix from SQD
 	},
 	Log: map[string]bool{
@@ -83,11 +91,21 @@ var ALL_SOLDEXER_FIELDS = Fields{
 	},
 }
 
-type headResponse struct {
+type BlockRef struct {
 	Number uint   `json:"number"`
 	Hash   string `json:"hash"`
 }
 
+// ForkError is returned when the parent block hash of a query doesn't match the portal chain
+type ForkError struct {
+	// Blocks the portal has before the requested block, these can be used to find a common ancestor
+	PreviousBlocks []BlockRef `json:"previousBlocks"`
+}
+
+func (e *ForkError) Error() string {
+	return "Fork detected, parent block hash doesn't match"
+}
+
 type metaResponse struct {
 	Dataset    string   `json:"dataset"`
 	Aliases    []string `json:"aliases"`
@@ -95,16 +113,82 @@ type metaResponse struct {
 	StartBlock uint     `json:"start_block"`
 }
 
+// How long portal metadata is cached before being refreshed
+const METADATA_REFRESH_INTERVAL = time.Minute
+
+// How long the latest and finalized heads are cached
+const HEAD_CACHE_TTL = 500 * time.Millisecond
+
 type SoldexerClient struct {
 	baseUrl string
-	meta    *NetworkMeta
+	client  *http.Client
+	network meta.NetworkMeta
+
+	metaMu        sync.Mutex
+	meta          *NetworkMeta
+	metaFetchedAt time.Time
+
+	head          cachedRef
+	finalizedHead cachedRef
+
+	// Optional, caches query results for finalized ranges
+	cache *cache.Cache
+}
+
+// cachedRef caches a block reference for HEAD_CACHE_TTL
+type cachedRef struct {
+	mu        sync.Mutex
+	ref       *BlockRef
+	fetchedAt time.Time
+}
+
+func (r *cachedRef) get(ctx context.Context, fetch func(ctx context.Context) (*BlockRef, error)) (*BlockRef, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ref != nil && time.Since(r.fetchedAt) < HEAD_CACHE_TTL {
+		return r.ref, nil
+	}
+
+	ref, err := fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r.ref = ref
+	r.fetchedAt = time.Now()
+	return ref, nil
 }
 
 func NewSoldexerClient(baseUrl string) *SoldexerClient {
 	return &SoldexerClient{
-		baseUrl,
-		nil,
+		baseUrl: baseUrl,
+		client:  &http.Client{Transport: tracing.Transport(http.DefaultTransport)},
+		network: meta.MAINNET,
+	}
+}
+
+// SetNetwork sets the network used for values the portal doesn't provide such as the genesis hash, the default is mainnet
+func (c *SoldexerClient) SetNetwork(network meta.NetworkMeta) {
+	c.network = network
+}
+
+// SetTimeout limits the duration of each portal request including reading the response, 0 means no timeout
+func (c *SoldexerClient) SetTimeout(timeout time.Duration) {
+	c.client.Timeout = timeout
+}
+
+// SetCache enables caching query results for ranges below the finalized head
+func (c *SoldexerClient) SetCache(cache *cache.Cache) {
+	c.cache = cache
+}
+
+func (c *SoldexerClient) CacheStats() *cache.Stats {
+	if c.cache == nil {
+		return nil
 	}
+	stats := c.cache.Stats()
+	return &stats
 }
 
 func (c *SoldexerClient) GetAllFields() Fields {
@@ -112,148 +196,315 @@ func (c *SoldexerClient) GetAllFields() Fields {
 }
 
 func (c *SoldexerClient) CurrentHeight(ctx context.Context) (uint, error) {
-	url, err := url.JoinPath(c.baseUrl, "/head")
+	head, err := c.Head(ctx)
 	if err != nil {
 		return 0, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return 0, err
+	return head.Number, nil
+}
+
+// Head returns the latest block available from the portal, this block could still be reorged
+func (c *SoldexerClient) Head(ctx context.Context) (*BlockRef, error) {
+	return c.head.get(ctx, func(ctx context.Context) (*BlockRef, error) {
+		head := &BlockRef{}
+		err := c.get(ctx, "/head", head)
+		if err != nil {
+			return nil, err
+		}
+
+		return head, nil
+	})
+}
+
+// FinalizedHead returns the latest finalized block available from the portal
+func (c *SoldexerClient) FinalizedHead(ctx context.Context) (*BlockRef, error) {
+	return c.finalizedHead.get(ctx, func(ctx context.Context) (*BlockRef, error) {
+		var head *BlockRef
+		err := c.get(ctx, "/finalized-head", &head)
+		if err != nil {
+			return nil, err
+		}
+
+		if head == nil {
+			return nil, fmt.Errorf("Finalized head is not available")
+		}
+
+		return head, nil
+	})
+}
+
+// Metadata returns the dataset metadata, it is refreshed every METADATA_REFRESH_INTERVAL.
+// If refreshing fails the previous metadata is returned
+func (c *SoldexerClient) Metadata(ctx context.Context) (*NetworkMeta, error) {
+	c.metaMu.Lock()
+	defer c.metaMu.Unlock()
+
+	if c.meta != nil && time.Since(c.metaFetchedAt) < METADATA_REFRESH_INTERVAL {
+		return c.meta, nil
 	}
 
-	res, err := http.DefaultClient.Do(req)
+	metaRes := &metaResponse{}
+	err := c.get(ctx, "/metadata", metaRes)
 	if err != nil {
-		return 0, err
+		if c.meta != nil {
+			slog.WarnContext(ctx, "Failed to refresh metadata, using previous metadata", "error", err)
+			return c.meta, nil
+		}
+		return nil, err
 	}
 
-	defer res.Body.Close()
-
-	if res.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("Bad response code: %s", res.Status)
+	chainId := metaRes.Dataset
+	if len(metaRes.Aliases) > 0 {
+		chainId = metaRes.Aliases[0]
 	}
 
-	resBody, err := io.ReadAll(res.Body)
-	if err != nil {
-		return 0, err
+	meta := &NetworkMeta{
+		StartBlock:  metaRes.StartBlock,
+		ChainId:     chainId,
+		GenesisHash: c.network.GenesisHash,
+		Dataset:     metaRes.Dataset,
+		Aliases:     metaRes.Aliases,
+		RealTime:    metaRes.RealTime,
 	}
 
-	headRes := &headResponse{}
-	err = json.Unmarshal(resBody, headRes)
-	if err != nil {
-		return 0, err
+	if c.meta != nil && c.meta.StartBlock != meta.StartBlock {
+		slog.InfoContext(ctx, "Dataset start block changed", "previous", c.meta.StartBlock, "current", meta.StartBlock)
 	}
 
-	return headRes.Number, nil
+	c.meta = meta
+	c.metaFetchedAt = time.Now()
+
+	return meta, nil
 }
 
-func (c *SoldexerClient) Metadata(ctx context.Context) (*NetworkMeta, error) {
-	if c.meta != nil {
-		return c.meta, nil
-	}
-	url, err := url.JoinPath(c.baseUrl, "/metadata")
+// get makes a GET request to the portal and unmarshals the JSON response into out
+func (c *SoldexerClient) get(ctx context.Context, path string, out interface{}) error {
+	url, err := url.JoinPath(c.baseUrl, path)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	res, err := http.DefaultClient.Do(req)
+	start := time.Now()
+	res, err := c.client.Do(req)
 	if err != nil {
-		return nil, err
+		metrics.ObservePortal(path, 0, start)
+		return err
 	}
 
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Bad response code: %s", res.Status)
+		metrics.ObservePortal(path, res.StatusCode, start)
+		return fmt.Errorf("Bad response code: %s", res.Status)
 	}
 
 	resBody, err := io.ReadAll(res.Body)
+	metrics.ObservePortal(path, res.StatusCode, start)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	slog.DebugContext(ctx, "Portal response", "path", path, "bytes", len(resBody), "duration", time.Since(start))
 
-	metaRes := &metaResponse{}
-	err = json.Unmarshal(resBody, metaRes)
+	return json.Unmarshal(resBody, out)
+}
+
+func (c *SoldexerClient) Query(ctx context.Context, solReq SolanaRequest, limit *int) ([]SolanaBlockResponse, error) {
+	blocks := []SolanaBlockResponse{}
+	err := c.QueryStream(ctx, solReq, limit, func(block SolanaBlockResponse) error {
+		blocks = append(blocks, block)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return blocks, nil
+}
 
-	chainId := metaRes.Dataset
-	if len(metaRes.Aliases) > 0 {
-		chainId = metaRes.Aliases[0]
+// QueryStream passes each block to onBlock as it is read from the portal, cached responses are passed on once decoded
+func (c *SoldexerClient) QueryStream(ctx context.Context, solReq SolanaRequest, limit *int, onBlock func(SolanaBlockResponse) error) (err error) {
+	ctx, span := tracing.Start(ctx, "SoldexerClient.Query",
+		attribute.Int("sqd.from_block", int(solReq.FromBlock)),
+		attribute.Int("sqd.to_block", int(solReq.ToBlock)),
+	)
+	defer tracing.End(span, &err)
+
+	cacheable := false
+	key := ""
+	if c.cache != nil {
+		// Only finalized ranges can be cached as unfinalized blocks could be reorged
+		finalized, err := c.FinalizedHead(ctx)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to get finalized head, skipping cache", "error", err)
+		}
+		cacheable = err == nil && solReq.ToBlock <= finalized.Number
+
+		key, err = cacheKey(solReq, limit)
+		if err != nil {
+			return err
+		}
 	}
 
-	meta := &NetworkMeta{
-		StartBlock:  metaRes.StartBlock,
-		ChainId:     chainId,
-		GenesisHash: meta.MAINNET.GenesisHash, // TODO make configurable
+	if cacheable {
+		if raw, ok := c.cache.Get(key); ok {
+			span.SetAttributes(attribute.Bool("sqd.cache_hit", true))
+			blocks, err := decodeBlocks(raw)
+			if err != nil {
+				return err
+			}
+			for _, block := range blocks {
+				if err := onBlock(block); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
 	}
 
-	c.meta = meta
+	// Cacheable responses are kept until they are complete
+	var buf *bytes.Buffer
+	if cacheable {
+		buf = &bytes.Buffer{}
+	}
 
-	return meta, nil
+	err = c.query(ctx, solReq, limit, func(raw json.RawMessage) error {
+		var block SolanaBlockResponse
+		if err := json.Unmarshal(raw, &block); err != nil {
+			return err
+		}
+		if buf != nil {
+			buf.Write(raw)
+			buf.WriteByte('\n')
+		}
+		return onBlock(block)
+	})
+	if err != nil {
+		return err
+	}
+
+	if buf != nil {
+		c.cache.Set(key, buf.Bytes())
+	}
+	return nil
 }
 
-func (c *SoldexerClient) Query(ctx context.Context, solReq SolanaRequest, limit *int) ([]SolanaBlockResponse, error) {
+// cacheKey normalizes a request and limit to a cache key
+func cacheKey(solReq SolanaRequest, limit *int) (string, error) {
+	rawReq, err := json.Marshal(solReq)
+	if err != nil {
+		return "", err
+	}
+
+	if limit == nil {
+		return string(rawReq), nil
+	}
+	return fmt.Sprintf("%s:%d", rawReq, *limit), nil
+}
+
+// decodeBlocks decodes newline delimited blocks
+func decodeBlocks(raw []byte) ([]SolanaBlockResponse, error) {
+	solanaRes := []SolanaBlockResponse{}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	for {
+		var item SolanaBlockResponse
+		if err := dec.Decode(&item); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		solanaRes = append(solanaRes, item)
+	}
+
+	return solanaRes, nil
+}
+
+// query runs a stream request and passes each block to onItem as it is read, stopping once limit blocks are read
+func (c *SoldexerClient) query(ctx context.Context, solReq SolanaRequest, limit *int, onItem func(json.RawMessage) error) error {
 	url, err := url.JoinPath(c.baseUrl, "/stream")
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	rawReq, err := json.Marshal(solReq)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	slog.DebugContext(ctx, "Portal query", "bytes", len(rawReq), "body", string(rawReq))
 
 	cancelCtx, cancelFn := context.WithCancel(ctx)
+	defer cancelFn()
 
 	req, err := http.NewRequestWithContext(cancelCtx, "POST", url, bytes.NewBuffer(rawReq))
+	if err != nil {
+		return err
+	}
 	req.Header.Set("Content-Type", "application/json")
 
-	res, err := http.DefaultClient.Do(req)
+	start := time.Now()
+	res, err := c.client.Do(req)
 	if err != nil {
-		slog.Error("failed to run query", "error", err)
-		return nil, err
+		metrics.ObservePortal("/stream", 0, start)
+		slog.ErrorContext(ctx, "Failed to run query", "error", err)
+		return err
 	}
 
 	defer res.Body.Close()
+	defer func() { metrics.ObservePortal("/stream", res.StatusCode, start) }()
+
+	if res.StatusCode == http.StatusConflict {
+		forkErr := &ForkError{}
+		if err := json.NewDecoder(res.Body).Decode(forkErr); err != nil {
+			slog.ErrorContext(ctx, "Failed to read fork response", "error", err)
+		}
+		return forkErr
+	}
 
 	if res.StatusCode != http.StatusOK {
 		rawRes, err := io.ReadAll(res.Body)
 		if err != nil {
-			slog.Error("failed to read query body", "status", res.Status, "error", err)
-			return nil, fmt.Errorf("Bad response code: %s\n%v", res.Status, "Failed to read body")
+			slog.ErrorContext(ctx, "Failed to read query body", "status", res.Status, "error", err)
+			return fmt.Errorf("Bad response code: %s\n%v", res.Status, "Failed to read body")
 		}
-		slog.Error("Request failed", "status", res.Status)
-		return nil, fmt.Errorf("Bad response code: %s\n%v", res.Status, string(rawRes))
+		slog.ErrorContext(ctx, "Request failed", "status", res.Status)
+		return fmt.Errorf("Bad response code: %s\n%v", res.Status, string(rawRes))
 	}
 
-	solanaRes := []SolanaBlockResponse{}
-
 	dec := json.NewDecoder(res.Body)
+	count := 0
+	size := 0
+	defer func() {
+		metrics.AddPortalBlocks(count, size)
+		slog.DebugContext(ctx, "Portal query response", "blocks", count, "bytes", size, "duration", time.Since(start))
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int("sqd.blocks", count))
+	}()
 
 	// Read JSON values one at a time
 	for {
-		var item SolanaBlockResponse
+		var item json.RawMessage
 		if err := dec.Decode(&item); err != nil {
 			if err == io.EOF {
 				break
 			}
-			return nil, err
+			return err
+		}
+		count++
+		size += len(item) + 1
+		if err := onItem(item); err != nil {
+			return err
 		}
-		solanaRes = append(solanaRes, item)
 
-		// Limit reached, cancel the request end return
-		if limit != nil && len(solanaRes) >= *limit {
-			cancelFn()
+		// Limit reached, the request is cancelled on return
+		if limit != nil && count >= *limit {
 			break
 		}
 	}
 
-	return solanaRes, nil
+	return nil
 }
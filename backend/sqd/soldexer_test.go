@@ -1,27 +1,34 @@
-package sqd
+package sqd_test
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
-)
+	"time"
 
-const SOLDEXER_URL = "https://portal.sqd.dev/datasets/solana-beta"
+	"github.com/subquery/solana-takoyaki/backend/sqd"
+	"github.com/subquery/solana-takoyaki/backend/sqd/sqdtest"
+	"github.com/subquery/solana-takoyaki/cache"
+)
 
-var SOLDEXER_FULL_BLOCK_REQUEST = SolanaRequest{
+var SOLDEXER_FULL_BLOCK_REQUEST = sqd.SolanaRequest{
 	Type:          "solana",
-	FromBlock:     327_347_682, // Slot
-	ToBlock:       327_347_682, // Slot
-	Fields:        ALL_SOLDEXER_FIELDS,
-	Transactions:  []TransactionRequest{{}}, // Empty item means no filter
-	Instructions:  []InstructionRequest{{}},
-	Rewards:       []RewardRequest{{}},
-	TokenBalances: []TokenBalanceRequest{{}},
-	Balances:      []BalancesRequest{{}},
-	Logs:          []LogRequest{{}},
+	FromBlock:     sqdtest.FIRST_SLOT, // Slot
+	ToBlock:       sqdtest.FIRST_SLOT, // Slot
+	Fields:        sqd.ALL_SOLDEXER_FIELDS,
+	Transactions:  []sqd.TransactionRequest{{}}, // Empty item means no filter
+	Instructions:  []sqd.InstructionRequest{{}},
+	Rewards:       []sqd.RewardRequest{{}},
+	TokenBalances: []sqd.TokenBalanceRequest{{}},
+	Balances:      []sqd.BalancesRequest{{}},
+	Logs:          []sqd.LogRequest{{}},
 }
 
 func TestSoldexerGetCurrentHeight(t *testing.T) {
-	client := NewSoldexerClient(SOLDEXER_URL)
+	portal := sqdtest.NewPortal(t)
+	client := sqd.NewSoldexerClient(portal.URL)
 
 	ctx := context.Background()
 
@@ -30,61 +37,37 @@ func TestSoldexerGetCurrentHeight(t *testing.T) {
 		t.Fatalf("Failed to get current height: %v", err)
 	}
 
-	if height == 0 {
-		t.Fatal("Expected non-zero height")
+	if height != sqdtest.LAST_SLOT {
+		t.Fatalf("Expected height %v. Got: %v", sqdtest.LAST_SLOT, height)
 	}
 }
 
 func TestSoldexerGetMeta(t *testing.T) {
-	client := NewSoldexerClient(SOLDEXER_URL)
+	portal := sqdtest.NewPortal(t)
+	client := sqd.NewSoldexerClient(portal.URL)
 
 	ctx := context.Background()
 
 	meta, err := client.Metadata(ctx)
 	if err != nil {
-		t.Fatalf("Failed to get current height: %v", err)
+		t.Fatalf("Failed to get metadata: %v", err)
 	}
 
 	if meta.StartBlock <= 0 {
 		t.Fatal("Expected non-zero start block")
 	}
-
-	// if meta.Aliases[0] != "solana-mainnet" {
-	// 	t.Fatal("Expected non-zero height")
-	// }
 }
 
 func TestSoldexerQuery(t *testing.T) {
-	client := NewSoldexerClient(SOLDEXER_URL)
-
-	req := SolanaRequest{
-		Type:      "solana",
-		FromBlock: 327_347_682,
-		ToBlock:   327_347_682,
-		Fields:    ALL_SOLDEXER_FIELDS, /*Fields{
-			Instruction: map[string]bool{"programId": true},
-			Transaction: map[string]bool{
-				"accountKeys":         true,
-				"addressTableLookups": true,
-			},
-			Log: map[string]bool{
-				"kind": true,
-			},
-			Block: map[string]bool{
-				"parentHash": true,
-				"timestamp":  true,
-			},
-		},*/
-		Transactions: []TransactionRequest{{}}, // Empty item means no filter
-		Instructions: []InstructionRequest{{}},
-	}
+	portal := sqdtest.NewPortal(t)
+	client := sqd.NewSoldexerClient(portal.URL)
 
-	res, err := client.Query(context.Background(), req)
+	res, err := client.Query(context.Background(), SOLDEXER_FULL_BLOCK_REQUEST, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// https://solscan.io/block/327347682 (slot 327_347_682 = block 305_604_799)
+	// Modelled on https://solscan.io/block/327347682 (slot 327_347_682 = block 305_604_799)
 	block := res[0]
 	if block.Header.Height != 305_604_799 {
 		t.Errorf("Expected block height %v. Got: %v", 305_604_799, block.Header.Height)
@@ -94,13 +77,77 @@ func TestSoldexerQuery(t *testing.T) {
 		t.Errorf("Expected block hash %v. Got: %v", "5FqMrgbiEmh22E9puyX4RV2EnARvwYBHgsTKYQ9a52Er", block.Header.Hash)
 	}
 
-	// Doesn't include voting program transactions
-	if len(block.Transactions) != 441 {
-		t.Errorf("Expected %v transactions. Got %v transactions", 441, len(block.Transactions))
+	if len(block.Transactions) != 4 {
+		t.Errorf("Expected %v transactions. Got %v transactions", 4, len(block.Transactions))
 	}
 
-	// TODO check this is correct
-	if len(block.Instructions) != 3_568 {
-		t.Errorf("Expected %v instructions. Got %v instructions", 3_568, len(block.Instructions))
+	if len(block.Instructions) != 8 {
+		t.Errorf("Expected %v instructions. Got %v instructions", 8, len(block.Instructions))
+	}
+}
+
+func TestSoldexerQueryCache(t *testing.T) {
+	portal := sqdtest.NewPortal(t)
+	client := sqd.NewSoldexerClient(portal.URL)
+	client.SetCache(cache.New(cache.NewLRU(1 << 20)))
+
+	ctx := context.Background()
+
+	// Finalized ranges are only requested once
+	finalized := SOLDEXER_FULL_BLOCK_REQUEST
+	for range 2 {
+		if _, err := client.Query(ctx, finalized, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(portal.Requests()); n != 1 {
+		t.Errorf("Expected 1 request for a finalized range. Got %v", n)
+	}
+
+	// Unfinalized ranges are always requested
+	unfinalized := SOLDEXER_FULL_BLOCK_REQUEST
+	unfinalized.ToBlock = sqdtest.LAST_SLOT
+	for range 2 {
+		if _, err := client.Query(ctx, unfinalized, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(portal.Requests()); n != 3 {
+		t.Errorf("Expected 3 requests. Got %v", n)
+	}
+}
+
+// Blocks are passed on as they are read rather than once the portal response is complete
+func TestSoldexerQueryStream(t *testing.T) {
+	blocks := sqdtest.Blocks(t)
+	received := make(chan struct{})
+	portal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enc := json.NewEncoder(w)
+		enc.Encode(blocks[0])
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			return
+		}
+		enc.Encode(blocks[1])
+	}))
+	defer portal.Close()
+
+	client := sqd.NewSoldexerClient(portal.URL)
+	slots := []uint64{}
+	err := client.QueryStream(context.Background(), SOLDEXER_FULL_BLOCK_REQUEST, nil, func(block sqd.SolanaBlockResponse) error {
+		if len(slots) == 0 {
+			close(received)
+		}
+		slots = append(slots, block.Header.Slot)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(slots) != 2 || slots[1] != blocks[1].Header.Slot {
+		t.Errorf("Expected the first block before the response ended, got %v", slots)
 	}
 }
@@ -0,0 +1,277 @@
+// Package sqdtest provides an in-process SQD portal for tests.
+// The portal serves /head, /finalized-head, /metadata and /stream from fixtures in the portal format, evaluating stream requests with sqd.FilterBlock
+package sqdtest
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/subquery/solana-takoyaki/backend/sqd"
+)
+
+// Blocks at slots 327347682, 327347683 and 327347685 in the portal format with all fields, slot 327347684 is skipped.
+// They are modelled on mainnet blocks and include a failed v0 transaction with lookup tables, a swap with inner token transfers, a Token-2022 transfer and fee rewards
+//
+//go:embed testdata
+var fixtures embed.FS
+
+// The slots of the fixture blocks
+const (
+	FIRST_SLOT = 327_347_682
+	LAST_SLOT  = 327_347_685
+)
+
+// The number of blocks before the requested range included in conflict responses
+const PREVIOUS_BLOCKS = 10
+
+type Metadata struct {
+	Dataset    string   `json:"dataset"`
+	Aliases    []string `json:"aliases"`
+	RealTime   bool     `json:"real_time"`
+	StartBlock uint     `json:"start_block"`
+}
+
+// Portal is a fake portal, the fixtures can be replaced and requests inspected while it is running
+type Portal struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	blocks        []sqd.SolanaBlockResponse // Ordered by slot
+	head          *sqd.BlockRef
+	finalizedHead *sqd.BlockRef
+	metadata      Metadata
+	streamLimit   int
+	requests      []sqd.SolanaRequest
+}
+
+// NewPortal starts a portal serving the fixture blocks, it is closed when the test completes
+func NewPortal(t testing.TB) *Portal {
+	p := &Portal{
+		blocks: Blocks(t),
+	}
+	readFixture(t, "testdata/metadata.json", &p.metadata)
+	readFixture(t, "testdata/head.json", &p.head)
+	readFixture(t, "testdata/finalized-head.json", &p.finalizedHead)
+
+	p.Server = httptest.NewServer(p)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// NewPortalWithBlocks starts a portal serving the given blocks, the head and finalized head are the last block and the dataset starts at the first block
+func NewPortalWithBlocks(t testing.TB, blocks []sqd.SolanaBlockResponse) *Portal {
+	p := &Portal{
+		blocks:   blocks,
+		metadata: Metadata{Dataset: "solana-mainnet", Aliases: []string{"solana-mainnet"}, RealTime: true},
+	}
+	if len(blocks) > 0 {
+		last := blocks[len(blocks)-1].Header
+		p.head = &sqd.BlockRef{Number: uint(last.Slot), Hash: last.Hash}
+		p.finalizedHead = p.head
+		p.metadata.StartBlock = uint(blocks[0].Header.Slot)
+	}
+
+	p.Server = httptest.NewServer(p)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// Blocks returns the fixture blocks
+func Blocks(t testing.TB) []sqd.SolanaBlockResponse {
+	raw, err := fixtures.ReadFile("testdata/blocks.ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := []sqd.SolanaBlockResponse{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	for dec.More() {
+		var block sqd.SolanaBlockResponse
+		if err := dec.Decode(&block); err != nil {
+			t.Fatalf("Invalid fixture block: %v", err)
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+// Block returns the fixture block at slot
+func Block(t testing.TB, slot uint64) sqd.SolanaBlockResponse {
+	for _, block := range Blocks(t) {
+		if block.Header.Slot == slot {
+			return block
+		}
+	}
+	t.Fatalf("No fixture block at slot %v", slot)
+	return sqd.SolanaBlockResponse{}
+}
+
+func readFixture(t testing.TB, name string, out interface{}) {
+	raw, err := fixtures.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		t.Fatalf("Invalid fixture %v: %v", name, err)
+	}
+}
+
+// SetBlocks replaces the blocks, ordered by slot, the head isn't changed
+func (p *Portal) SetBlocks(blocks []sqd.SolanaBlockResponse) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.blocks = blocks
+}
+
+// SetHead sets the head, nil responds with an error
+func (p *Portal) SetHead(head *sqd.BlockRef) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.head = head
+}
+
+// SetFinalizedHead sets the finalized head, nil responds with null like a portal without finalized blocks
+func (p *Portal) SetFinalizedHead(head *sqd.BlockRef) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.finalizedHead = head
+}
+
+// SetStreamLimit ends each stream after limit blocks like the portal does for large ranges, 0 is unlimited
+func (p *Portal) SetStreamLimit(limit int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.streamLimit = limit
+}
+
+// Requests returns the stream requests received
+func (p *Portal) Requests() []sqd.SolanaRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]sqd.SolanaRequest{}, p.requests...)
+}
+
+func (p *Portal) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/head":
+		if p.head == nil {
+			http.Error(w, "Head is not available", http.StatusServiceUnavailable)
+			return
+		}
+		writeJson(w, http.StatusOK, p.head)
+	case "/finalized-head":
+		writeJson(w, http.StatusOK, p.finalizedHead)
+	case "/metadata":
+		writeJson(w, http.StatusOK, p.metadata)
+	case "/stream":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		p.stream(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// stream responds with the blocks in the requested range that match the request, like the portal the first and last blocks are always included
+func (p *Portal) stream(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req sqd.SolanaRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.FromBlock > req.ToBlock {
+		http.Error(w, "fromBlock is after toBlock", http.StatusBadRequest)
+		return
+	}
+	p.requests = append(p.requests, req)
+
+	inRange := []sqd.SolanaBlockResponse{}
+	previous := []sqd.BlockRef{}
+	for _, block := range p.blocks {
+		slot := uint(block.Header.Slot)
+		if slot < req.FromBlock {
+			previous = append(previous, sqd.BlockRef{Number: slot, Hash: block.Header.Hash})
+		} else if slot <= req.ToBlock {
+			inRange = append(inRange, block)
+		}
+	}
+
+	// The range is after the head, the portal responds without content once it times out waiting for new blocks
+	if len(inRange) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if req.ParentBlockHash != nil && inRange[0].Header.ParentHash != *req.ParentBlockHash {
+		previous = previous[max(0, len(previous)-PREVIOUS_BLOCKS):]
+		writeJson(w, http.StatusConflict, map[string][]sqd.BlockRef{"previousBlocks": previous})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/jsonl")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	count := 0
+	for i, block := range inRange {
+		filtered, ok := sqd.FilterBlock(block, req)
+		if !ok && i != 0 && i != len(inRange)-1 {
+			continue
+		}
+		if err := enc.Encode(newPortalBlock(filtered)); err != nil {
+			return
+		}
+		count++
+		if p.streamLimit > 0 && count >= p.streamLimit {
+			return
+		}
+	}
+}
+
+type portalHeader struct {
+	Number       uint64 `json:"number"`
+	Height       uint64 `json:"height"`
+	Hash         string `json:"hash"`
+	ParentNumber uint64 `json:"parentNumber"`
+	ParentHash   string `json:"parentHash"`
+	Timestamp    int64  `json:"timestamp"`
+}
+
+// portalBlock encodes the header the way the portal does, the number is the slot
+type portalBlock struct {
+	sqd.SolanaBlockResponse
+	Header portalHeader `json:"header"`
+}
+
+func newPortalBlock(block sqd.SolanaBlockResponse) portalBlock {
+	return portalBlock{block, portalHeader{
+		Number:       block.Header.Slot,
+		Height:       block.Header.Height,
+		Hash:         block.Header.Hash,
+		ParentNumber: block.Header.ParentSlot,
+		ParentHash:   block.Header.ParentHash,
+		Timestamp:    block.Header.Timestamp,
+	}}
+}
+
+func writeJson(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,93 @@
+package sqdtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/subquery/solana-takoyaki/backend/sqd"
+)
+
+const JUPITER_PROGRAM_ID = "JUP6LkbZbjS1jKKwapdHNy74zcZ3tLUZoi5QNyVTaV4"
+
+func TestPortal(t *testing.T) {
+	portal := NewPortal(t)
+	client := sqd.NewSoldexerClient(portal.URL)
+	ctx := context.Background()
+
+	head, err := client.Head(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Number != LAST_SLOT {
+		t.Errorf("Expected head %v, got %v", LAST_SLOT, head.Number)
+	}
+
+	meta, err := client.Metadata(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ChainId != "solana-mainnet" || meta.StartBlock == 0 {
+		t.Errorf("Unexpected metadata %+v", meta)
+	}
+
+	// Only the first and last blocks and blocks with Jupiter instructions are returned
+	blocks, err := client.Query(ctx, sqd.SolanaRequest{
+		Type:         "solana",
+		FromBlock:    FIRST_SLOT,
+		ToBlock:      LAST_SLOT,
+		Instructions: []sqd.InstructionRequest{{ProgramId: []string{JUPITER_PROGRAM_ID}, InnerInstructions: true}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 {
+		t.Fatalf("Expected 2 blocks, got %v", len(blocks))
+	}
+	first := blocks[0]
+	if first.Header.Slot != FIRST_SLOT || first.Header.Height != 305_604_799 || first.Header.ParentSlot != FIRST_SLOT-1 {
+		t.Errorf("Unexpected header %+v", first.Header)
+	}
+	// The route and its 2 inner token transfers
+	if len(first.Instructions) != 3 || len(first.Transactions) != 0 {
+		t.Errorf("Expected 3 instructions and no transactions, got %v and %v", len(first.Instructions), len(first.Transactions))
+	}
+	if blocks[1].Header.Slot != LAST_SLOT || len(blocks[1].Instructions) != 2 {
+		t.Errorf("Expected the last block with 2 instructions, got %v with %v", blocks[1].Header.Slot, len(blocks[1].Instructions))
+	}
+
+	if len(portal.Requests()) != 1 {
+		t.Errorf("Expected 1 request, got %v", len(portal.Requests()))
+	}
+}
+
+func TestPortalStreamLimit(t *testing.T) {
+	portal := NewPortal(t)
+	portal.SetStreamLimit(1)
+	client := sqd.NewSoldexerClient(portal.URL)
+
+	includeAll := true
+	blocks, err := client.Query(context.Background(), sqd.SolanaRequest{Type: "solana", FromBlock: FIRST_SLOT, ToBlock: LAST_SLOT, IncludeAllBlocks: &includeAll}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 1 {
+		t.Errorf("Expected the stream to end after 1 block, got %v", len(blocks))
+	}
+}
+
+func TestPortalConflict(t *testing.T) {
+	portal := NewPortal(t)
+	client := sqd.NewSoldexerClient(portal.URL)
+
+	parentHash := "unknown"
+	_, err := client.Query(context.Background(), sqd.SolanaRequest{Type: "solana", FromBlock: LAST_SLOT, ToBlock: LAST_SLOT, ParentBlockHash: &parentHash}, nil)
+
+	var forkErr *sqd.ForkError
+	if !errors.As(err, &forkErr) {
+		t.Fatalf("Expected fork error, got %v", err)
+	}
+	if len(forkErr.PreviousBlocks) != 2 || forkErr.PreviousBlocks[1].Number != FIRST_SLOT+1 {
+		t.Errorf("Unexpected previous blocks %v", forkErr.PreviousBlocks)
+	}
+}
@@ -2,7 +2,7 @@ package sqd
 
 import (
 	"fmt"
-	"math/big"
+	"maps"
 	"slices"
 	"sort"
 	"strconv"
@@ -10,7 +10,27 @@ import (
 	"github.com/subquery/solana-takoyaki/solana"
 )
 
+// TransformWarning describes a transaction or reward that was skipped by lenient transformation
+type TransformWarning struct {
+	Slot             uint64 `json:"slot"`
+	TransactionIndex *uint  `json:"transactionIndex,omitempty"`
+	Signature        string `json:"signature,omitempty"`
+	RewardPubkey     string `json:"rewardPubkey,omitempty"`
+	Message          string `json:"message"`
+}
+
+// TransformBlock converts an SQD block to the RPC format, any transaction or reward that fails to transform is an error
 func TransformBlock(sqdBlock SolanaBlockResponse) (out *solana.Block, err error) {
+	out, _, err = transformBlock(sqdBlock, false)
+	return out, err
+}
+
+// TransformBlockLenient converts an SQD block to the RPC format, transactions and rewards that fail to transform are skipped and a warning is returned for each
+func TransformBlockLenient(sqdBlock SolanaBlockResponse) (out *solana.Block, warnings []TransformWarning, err error) {
+	return transformBlock(sqdBlock, true)
+}
+
+func transformBlock(sqdBlock SolanaBlockResponse, lenient bool) (out *solana.Block, warnings []TransformWarning, err error) {
 
 	out = &solana.Block{
 		BlockHeight:       sqdBlock.Header.Height,
@@ -20,34 +40,35 @@ func TransformBlock(sqdBlock SolanaBlockResponse) (out *solana.Block, err error)
 		BlockTime:         sqdBlock.Header.Timestamp,
 	}
 
+	// Errors of each transaction, these are collected so transactions can be skipped in lenient mode
+	txErrs := txErrors{}
+
+	// Transactions are looked up for every instruction and balance, index them once rather than scanning the block each time
+	txs := indexTransactions(sqdBlock.Transactions)
+
 	// Transform Token Balances
-	preTokenBalances, postTokenBalances, err := groupTokenBalances(sqdBlock.TokenBalances, sqdBlock.Transactions)
-	if err != nil {
-		return nil, err
-	}
+	preTokenBalances, postTokenBalances := groupTokenBalances(sqdBlock.TokenBalances, txs, txErrs)
 
 	// Transform Balances
-	preBalances, postBalances, err := groupBalances(sqdBlock.Balances, sqdBlock.Transactions)
-	if err != nil {
-		return nil, err
-	}
+	preBalances, postBalances := groupBalances(sqdBlock.Balances, txs, txErrs)
 
 	// Transform instructions
-	instructions, innerInstructions, err := groupInstructions(sqdBlock.Instructions, sqdBlock.Transactions)
-	if err != nil {
-		return nil, err
-	}
+	instructions, innerInstructions := groupInstructions(sqdBlock.Instructions, txs, txErrs)
 
 	logs, err := groupLogs(sqdBlock.Logs)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Transform Transactions
 	if out.Transactions == nil {
-		out.Transactions = []solana.Transaction{}
+		out.Transactions = make([]solana.Transaction, 0, len(sqdBlock.Transactions))
 	}
 	for _, tx := range sqdBlock.Transactions {
+		if _, failed := txErrs[tx.TransactionIndex]; failed {
+			continue
+		}
+
 		inner := innerInstructions[tx.TransactionIndex]
 		if inner == nil {
 			inner = []solana.InnerInstruction{}
@@ -56,32 +77,56 @@ func TransformBlock(sqdBlock SolanaBlockResponse) (out *solana.Block, err error)
 		if txLogs == nil {
 			txLogs = []solana.Log{}
 		}
+		// The RPC has empty lists for transactions without token accounts
+		preTokens, postTokens := preTokenBalances[tx.TransactionIndex], postTokenBalances[tx.TransactionIndex]
+		if preTokens == nil {
+			preTokens = []solana.TokenBalance{}
+		}
+		if postTokens == nil {
+			postTokens = []solana.TokenBalance{}
+		}
 
 		solanaTx, err := TransformTransaction(
 			tx,
 			sqdBlock.Header,
 			preBalances[tx.TransactionIndex],
 			postBalances[tx.TransactionIndex],
-			preTokenBalances[tx.TransactionIndex],
-			postTokenBalances[tx.TransactionIndex],
+			preTokens,
+			postTokens,
 			instructions[tx.TransactionIndex],
 			inner,
 			txLogs,
 		)
 		if err != nil {
-			return nil, err
+			txErrs.add(tx.TransactionIndex, err)
+			continue
 		}
 		out.Transactions = append(out.Transactions, *solanaTx)
 	}
 
+	if len(txErrs) > 0 {
+		if !lenient {
+			return nil, nil, txErrs.first()
+		}
+		warnings = append(warnings, txErrs.warnings(sqdBlock.Header.Slot, txs)...)
+	}
+
 	// Transform Rewards
 	if out.Rewards == nil {
-		out.Rewards = []solana.BlockReward{}
+		out.Rewards = make([]solana.BlockReward, 0, len(sqdBlock.Rewards))
 	}
 	for _, reward := range sqdBlock.Rewards {
 		solanaReward, err := TransformReward(reward)
 		if err != nil {
-			return nil, err
+			if !lenient {
+				return nil, nil, err
+			}
+			warnings = append(warnings, TransformWarning{
+				Slot:         sqdBlock.Header.Slot,
+				RewardPubkey: reward.Pubkey,
+				Message:      err.Error(),
+			})
+			continue
 		}
 		out.Rewards = append(out.Rewards, *solanaReward)
 	}
@@ -92,7 +137,39 @@ func TransformBlock(sqdBlock SolanaBlockResponse) (out *solana.Block, err error)
 	}
 	// TODO fill signatures
 
-	return out, nil
+	return out, warnings, nil
+}
+
+// txErrors is the first error of each transaction by transaction index
+type txErrors map[uint]error
+
+func (e txErrors) add(txIdx uint, err error) {
+	if _, ok := e[txIdx]; !ok {
+		e[txIdx] = err
+	}
+}
+
+// first returns the error of the lowest transaction index
+func (e txErrors) first() error {
+	return e[slices.Min(slices.Collect(maps.Keys(e)))]
+}
+
+// warnings returns a warning for each failed transaction ordered by transaction index
+func (e txErrors) warnings(slot uint64, txs txIndex) []TransformWarning {
+	indexes := slices.Sorted(maps.Keys(e))
+	warnings := make([]TransformWarning, 0, len(indexes))
+	for _, txIdx := range indexes {
+		warning := TransformWarning{
+			Slot:             slot,
+			TransactionIndex: &txIdx,
+			Message:          e[txIdx].Error(),
+		}
+		if tx, err := txs.get(txIdx); err == nil && len(tx.Signatures) > 0 {
+			warning.Signature = tx.Signatures[0]
+		}
+		warnings = append(warnings, warning)
+	}
+	return warnings
 }
 
 func TransformTransaction(
@@ -146,28 +223,52 @@ func TransformTransaction(
 		Transaction: &solana.JSONTransaction{
 			Signatures: in.Signatures,
 			Message: solana.Message{
-				AccountKeys:  in.AccountKeys,
-				Instructions: instructions,
+				AccountKeys: in.AccountKeys,
+				Header: solana.MessageHeader{
+					NumRequiredSignatures:       uint8(in.NumRequiredSignatures),
+					NumReadonlySignedAccounts:   uint8(in.NumReadonlySignedAccounts),
+					NumReadonlyUnsignedAccounts: uint8(in.NumReadonlyUnsignedAccounts),
+				},
+				RecentBlockhash:     in.RecentBlockhash, // Not yet available from SQD
+				Instructions:        instructions,
+				AddressTableLookups: transformAddressTableLookups(in.AddressTableLookups),
 			},
 		},
+		Version: in.Version,
 	}
 
 	return out, nil
 }
 
+func transformAddressTableLookups(in []addressTableLookup) []solana.MessageAddressTableLookup {
+	out := make([]solana.MessageAddressTableLookup, 0, len(in))
+	for _, lookup := range in {
+		out = append(out, solana.MessageAddressTableLookup{
+			AccountKey:      lookup.AccountKey,
+			WritableIndexes: lookup.WritableIndexes,
+			ReadonlyIndexes: lookup.ReadonlyIndexes,
+		})
+	}
+	return out
+}
+
 func TransformInstruction(in instruction, tx transaction) (out *solana.CompiledInstruction, err error) {
-	accounts := []uint16{}
+	return transformInstruction(in, newIndexedTransaction(&tx))
+}
+
+func transformInstruction(in instruction, tx *indexedTransaction) (out *solana.CompiledInstruction, err error) {
+	accounts := make([]uint16, 0, len(in.Accounts))
 	for _, account := range in.Accounts {
-		idx, err := findAddressIndex(account, tx)
+		idx, err := tx.accountIndex(account)
 		if err != nil {
 			return nil, err
 		}
 		accounts = append(accounts, uint16(idx))
 	}
 
-	programIdIndex, err := findAddressIndex(in.ProgramId, tx)
+	programIdIndex, err := tx.accountIndex(in.ProgramId)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to find programIdIndex: %v", tx)
+		return nil, fmt.Errorf("Unable to find programIdIndex: %v", *tx.transaction)
 	}
 
 	out = &solana.CompiledInstruction{
@@ -178,27 +279,49 @@ func TransformInstruction(in instruction, tx transaction) (out *solana.CompiledI
 	return out, nil
 }
 
-func findAddressIndex(account string, tx transaction) (int, error) {
-	idx := slices.Index(tx.AccountKeys, account)
-	if idx >= 0 {
-		return idx, nil
+// indexedTransaction is a transaction with a map of its account keys to their index in the transaction
+type indexedTransaction struct {
+	*transaction
+	accounts map[string]int
+}
+
+func newIndexedTransaction(tx *transaction) *indexedTransaction {
+	accounts := make(map[string]int, len(tx.AccountKeys)+len(tx.LoadedAddresses.Writable)+len(tx.LoadedAddresses.Readonly))
+	// Loaded writable addresses follow the account keys, then the loaded readonly addresses.
+	// An account can appear more than once, the first index is used
+	for i, account := range slices.Concat(tx.AccountKeys, tx.LoadedAddresses.Writable, tx.LoadedAddresses.Readonly) {
+		if _, ok := accounts[account]; !ok {
+			accounts[account] = i
+		}
 	}
+	return &indexedTransaction{tx, accounts}
+}
 
-	// Try the Loaded Addresses Writable with an offset of AccountKeys
-	wLookupIdx := slices.Index(tx.LoadedAddresses.Writable, account)
-	if wLookupIdx >= 0 {
-		accountIdx := len(tx.AccountKeys) + wLookupIdx
-		return accountIdx, nil
+func (tx *indexedTransaction) accountIndex(account string) (int, error) {
+	idx, ok := tx.accounts[account]
+	if !ok {
+		return -1, fmt.Errorf("Unable to find account key: %v", account)
 	}
+	return idx, nil
+}
 
-	// Try the Loaded Addresses Readable with an offset of AccountKeys + Writable
-	rLookupIdx := slices.Index(tx.LoadedAddresses.Readonly, account)
-	if rLookupIdx >= 0 {
-		accountIdx := len(tx.AccountKeys) + len(tx.LoadedAddresses.Writable) + rLookupIdx
-		return accountIdx, nil
+// txIndex is the transactions of a block by transaction index
+type txIndex map[uint]*indexedTransaction
+
+func indexTransactions(txs []transaction) txIndex {
+	index := make(txIndex, len(txs))
+	for i := range txs {
+		index[txs[i].TransactionIndex] = newIndexedTransaction(&txs[i])
 	}
+	return index
+}
 
-	return -1, fmt.Errorf("Unable to find account key: %v", account)
+func (idx txIndex) get(txIdx uint) (*indexedTransaction, error) {
+	tx, ok := idx[txIdx]
+	if !ok {
+		return nil, fmt.Errorf("Unable to find transaction with index: %v", txIdx)
+	}
+	return tx, nil
 }
 
 func TransformReward(in reward) (*solana.BlockReward, error) {
@@ -210,6 +333,9 @@ func TransformReward(in reward) (*solana.BlockReward, error) {
 	if err != nil {
 		return nil, err
 	}
+	if in.RewardType == nil {
+		return nil, fmt.Errorf("Reward type is missing for %v", in.Pubkey)
+	}
 
 	out := &solana.BlockReward{
 		Pubkey:      in.Pubkey,
@@ -223,10 +349,13 @@ func TransformReward(in reward) (*solana.BlockReward, error) {
 }
 
 func TransformTokenBalance(in tokenBalance, tx transaction) (pre *solana.TokenBalance, post *solana.TokenBalance, err error) {
+	return transformTokenBalance(in, newIndexedTransaction(&tx))
+}
+
+func transformTokenBalance(in tokenBalance, tx *indexedTransaction) (pre *solana.TokenBalance, post *solana.TokenBalance, err error) {
 	parse := func(owner, programId *string, mint, amount string, decimals uint8) (*solana.TokenBalance, error) {
 
-		amountBF, success := new(big.Float).SetString(amount)
-		if !success {
+		if _, err := strconv.ParseUint(amount, 10, 64); err != nil {
 			return nil, fmt.Errorf("Unable to parse amount %v", amount)
 		}
 
@@ -238,13 +367,12 @@ func TransformTokenBalance(in tokenBalance, tx transaction) (pre *solana.TokenBa
 
 		// Values are only set for non-zero amounts
 		if amount != "0" {
-			uiAmount := shiftDecimalPlacesLeft(*amountBF, int64(decimals))
-			floatAmount, _ := uiAmount.Float64()
+			uiTokenAmount.UiAmountString = solana.ShiftDecimals(amount, decimals)
+			floatAmount, _ := strconv.ParseFloat(uiTokenAmount.UiAmountString, 64)
 			uiTokenAmount.UiAmount = &floatAmount
-			uiTokenAmount.UiAmountString = uiAmount.Text(byte('f'), -1)
 		}
 
-		idx, err := findAddressIndex(in.Account, tx)
+		idx, err := tx.accountIndex(in.Account)
 		if err != nil {
 			return nil, err
 		}
@@ -290,28 +418,19 @@ func TransformLog(log logMessage) (out solana.Log) {
 	}
 }
 
-func shiftDecimalPlacesLeft(input big.Float, places int64) *big.Float {
-	// Compute 10^places as a *big.Float
-	exp := new(big.Float).SetFloat64(1)
-	ten := big.NewFloat(10)
-
-	for i := int64(0); i < places; i++ {
-		exp.Mul(exp, ten)
-	}
-	return input.Quo(&input, exp)
-}
-
-func groupInstructions(instructions []instruction, txs []transaction) (out map[uint][]solana.CompiledInstruction, inner map[uint][]solana.InnerInstruction, err error) {
+func groupInstructions(instructions []instruction, txs txIndex, txErrs txErrors) (out map[uint][]solana.CompiledInstruction, inner map[uint][]solana.InnerInstruction) {
 	out = map[uint][]solana.CompiledInstruction{}
 	innerInternal := map[uint]map[uint64]*solana.InnerInstruction{}
 	for _, instruction := range instructions {
-		tx, err := getTransactionByIndex(txs, instruction.TransactionIndex)
+		tx, err := txs.get(instruction.TransactionIndex)
 		if err != nil {
-			return nil, nil, fmt.Errorf("Unable to find transaction for instruction: %v", err)
+			txErrs.add(instruction.TransactionIndex, fmt.Errorf("Unable to find transaction for instruction: %v", err))
+			continue
 		}
-		inst, err := TransformInstruction(instruction, *tx)
+		inst, err := transformInstruction(instruction, tx)
 		if err != nil {
-			return nil, nil, err
+			txErrs.add(instruction.TransactionIndex, err)
+			continue
 		}
 
 		// Inner instructions have an array len > 1. See instruction struct definition for more info
@@ -324,6 +443,9 @@ func groupInstructions(instructions []instruction, txs []transaction) (out map[u
 			if innerInternal[instruction.TransactionIndex] == nil {
 				innerInternal[instruction.TransactionIndex] = map[uint64]*solana.InnerInstruction{}
 			}
+			stackHeight := uint16(len(instruction.InstructionAddress))
+			inst.StackHeight = &stackHeight
+
 			innerIdx := instruction.InstructionAddress[0]
 			if innerInternal[instruction.TransactionIndex][innerIdx] == nil {
 				innerInternal[instruction.TransactionIndex][innerIdx] = &solana.InnerInstruction{
@@ -336,18 +458,18 @@ func groupInstructions(instructions []instruction, txs []transaction) (out map[u
 		}
 	}
 
-	// Flatten inner instructions
-	inner = map[uint][]solana.InnerInstruction{}
+	// Flatten inner instructions, ordered by the index of the outer instruction like the RPC
+	inner = make(map[uint][]solana.InnerInstruction, len(innerInternal))
 	for txIdx, innerInst := range innerInternal {
-		for _, inst := range innerInst {
-			inner[txIdx] = append(inner[txIdx], *inst)
+		for _, innerIdx := range slices.Sorted(maps.Keys(innerInst)) {
+			inner[txIdx] = append(inner[txIdx], *innerInst[innerIdx])
 		}
 	}
 
-	return out, inner, nil
+	return out, inner
 }
 
-func groupBalances(in []balance, txs []transaction) (preBalances, postBalances map[uint][]uint64, err error) {
+func groupBalances(in []balance, txs txIndex, txErrs txErrors) (preBalances, postBalances map[uint][]uint64) {
 	preBalances = map[uint][]uint64{}
 	postBalances = map[uint][]uint64{}
 
@@ -358,40 +480,40 @@ func groupBalances(in []balance, txs []transaction) (preBalances, postBalances m
 	}
 
 	for txIdx, txBals := range bxs {
-		tx, err := getTransactionByIndex(txs, txIdx)
+		tx, err := txs.get(txIdx)
 		if err != nil {
-			return nil, nil, err
+			txErrs.add(txIdx, err)
+			continue
 		}
 
-		if tx == nil {
-			return nil, nil, fmt.Errorf("Unable to find transaction for index %v", txIdx)
+		// Sort the balances by the account index in the transaction, unknown accounts sort first.
+		// The indexes are looked up once rather than in the comparator
+		type indexedBalance struct {
+			accountIdx int
+			balance
 		}
-
-		// Sort the balances by the account index in the transaction
-		slices.SortFunc(txBals, func(i, j balance) int {
-			accountIdxI, _ := findAddressIndex(i.Account, *tx)
-			accountIdxJ, _ := findAddressIndex(j.Account, *tx)
-
-			return int(accountIdxI) - int(accountIdxJ)
+		sorted := make([]indexedBalance, len(txBals))
+		for i, bal := range txBals {
+			accountIdx, _ := tx.accountIndex(bal.Account)
+			sorted[i] = indexedBalance{accountIdx, bal}
+		}
+		slices.SortStableFunc(sorted, func(i, j indexedBalance) int {
+			return i.accountIdx - j.accountIdx
 		})
 
 		// Split the balances into pre and post balances
-		for _, bal := range txBals {
-			if preBalances[txIdx] == nil {
-				preBalances[txIdx] = []uint64{}
-			}
-			if postBalances[txIdx] == nil {
-				postBalances[txIdx] = []uint64{}
-			}
-
+		preBalances[txIdx] = make([]uint64, 0, len(txBals))
+		postBalances[txIdx] = make([]uint64, 0, len(txBals))
+		for _, bal := range sorted {
 			preBal, err := strconv.ParseUint(bal.Pre, 10, 64)
 			if err != nil {
-				fmt.Println("Transaction", txIdx, tx.Signatures)
-				return nil, nil, fmt.Errorf("Unable to parse pre balance. Err=%v. Value=%v", err, bal)
+				txErrs.add(txIdx, fmt.Errorf("Unable to parse pre balance of transaction %v %v. Err=%v. Value=%v", txIdx, tx.Signatures, err, bal.balance))
+				break
 			}
 			postBal, err := strconv.ParseUint(bal.Post, 10, 64)
 			if err != nil {
-				return nil, nil, fmt.Errorf("Unable to parse post balance: %v", err)
+				txErrs.add(txIdx, fmt.Errorf("Unable to parse post balance: %v", err))
+				break
 			}
 
 			preBalances[txIdx] = append(preBalances[txIdx], preBal)
@@ -399,10 +521,10 @@ func groupBalances(in []balance, txs []transaction) (preBalances, postBalances m
 		}
 	}
 
-	return preBalances, postBalances, nil
+	return preBalances, postBalances
 }
 
-func groupTokenBalances(in []tokenBalance, txs []transaction) (preTokenBalances, postTokenBalances map[uint][]solana.TokenBalance, err error) {
+func groupTokenBalances(in []tokenBalance, txs txIndex, txErrs txErrors) (preTokenBalances, postTokenBalances map[uint][]solana.TokenBalance) {
 	preTokenBalances = map[uint][]solana.TokenBalance{}
 	postTokenBalances = map[uint][]solana.TokenBalance{}
 	for _, balance := range in {
@@ -413,14 +535,16 @@ func groupTokenBalances(in []tokenBalance, txs []transaction) (preTokenBalances,
 			postTokenBalances[balance.TransactionIndex] = []solana.TokenBalance{}
 		}
 
-		tx, err := getTransactionByIndex(txs, balance.TransactionIndex)
+		tx, err := txs.get(balance.TransactionIndex)
 		if err != nil {
-			return nil, nil, err
+			txErrs.add(balance.TransactionIndex, err)
+			continue
 		}
 
-		pre, post, err := TransformTokenBalance(balance, *tx)
+		pre, post, err := transformTokenBalance(balance, tx)
 		if err != nil {
-			return nil, nil, fmt.Errorf("Error parsing token balances. Tx index: %v. Err: %v", balance.TransactionIndex, err)
+			txErrs.add(balance.TransactionIndex, fmt.Errorf("Error parsing token balances. Tx index: %v. Err: %v", balance.TransactionIndex, err))
+			continue
 		}
 		if pre != nil {
 			preTokenBalances[balance.TransactionIndex] = append(preTokenBalances[balance.TransactionIndex], *pre)
@@ -430,7 +554,7 @@ func groupTokenBalances(in []tokenBalance, txs []transaction) (preTokenBalances,
 		}
 	}
 
-	return preTokenBalances, postTokenBalances, nil
+	return preTokenBalances, postTokenBalances
 }
 
 func groupLogs(in []logMessage) (out map[uint][]solana.Log, err error) {
@@ -444,13 +568,3 @@ func groupLogs(in []logMessage) (out map[uint][]solana.Log, err error) {
 
 	return out, nil
 }
-
-func getTransactionByIndex(txs []transaction, idx uint) (*transaction, error) {
-	for _, tx := range txs {
-		if tx.TransactionIndex == idx {
-			return &tx, nil
-		}
-	}
-
-	return nil, fmt.Errorf("Unable to find transaction with index: %v", idx)
-}
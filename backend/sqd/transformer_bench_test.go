@@ -0,0 +1,152 @@
+package sqd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// Busy mainnet blocks recorded for the conformance tests, the portal block of each pair is benchmarked
+const RECORDED_BLOCKS = "conformance/testdata/recorded/*/sqd.json"
+
+// generateBlock builds a block shaped like a busy mainnet block, every transaction uses lookup tables and has inner instructions, balances and token balances
+func generateBlock(txCount int) SolanaBlockResponse {
+	owner := "owner"
+	programId := "TokenkegQfeZyiNwAJbNbGKPFXCWuBvf9Ss623VQ5DA"
+	rewardType := "fee"
+
+	block := SolanaBlockResponse{
+		Header: blockHeader{Hash: "hash", Height: 300_000_000, ParentHash: "parent", Slot: 320_000_000, ParentSlot: 319_999_999, Timestamp: 1_700_000_000},
+		Rewards: []reward{
+			{Pubkey: "validator", Lamports: "10000", PostBalance: "1000000", RewardType: &rewardType},
+		},
+	}
+
+	for txIdx := range uint(txCount) {
+		account := func(i int) string { return fmt.Sprintf("account%d_%d", txIdx, i) }
+
+		tx := transaction{
+			TransactionIndex:      txIdx,
+			Signatures:            []string{fmt.Sprintf("signature%d", txIdx)},
+			Version:               0,
+			NumRequiredSignatures: 1,
+			Fee:                   "5000",
+			ComputeUnitsConsumed:  "150000",
+			LoadedAddresses:       loadedAddresses{Writable: []string{}, Readonly: []string{}},
+		}
+		for i := range 24 {
+			tx.AccountKeys = append(tx.AccountKeys, account(i))
+		}
+		for i := 24; i < 32; i++ {
+			tx.LoadedAddresses.Writable = append(tx.LoadedAddresses.Writable, account(i))
+		}
+		for i := 32; i < 48; i++ {
+			tx.LoadedAddresses.Readonly = append(tx.LoadedAddresses.Readonly, account(i))
+		}
+		block.Transactions = append(block.Transactions, tx)
+
+		// 4 instructions with 3 inner instructions each, accounts are spread across the keys and lookup tables
+		for i := range uint64(4) {
+			block.Instructions = append(block.Instructions, instruction{
+				TransactionIndex:   txIdx,
+				InstructionAddress: []uint64{i},
+				ProgramId:          account(47),
+				Accounts:           []string{account(0), account(25), account(40), account(int(i) + 1)},
+				Data:               "3Bxs4h24hBtQy9rw",
+			})
+			for j := range uint64(3) {
+				block.Instructions = append(block.Instructions, instruction{
+					TransactionIndex:   txIdx,
+					InstructionAddress: []uint64{i, j},
+					ProgramId:          account(46),
+					Accounts:           []string{account(30), account(int(j) + 5), account(45)},
+					Data:               "3Bxs4h24hBtQy9rw",
+				})
+			}
+		}
+
+		// Balances are in reverse order so they need sorting
+		for i := 31; i >= 0; i -= 2 {
+			block.Balances = append(block.Balances, balance{TransactionIndex: txIdx, Account: account(i), Pre: "2039280", Post: "2034280"})
+		}
+
+		for i := range 4 {
+			block.TokenBalances = append(block.TokenBalances, tokenBalance{
+				TransactionIndex: txIdx,
+				Account:          account(8 + i),
+				PreMint:          "mint",
+				PreDecimals:      6,
+				PreOwner:         &owner,
+				PreAmount:        "123456789",
+				PreProgramId:     &programId,
+				PostMint:         "mint",
+				PostDecimals:     6,
+				PostOwner:        &owner,
+				PostAmount:       "123400000",
+				PostProgramId:    &programId,
+			})
+		}
+
+		for i := range uint(12) {
+			block.Logs = append(block.Logs, logMessage{TransactionIndex: txIdx, LogIndex: i, InstructionAddress: []uint{0}, ProgramId: account(47), Kind: "log", Message: "Instruction: Transfer"})
+		}
+	}
+
+	return block
+}
+
+// benchmarkBlocks returns the recorded blocks, the generated block and the fixture blocks by name
+func benchmarkBlocks(b *testing.B) map[string]SolanaBlockResponse {
+	blocks := map[string]SolanaBlockResponse{
+		"generated": generateBlock(1500),
+	}
+
+	files, err := filepath.Glob(RECORDED_BLOCKS)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if len(files) == 0 {
+		b.Fatal("No recorded blocks, record them with `takoyaki conformance -rpcEndpoint <url> -slots <slots>`")
+	}
+	for _, file := range files {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var block SolanaBlockResponse
+		if err := json.Unmarshal(raw, &block); err != nil {
+			b.Fatalf("Invalid recorded block %v: %v", file, err)
+		}
+		blocks["recorded/"+strconv.FormatUint(block.Header.Slot, 10)] = block
+	}
+
+	raw, err := os.ReadFile(FIXTURE_BLOCKS)
+	if err != nil {
+		b.Fatal(err)
+	}
+	fixtures, err := decodeBlocks(raw)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, block := range fixtures {
+		blocks[strconv.FormatUint(block.Header.Slot, 10)] = block
+	}
+
+	return blocks
+}
+
+func BenchmarkTransformBlock(b *testing.B) {
+	for name, block := range benchmarkBlocks(b) {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := TransformBlock(block); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,23 +1,19 @@
 package sqd
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
-	"math/big"
+	"os"
 	"strings"
 	"testing"
 
-	solanaGo "github.com/gagliardetto/solana-go"
-	"github.com/gagliardetto/solana-go/rpc"
-	"github.com/subquery/solana-takoyaki/meta"
 	"github.com/subquery/solana-takoyaki/solana"
 )
 
-// https://solscan.io/block/327347682
+// Blocks served by the fake portal in sqdtest, modelled on https://solscan.io/block/327347682
+const FIXTURE_BLOCKS = "sqdtest/testdata/blocks.ndjson"
 const BLOCK = 305_604_799
 const SLOT = 327_347_682
-const RPC_ENDPOINT = "https://api.mainnet-beta.solana.com"
 
 func compareAsJson(t *testing.T, expected, got interface{}, errorPrefix string) {
 	aStr, _ := json.Marshal(expected)
@@ -27,252 +23,307 @@ func compareAsJson(t *testing.T, expected, got interface{}, errorPrefix string)
 	}
 }
 
-func TestTransforming(t *testing.T) {
-	url, _ := GetSquidUrl(context.Background(), "solana-mainnet")
-	client := NewClient(url, meta.MAINNET)
-
-	rpcClient := rpc.NewWithHeaders(RPC_ENDPOINT, map[string]string{})
-
-	maxVersion := uint64(0)
-
-	// TODO parallelize RPC and SQD requests
-	rpcBlock, err := rpcClient.GetBlockWithOpts(context.Background(), SLOT, &rpc.GetBlockOpts{
-		Encoding:                       solanaGo.EncodingBase64,
-		TransactionDetails:             "full",
-		MaxSupportedTransactionVersion: &maxVersion,
-	})
+// fixtureBlock reads a fixture block, sqdtest can't be imported here as it depends on this package
+func fixtureBlock(t *testing.T, slot uint64) SolanaBlockResponse {
+	raw, err := os.ReadFile(FIXTURE_BLOCKS)
 	if err != nil {
-		t.Fatalf("Failed to get RPC block: %v", err)
+		t.Fatal(err)
 	}
-
-	res, err := client.Query(context.Background(), FULL_BLOCK_REQUEST)
+	blocks, err := decodeBlocks(raw)
 	if err != nil {
-		t.Fatalf("Failed to query SQD: %v", err)
+		t.Fatal(err)
 	}
-
-	transformedBlock, err := TransformBlock(res[0])
-	if err != nil {
-		t.Fatalf("Failed to transform block: %v", err)
+	for _, block := range blocks {
+		if block.Header.Slot == slot {
+			return block
+		}
 	}
-
-	compareBlock(t, *transformedBlock, *rpcBlock)
+	t.Fatalf("No fixture block at slot %v", slot)
+	return SolanaBlockResponse{}
 }
 
-func TestTransformingSoldexer(t *testing.T) {
-	client := NewSoldexerClient(SOLDEXER_URL)
-
-	rpcClient := rpc.NewWithHeaders(RPC_ENDPOINT, map[string]string{})
-
-	maxVersion := uint64(0)
-
-	// TODO parallelize RPC and SQD requests
-	rpcBlock, err := rpcClient.GetBlockWithOpts(context.Background(), SLOT, &rpc.GetBlockOpts{
-		Encoding:                       solanaGo.EncodingBase64,
-		TransactionDetails:             "full",
-		MaxSupportedTransactionVersion: &maxVersion,
-	})
-	if err != nil {
-		t.Fatalf("Failed to get RPC block: %v", err)
-	}
-
-	res, err := client.Query(context.Background(), SOLDEXER_FULL_BLOCK_REQUEST)
-	if err != nil {
-		t.Fatalf("Failed to query SQD: %v", err)
-	}
-
-	transformedBlock, err := TransformBlock(res[0])
+func TestTransforming(t *testing.T) {
+	block, err := TransformBlock(fixtureBlock(t, SLOT))
 	if err != nil {
 		t.Fatalf("Failed to transform block: %v", err)
 	}
 
-	compareBlock(t, *transformedBlock, *rpcBlock)
-}
-
-func compareBlock(t *testing.T, sqdBlock solana.Block, rpcBlock rpc.GetBlockResult) {
-	if sqdBlock.Blockhash != rpcBlock.Blockhash.String() {
-		t.Errorf("Blockhash mismatch: %v != %v", sqdBlock.Blockhash, rpcBlock.Blockhash.String())
+	if block.Blockhash != "5FqMrgbiEmh22E9puyX4RV2EnARvwYBHgsTKYQ9a52Er" {
+		t.Errorf("Blockhash mismatch: %v", block.Blockhash)
 	}
-	if sqdBlock.BlockHeight != *rpcBlock.BlockHeight {
-		t.Errorf("Block height mismatch: %v != %v", sqdBlock.BlockHeight, *rpcBlock.BlockHeight)
+	if block.BlockHeight != BLOCK {
+		t.Errorf("Block height mismatch: %v != %v", block.BlockHeight, BLOCK)
 	}
-	if sqdBlock.PreviousBlockhash != rpcBlock.PreviousBlockhash.String() {
-		t.Errorf("Previous block hash mismatch: %v != %v", sqdBlock.PreviousBlockhash, rpcBlock.PreviousBlockhash)
+	if block.ParentSlot != SLOT-1 {
+		t.Errorf("Parent slot mismatch: %v != %v", block.ParentSlot, SLOT-1)
 	}
-	if sqdBlock.ParentSlot != rpcBlock.ParentSlot {
-		t.Errorf("Parent slot mismatch: %v != %v", sqdBlock.ParentSlot, rpcBlock.ParentSlot)
+	if block.PreviousBlockhash != "6MZKYBX3YRASapeTqCUq5Q6DuvY7AtaFGm98vzTGLqRr" {
+		t.Errorf("Previous block hash mismatch: %v", block.PreviousBlockhash)
 	}
-	if sqdBlock.BlockTime != rpcBlock.BlockTime.Time().Unix() {
-		t.Errorf("Block time mismatch: %v != %v", sqdBlock.BlockTime, rpcBlock.BlockTime)
+	if block.BlockTime != 1_743_000_000 {
+		t.Errorf("Block time mismatch: %v", block.BlockTime)
 	}
 
-	// SQD archive doesn't include voting transactions
-	nonVotingTxs := []rpc.TransactionWithMeta{}
-	for _, tx := range rpcBlock.Transactions {
-		rpcTxInner, _ := solanaGo.TransactionFromBytes(tx.Transaction.GetBinary())
-		if !IsVoteTx(rpcTxInner) {
-			nonVotingTxs = append(nonVotingTxs, tx)
-		}
+	if len(block.Transactions) != 4 {
+		t.Fatalf("Tx count mismatch: %v != %v", len(block.Transactions), 4)
 	}
-	if len(sqdBlock.Transactions) != len(nonVotingTxs) {
-		t.Errorf("Tx count mismatch: %v != %v", len(sqdBlock.Transactions), len(nonVotingTxs))
+	for i, tx := range block.Transactions {
+		if tx.Slot != SLOT || tx.BlockTime != block.BlockTime {
+			t.Errorf("Transaction[%v] slot or block time mismatch: %v, %v", i, tx.Slot, tx.BlockTime)
+		}
 	}
 
-	// SQD doesnt include transactions from the vote program, this leads to different transaction indexes
-
-	// https://solscan.io/tx/5LcWr1JK43dG7NNBXUw7qhouH4ewwoyo4idxgyf2BUtbzuKQpiv4Vn2w2c6sDWaejsmRzENLWdNxcA8mtaeXfS94
-	compareTransactions(t, sqdBlock.Transactions[0], rpcBlock.Transactions[0], 0, []uint{0, 2})
-
-	// https://solscan.io/tx/5vrB5e2Va47YcLvs2oVMeYfMdtmYJ2wJ7q2SQV9A7P8f1djzb9hMiwbPMajE5yaxPkYdch9QSzRzaiwHEvHgzCno
-	// Failed transaction
-	compareTransactions(t, sqdBlock.Transactions[2], rpcBlock.Transactions[3], 3, []uint{})
-
-	// https://solscan.io/tx/2SB7fVzaUyU8knSbEa42c2BKQJXm1QPamtiFXKapX6YwLbbAm7dzezaGKyXfb6uGRH8a1xTeovSmWnbgav7jeKCS
-	// Transaction with a program
-	compareTransactions(t, sqdBlock.Transactions[5], rpcBlock.Transactions[16], 16, []uint{3, 4})
+	compareAsJson(t, []solana.BlockReward{{
+		Pubkey:      "6CyE7afmQKszjCCvMZ4FpQ5wDu9zosq95o1aDMNgB1mU",
+		Lamports:    15000,
+		PostBalance: 1_234_567_890,
+		RewardType:  solana.RewardTypeFee,
+	}}, block.Rewards, "Rewards")
+}
 
-	if len(sqdBlock.Rewards) != len(rpcBlock.Rewards) {
-		t.Errorf("Rewards count mismatch: %v != %v", len(sqdBlock.Rewards), len(rpcBlock.Rewards))
+// A failed v0 transaction, loaded addresses are indexed after the account keys with writable before readonly
+func TestTransformingFailedTransaction(t *testing.T) {
+	block, err := TransformBlock(fixtureBlock(t, SLOT))
+	if err != nil {
+		t.Fatalf("Failed to transform block: %v", err)
 	}
+	tx := block.Transactions[1]
 
-	// This block only contains a single reward
-	if len(rpcBlock.Rewards) > 0 {
-		compareAsJson(t, rpcBlock.Rewards[0], sqdBlock.Rewards[0], "Reward 0")
+	compareAsJson(t, map[string]interface{}{"InstructionError": []interface{}{2, map[string]int{"Custom": 30}}}, tx.Meta.Err, "Err")
+	if tx.Meta.Fee != 15000 {
+		t.Errorf("Fee mismatch: %v != %v", tx.Meta.Fee, 15000)
 	}
-}
+	compareAsJson(t, solana.LoadedAddresses{
+		Readonly: []string{"BDxwEfKqgACvj7yMSLngkDZG2sF5Mmd7R7s6hW3PQcco"},
+		Writable: []string{"DAK1wKASDGKyTgRFwqgUqHhT5A4iNenraNvz2EpS5g7r", "2or3jnWF2Zyhh2C3bG7P3Dbz2cAZ7AqJk4i82dYfb7Cg"},
+	}, tx.Meta.LoadedAddresses, "LoadedAddresses")
 
-func compareTransactions(t *testing.T, sqdTx solana.Transaction, rpcTx rpc.TransactionWithMeta, index uint, checkInstructions []uint) {
-	// RPC is missing both of these
-	if sqdTx.Slot != SLOT {
-		t.Errorf("Transaction Slot mismatch: %v != %v", sqdTx.Slot, SLOT)
+	instructions := tx.Transaction.Message.Instructions
+	if len(instructions) != 3 {
+		t.Fatalf("Instructions count mismatch: %v != %v", len(instructions), 3)
 	}
-	// if sqdTx.BlockTime != rpcTx.BlockTime.Time().Unix() {
-	// 	t.Errorf("Transaction BlockTime mismatch: %v != %v", sqdTx.BlockTime, rpcTx.BlockTime.Time().Unix())
-	// }
-	fmt.Printf("Checking tx sig: %v, idx: %v", sqdTx.Transaction.Signatures[0], index)
+	compareAsJson(t, solana.CompiledInstruction{ProgramIDIndex: 3, Accounts: []uint16{1, 4, 5, 6, 0}, Data: "6AuM4xMCPFhR"}, instructions[2], "Instruction[2]")
 
-	rpcTxInner, err := solanaGo.TransactionFromBytes(rpcTx.Transaction.GetBinary())
-	if err != nil {
-		t.Fatalf("Failed to unmarshal RPC transaction: %v", err)
-	}
-	if len(sqdTx.Transaction.Signatures) != len(rpcTxInner.Signatures) {
-		t.Errorf("Transaction[%v].Signatures count mismatch: %v != %v", index, len(sqdTx.Transaction.Signatures), len(rpcTxInner.Signatures))
+	// Only the fee payer balance changed
+	compareAsJson(t, []uint64{500_000_000}, tx.Meta.PreBalances, "PreBalances")
+	compareAsJson(t, []uint64{499_985_000}, tx.Meta.PostBalances, "PostBalances")
+	if len(tx.Meta.Logs) != 2 {
+		t.Errorf("Logs count mismatch: %v != %v", len(tx.Meta.Logs), 2)
 	}
-	// Most likely only one tx
-	if sqdTx.Transaction.Signatures[0] != rpcTxInner.Signatures[0].String() {
-		t.Errorf("Transaction[%v].Signatures mismatch: %v != %v", index, sqdTx.Transaction.Signatures[0], rpcTxInner.Signatures[0].String())
-	}
-
-	compareAsJson(t, rpcTx.Meta.Err, sqdTx.Meta.Err, fmt.Sprintf("Transaction[%v] Err", index))
+}
 
-	if sqdTx.Meta.Fee != rpcTx.Meta.Fee {
-		t.Errorf("Transaction[%v].Fee mismatch: %v != %v", index, sqdTx.Meta.Fee, rpcTx.Meta.Fee)
+// A swap with inner token transfers, token balances are sorted by account index
+func TestTransformingInnerInstructions(t *testing.T) {
+	block, err := TransformBlock(fixtureBlock(t, SLOT))
+	if err != nil {
+		t.Fatalf("Failed to transform block: %v", err)
 	}
+	tx := block.Transactions[2]
 
-	if *sqdTx.Meta.ComputeUnitsConsumed != *rpcTx.Meta.ComputeUnitsConsumed {
-		t.Errorf("Transaction[%v].ComputeUnitsConsumed mismatch: %v != %v", index, *sqdTx.Meta.ComputeUnitsConsumed, *rpcTx.Meta.ComputeUnitsConsumed)
+	if tx.Transaction.Signatures[0] != "3rv9JYwLPLkbsZFobpuFGFBPpnYZzJQtvaxSNCMn9QJEepcfnJ173XCMNhC5xbHAbG4GKytu7AiSYeswT85tCmVN" {
+		t.Errorf("Signature mismatch: %v", tx.Transaction.Signatures[0])
 	}
 
-	compareAsJson(t, rpcTx.Meta.LoadedAddresses, sqdTx.Meta.LoadedAddresses, fmt.Sprintf("Transaction[%v] LoadedAddresses", index))
-	compareAsJson(t, rpcTx.Meta.Rewards, sqdTx.Meta.Rewards, fmt.Sprintf("Transaction[%v] Rewards", index))
-
-	// Only balance changes are included with SQD, not balances for all accounts in the tx
-	sqdTokenBalIdx := 0
-	for idx, preBal := range rpcTx.Meta.PreBalances {
-		postBal := rpcTx.Meta.PostBalances[idx]
+	stackHeight := uint16(2)
+	compareAsJson(t, []solana.InnerInstruction{{
+		Index: 0,
+		Instructions: []solana.CompiledInstruction{
+			{ProgramIDIndex: 5, Accounts: []uint16{1, 3, 0}, Data: "3QCwqmHZ4mdq", StackHeight: &stackHeight},
+			{ProgramIDIndex: 5, Accounts: []uint16{4, 2, 7}, Data: "3DczudEgsqyq", StackHeight: &stackHeight},
+		},
+	}}, tx.Meta.InnerInstructions, "InnerInstructions")
 
-		if preBal != postBal {
-			compareAsJson(t, preBal, sqdTx.Meta.PreBalances[sqdTokenBalIdx], fmt.Sprintf("Transaction[%v] Pre balance %v", index, idx))
-			compareAsJson(t, postBal, sqdTx.Meta.PostBalances[sqdTokenBalIdx], fmt.Sprintf("Transaction[%v] Post balance %v", index, idx))
-			sqdTokenBalIdx++
+	if len(tx.Meta.PreTokenBalances) != 4 || len(tx.Meta.PostTokenBalances) != 4 {
+		t.Fatalf("Token balances count mismatch: %v, %v", len(tx.Meta.PreTokenBalances), len(tx.Meta.PostTokenBalances))
+	}
+	for i, balance := range tx.Meta.PostTokenBalances {
+		if balance.AccountIndex != uint16(i+1) {
+			t.Errorf("PostTokenBalances[%v] account index mismatch: %v != %v", i, balance.AccountIndex, i+1)
 		}
 	}
 
-	if len(sqdTx.Meta.PreTokenBalances) != len(rpcTx.Meta.PreTokenBalances) {
-		t.Errorf("Transaction[%v].PreTokenBalances count mismatch: %v != %v", index, len(sqdTx.Meta.PreTokenBalances), len(rpcTx.Meta.PreTokenBalances))
-	}
-	if len(sqdTx.Meta.PostTokenBalances) != len(rpcTx.Meta.PostTokenBalances) {
-		t.Errorf("Transaction[%v].PostTokenBalances count mismatch: %v != %v", index, len(sqdTx.Meta.PostTokenBalances), len(rpcTx.Meta.PostTokenBalances))
+	// A zero balance has no ui amount like the RPC
+	uiAmount := 2.5
+	compareAsJson(t, &solana.UiTokenAmount{Amount: "0", Decimals: 9, UiAmountString: "0"}, tx.Meta.PreTokenBalances[1].UiTokenAmount, "PreTokenBalances[1]")
+	compareAsJson(t, &solana.UiTokenAmount{Amount: "2500000000", Decimals: 9, UiAmount: &uiAmount, UiAmountString: "2.5"}, tx.Meta.PostTokenBalances[1].UiTokenAmount, "PostTokenBalances[1]")
+
+	kinds := []string{}
+	for _, log := range tx.Meta.Logs {
+		kinds = append(kinds, log.Kind)
 	}
+	compareAsJson(t, []string{"log", "log", "log", "data"}, kinds, "Log kinds")
+}
 
-	if len(rpcTx.Meta.PreTokenBalances) > 0 {
-		compareAsJson(t, rpcTx.Meta.PreTokenBalances, sqdTx.Meta.PreTokenBalances, fmt.Sprintf("Transaction[%v] Pre token balance 0", index))
+// Inner instruction groups are ordered by the index of their outer instruction
+func TestTransformingInnerInstructionsOrder(t *testing.T) {
+	block := fixtureBlock(t, SLOT)
+	for _, inst := range block.Instructions {
+		if inst.TransactionIndex == 2 && len(inst.InstructionAddress) == 2 {
+			for i := uint64(1); i < 8; i++ {
+				group := inst
+				group.InstructionAddress = []uint64{i, 0}
+				block.Instructions = append(block.Instructions, group)
+			}
+			break
+		}
 	}
 
-	if len(rpcTx.Meta.PostTokenBalances) > 0 {
-		compareAsJson(t, rpcTx.Meta.PostTokenBalances, sqdTx.Meta.PostTokenBalances, fmt.Sprintf("Transaction[%v] Post token balance 0", index))
+	// Map iteration order is random so a single run could be ordered by chance
+	for run := 0; run < 20; run++ {
+		res, err := TransformBlock(block)
+		if err != nil {
+			t.Fatalf("Failed to transform block: %v", err)
+		}
+		indexes := []uint64{}
+		for _, group := range res.Transactions[2].Meta.InnerInstructions {
+			indexes = append(indexes, group.Index)
+		}
+		if fmt.Sprint(indexes) != "[0 1 2 3 4 5 6 7]" {
+			t.Fatalf("Inner instructions out of order: %v", indexes)
+		}
 	}
+}
 
-	if len(sqdTx.Transaction.Message.AccountKeys) != len(rpcTxInner.Message.AccountKeys) {
-		t.Errorf("Transaction[%v].Message.AccountKeys count mismatch: %v != %v", index, len(sqdTx.Transaction.Message.AccountKeys), len(rpcTxInner.Message.AccountKeys))
+// A Token-2022 transfer to a new account only has a post token balance for the destination
+func TestTransformingToken2022(t *testing.T) {
+	block, err := TransformBlock(fixtureBlock(t, SLOT))
+	if err != nil {
+		t.Fatalf("Failed to transform block: %v", err)
 	}
-	// compareAsJson(t, rpcTx.Transaction.Message.AccountKeys, sqdTx.Transaction.Message.AccountKeys, fmt.Sprintf("Transaction[%v].Message.AccountKeys", index))
+	tx := block.Transactions[3]
 
-	if len(sqdTx.Transaction.Message.Instructions) != len(rpcTxInner.Message.Instructions) {
-		t.Errorf("Transaction[%v].Message.Instructions count mismatch: %v != %v", index, len(sqdTx.Transaction.Message.Instructions), len(rpcTxInner.Message.Instructions))
+	if len(tx.Meta.PreTokenBalances) != 1 || len(tx.Meta.PostTokenBalances) != 2 {
+		t.Fatalf("Token balances count mismatch: %v, %v", len(tx.Meta.PreTokenBalances), len(tx.Meta.PostTokenBalances))
 	}
-	for _, idx := range checkInstructions {
-		compareInstructions(t, sqdTx.Transaction.Message.Instructions[idx], rpcTxInner.Message.Instructions[idx], idx)
+	dst := tx.Meta.PostTokenBalances[1]
+	if dst.AccountIndex != 2 || *dst.ProgramId != "TokenzQdBNbLqP5VEhdkAS6EPFLC1PHnBqCXEpPxuEb" || dst.UiTokenAmount.UiAmountString != "123.456789" {
+		t.Errorf("Unexpected destination token balance %+v", dst)
 	}
+}
 
-	if len(sqdTx.Meta.InnerInstructions) != len(rpcTx.Meta.InnerInstructions) {
-		t.Errorf("Transaction[%v].Meta.InnerInstructions count mismatch: %v != %v", index, len(sqdTx.Meta.InnerInstructions), len(rpcTx.Meta.InnerInstructions))
+// Transactions without token balances serialize empty arrays like the RPC rather than null
+func TestTransformingEmptyTokenBalances(t *testing.T) {
+	block, err := TransformBlock(fixtureBlock(t, SLOT))
+	if err != nil {
+		t.Fatalf("Failed to transform block: %v", err)
 	}
 
-	for idx, innerInst := range sqdTx.Meta.InnerInstructions {
-		rpcInnerInst := rpcTx.Meta.InnerInstructions[idx]
-		if innerInst.Index != uint64(rpcInnerInst.Index) {
-			t.Errorf("Transaction[%v].Meta.InnerInstructions[%v].Index mismatch: %v != %v", index, idx, innerInst.Index, rpcInnerInst.Index)
+	for _, i := range []int{0, 1} {
+		meta, err := json.Marshal(block.Transactions[i].Meta)
+		if err != nil {
+			t.Fatal(err)
 		}
-		compareAsJson(t, rpcInnerInst.Instructions, innerInst.Instructions, fmt.Sprintf("Transaction[%v].Meta.InnerInstructions[%v]", index, idx))
-	}
-
-	// SQD only includes logs output by contracts, this excludes invoke, success and consumed compute units logs
-	filteredLogs := []string{}
-	for _, log := range rpcTx.Meta.LogMessages {
-		if strings.HasPrefix(log, "Program log: ") || strings.HasPrefix(log, "Program data: ") || strings.HasPrefix(log, "Program returned:") {
-			filteredLogs = append(filteredLogs, log)
+		if !strings.Contains(string(meta), `"preTokenBalances":[]`) || !strings.Contains(string(meta), `"postTokenBalances":[]`) {
+			t.Errorf("Transaction[%v] token balances are not empty arrays: %s", i, meta)
 		}
 	}
-	// if len(sqdTx.Meta.LogMessages) != len(filteredLogs) {
-	// 	t.Errorf("Transaction[%v].Meta.LogMessages count mismatch: %v != %v", index, len(sqdTx.Meta.LogMessages), len(filteredLogs))
-	// }
-	// compareAsJson(t, sqdTx.Meta.LogMessages, filteredLogs, fmt.Sprintf("Transaction[%v].Meta.LogMessages", index))
 }
 
-func compareInstructions(t *testing.T, sqdInst solana.CompiledInstruction, rpcInst solanaGo.CompiledInstruction, index uint) {
-	if sqdInst.ProgramIDIndex != rpcInst.ProgramIDIndex {
-		t.Errorf("Instruction[%v].ProgramIDIndex mismatch: %v != %v", index, sqdInst.ProgramIDIndex, rpcInst.ProgramIDIndex)
+// Lookup table indexes serialize as arrays of numbers like the RPC rather than base64
+func TestTransformingAddressTableLookups(t *testing.T) {
+	block, err := TransformBlock(fixtureBlock(t, SLOT))
+	if err != nil {
+		t.Fatalf("Failed to transform block: %v", err)
 	}
-	if len(sqdInst.Accounts) != len(rpcInst.Accounts) {
-		t.Errorf("Instruction[%v].Accounts count mismatch: %v != %v", index, len(sqdInst.Accounts), len(rpcInst.Accounts))
+
+	expected := map[int]string{
+		1: `[{"accountKey":"9ep9AvXX7NZHnoXj7xGAT2pb7zqLDU8a5hqatxwCWZA6","writableIndexes":[0,1],"readonlyIndexes":[4]}]`,
+		2: `[{"accountKey":"3ZBo4KM28gAiCwzWEsnzbfoPJhTscfutRs5v4qpumqHA","writableIndexes":[],"readonlyIndexes":[7]}]`,
 	}
-	if sqdInst.Data != rpcInst.Data.String() {
-		t.Errorf("Instruction[%v].Data mismatch: %v != %v", index, sqdInst.Data, rpcInst.Data.String())
+	for i, want := range expected {
+		got, err := json.Marshal(block.Transactions[i].Transaction.Message.AddressTableLookups)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("Transaction[%v] address table lookups mismatch\nexpected: %v\ngot: %s", i, want, got)
+		}
+	}
+
+	// Indexes can be read back from the RPC format
+	var lookups []solana.MessageAddressTableLookup
+	if err := json.Unmarshal([]byte(expected[1]), &lookups); err != nil {
+		t.Fatal(err)
 	}
+	compareAsJson(t, []uint8{0, 1}, []uint8(lookups[0].WritableIndexes), "WritableIndexes")
 }
 
-// Is part of github.com/gagliardetto/solana-go but not released at time of writing
-func IsVoteTx(tx *solanaGo.Transaction) bool {
-	// is vote if any of the instructions are of the vote program
-	for _, inst := range tx.Message.Instructions {
-		progKey, err := tx.ResolveProgramIDIndex(inst.ProgramIDIndex)
-		if err == nil {
-			if progKey.Equals(solanaGo.VoteProgramID) {
-				return true
-			}
+func TestShiftDecimals(t *testing.T) {
+	tests := []struct {
+		amount   string
+		decimals uint8
+		expected string
+	}{
+		{"101", 1, "10.1"},
+		{"987654321", 5, "9876.54321"},
+		// The largest u64 amount keeps every digit
+		{"18446744073709551615", 9, "18446744073.709551615"},
+		{"18446744073709551615", 20, "0.18446744073709551615"},
+	}
+
+	for _, test := range tests {
+		if shifted := solana.ShiftDecimals(test.amount, test.decimals); shifted != test.expected {
+			t.Errorf("Expected %v, got %v", test.expected, shifted)
 		}
 	}
-	return false
 }
 
-func TestShiftDecimalPlacesLeft(t *testing.T) {
-	shifted := shiftDecimalPlacesLeft(*big.NewFloat(101), 1)
-	if big.NewFloat(10.1).Cmp(shifted) != 0 {
-		t.Errorf("Expected 10.1, got %v", shifted)
+func TestTransformBlockLenient(t *testing.T) {
+	raw := `{
+		"header":{"number":100,"height":90,"hash":"B","parentNumber":99,"parentHash":"A","timestamp":1700000000},
+		"transactions":[
+			{"transactionIndex":0,"signatures":["sig0"],"accountKeys":["payer","program"],"fee":"5000","computeUnitsConsumed":"100","loadedAddresses":{"readonly":[],"writable":[]}},
+			{"transactionIndex":1,"signatures":["sig1"],"accountKeys":["payer","program"],"fee":"invalid","computeUnitsConsumed":"100","loadedAddresses":{"readonly":[],"writable":[]}},
+			{"transactionIndex":2,"signatures":["sig2"],"accountKeys":["payer","program"],"fee":"5000","computeUnitsConsumed":"100","loadedAddresses":{"readonly":[],"writable":[]}}
+		],
+		"instructions":[
+			{"transactionIndex":0,"instructionAddress":[0],"programId":"program","accounts":["payer"],"data":"1"},
+			{"transactionIndex":2,"instructionAddress":[0],"programId":"program","accounts":["missing"],"data":"1"}
+		],
+		"rewards":[
+			{"pubkey":"validator","lamports":"10","postBalance":"100","rewardType":"fee"},
+			{"pubkey":"other","lamports":"10","postBalance":"100"}
+		]
+	}`
+	var block SolanaBlockResponse
+	if err := json.Unmarshal([]byte(raw), &block); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := TransformBlock(block); err == nil || !strings.Contains(err.Error(), "invalid") {
+		t.Errorf("Expected strict transform to fail on the first transaction error, got %v", err)
+	}
+
+	out, warnings, err := TransformBlockLenient(block)
+	if err != nil {
+		t.Fatalf("Failed to transform block: %v", err)
+	}
+
+	if len(out.Transactions) != 1 || out.Transactions[0].Transaction.Signatures[0] != "sig0" {
+		t.Errorf("Expected only the valid transaction, got %v", out.Transactions)
+	}
+	if len(out.Rewards) != 1 || out.Rewards[0].Pubkey != "validator" {
+		t.Errorf("Expected only the valid reward, got %v", out.Rewards)
 	}
 
-	shifted2 := shiftDecimalPlacesLeft(*big.NewFloat(987654321), 5)
-	if big.NewFloat(9876.54321).Cmp(shifted2) != 0 {
-		t.Errorf("Expected 9876.54321, got %v", shifted2)
+	if len(warnings) != 3 {
+		t.Fatalf("Expected 3 warnings, got %v", warnings)
+	}
+	expected := []struct {
+		txIndex   uint
+		signature string
+		reward    string
+	}{{1, "sig1", ""}, {2, "sig2", ""}, {0, "", "other"}}
+	for i, e := range expected {
+		w := warnings[i]
+		if e.reward != "" {
+			if w.RewardPubkey != e.reward || w.TransactionIndex != nil {
+				t.Errorf("Unexpected reward warning %+v", w)
+			}
+			continue
+		}
+		if w.TransactionIndex == nil || *w.TransactionIndex != e.txIndex || w.Signature != e.signature || w.Slot != 100 || w.Message == "" {
+			t.Errorf("Unexpected transaction warning %+v", w)
+		}
 	}
 }
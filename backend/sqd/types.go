@@ -13,6 +13,9 @@ type NetworkMeta struct {
 	GenesisHash string
 	ChainId     string
 	StartBlock  uint
+	Dataset     string
+	Aliases     []string
+	RealTime    bool // Whether the dataset includes unfinalized blocks
 }
 
 /* Spec can be found here https://docs.sqd.ai/solana-indexing/network-api/solana-api/*/
@@ -25,6 +28,9 @@ type SolanaRequest struct {
 	ToBlock   uint `json:"toBlock"`
 
 	IncludeAllBlocks *bool `json:"includeAllBlocks,omitempty"` // default: false
+
+	// The hash of the block before FromBlock, the portal responds with a conflict if it doesn't match its chain
+	ParentBlockHash *string `json:"parentBlockHash,omitempty"`
 	//
 	// Fields to.select, see the specific types for provided details
 	Fields Fields `json:"fields,omitempty"`
@@ -42,6 +48,29 @@ func (s SolanaRequest) MarshalJSON() ([]byte, error) {
 	return utils.MarshalWithEmptySlices(s)
 }
 
+// FullBlockRequest selects every item of every block from from to to (inclusive)
+func FullBlockRequest(from, to uint) SolanaRequest {
+	includeAll := true
+	return SolanaRequest{
+		Type:             "solana",
+		FromBlock:        from,
+		ToBlock:          to,
+		IncludeAllBlocks: &includeAll,
+		Fields:           ALL_SOLDEXER_FIELDS,
+		Transactions: []TransactionRequest{{
+			Instructions:  true,
+			Logs:          true,
+			Balances:      true,
+			TokenBalances: true,
+		}},
+		Instructions:  []InstructionRequest{{}},
+		Logs:          []LogRequest{{}},
+		Rewards:       []RewardRequest{{}},
+		TokenBalances: []TokenBalanceRequest{{}},
+		Balances:      []BalancesRequest{{}},
+	}
+}
+
 type SolanaBlockResponse struct {
 	Header        blockHeader    `json:"header"`
 	Transactions  []transaction  `json:"transactions"` // Excludes all Voting Program transactions
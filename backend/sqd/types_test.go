@@ -8,7 +8,7 @@ func TestSetsDiscriminatorCorrectly(t *testing.T) {
 
 	ir := InstructionRequest{}
 
-	ir.SetDiscriminators([]string{"0xe517cb977ae3ad2a"});
+	ir.SetDiscriminators([]string{"0xe517cb977ae3ad2a"})
 
 	if len(ir.D8) != 1 {
 		t.Fatalf("Expected 1 D8 entry, got %d", len(ir.D8))
@@ -23,7 +23,7 @@ func TestSetsAccountsCorrectly(t *testing.T) {
 
 	ir := InstructionRequest{}
 
-	ir.SetAccounts(1, []string{"0xe517cb977ae3ad2a"});
+	ir.SetAccounts(1, []string{"0xe517cb977ae3ad2a"})
 
 	if len(ir.A1) != 1 {
 		t.Fatalf("Expected 1 A1 entry, got %d", len(ir.A1))
@@ -33,4 +33,3 @@ func TestSetsAccountsCorrectly(t *testing.T) {
 		t.Fatalf("Expected discriminator 0xe517cb977ae3ad2a, got %s", ir.A1[0])
 	}
 }
-
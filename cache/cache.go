@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"sync/atomic"
+)
+
+// Store is a key value store for cached responses, implementations must be safe for concurrent use
+type Store interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+}
+
+type Stats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+// Cache wraps a Store and records hits and misses
+type Cache struct {
+	store  Store
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func New(store Store) *Cache {
+	return &Cache{store: store}
+}
+
+func (c *Cache) Get(key string) ([]byte, bool) {
+	value, ok := c.store.Get(key)
+	if ok {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+	return value, ok
+}
+
+func (c *Cache) Set(key string, value []byte) {
+	c.store.Set(key, value)
+}
+
+func (c *Cache) Stats() Stats {
+	return Stats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+	}
+}
+
+// Tiered checks each store in order, values found in later stores are copied to earlier ones
+type Tiered struct {
+	stores []Store
+}
+
+func NewTiered(stores ...Store) *Tiered {
+	return &Tiered{stores}
+}
+
+func (t *Tiered) Get(key string) ([]byte, bool) {
+	for i, store := range t.stores {
+		value, ok := store.Get(key)
+		if !ok {
+			continue
+		}
+		for _, earlier := range t.stores[:i] {
+			earlier.Set(key, value)
+		}
+		return value, true
+	}
+	return nil, false
+}
+
+func (t *Tiered) Set(key string, value []byte) {
+	for _, store := range t.stores {
+		store.Set(key, value)
+	}
+}
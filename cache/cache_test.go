@@ -0,0 +1,227 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLRU(t *testing.T) {
+	// Room for two entries of a one byte key and value
+	lru := NewLRU(4)
+	lru.Set("a", []byte("1"))
+	lru.Set("b", []byte("2"))
+
+	// Use a so b is evicted next
+	if _, ok := lru.Get("a"); !ok {
+		t.Fatal("Expected a to be cached")
+	}
+	lru.Set("c", []byte("3"))
+
+	if _, ok := lru.Get("b"); ok {
+		t.Error("Expected b to be evicted")
+	}
+	if value, ok := lru.Get("a"); !ok || string(value) != "1" {
+		t.Errorf("Expected a to be 1, got %q", value)
+	}
+	if lru.Len() != 2 || lru.Size() != 4 {
+		t.Errorf("Expected 2 entries of 4 bytes, got %v of %v bytes", lru.Len(), lru.Size())
+	}
+}
+
+func TestLRUMaxBytes(t *testing.T) {
+	lru := NewLRU(10)
+	lru.Set("a", []byte("1"))
+	lru.Set("b", []byte("2"))
+
+	// Values larger than the cache aren't stored and don't evict other entries
+	lru.Set("c", []byte("0123456789"))
+	if _, ok := lru.Get("c"); ok {
+		t.Error("Expected c to not be cached")
+	}
+	if lru.Len() != 2 {
+		t.Errorf("Expected 2 entries, got %v", lru.Len())
+	}
+
+	// Large values evict as many entries as needed
+	lru.Set("d", []byte("0123456"))
+	if _, ok := lru.Get("a"); ok {
+		t.Error("Expected a to be evicted")
+	}
+	if _, ok := lru.Get("b"); !ok {
+		t.Error("Expected b to be cached")
+	}
+
+	// Replacing a value updates the size, d is the least recently used
+	lru.Set("b", []byte("22"))
+	if _, ok := lru.Get("d"); ok {
+		t.Error("Expected d to be evicted")
+	}
+	if lru.Size() != 3 {
+		t.Errorf("Expected 3 bytes, got %v", lru.Size())
+	}
+}
+
+func TestDisk(t *testing.T) {
+	disk, err := NewDisk(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := disk.Get("a"); ok {
+		t.Fatal("Expected a to not be cached")
+	}
+
+	disk.Set("a", []byte("1"))
+	if value, ok := disk.Get("a"); !ok || string(value) != "1" {
+		t.Errorf("Expected a to be 1, got %q", value)
+	}
+}
+
+func TestDiskMaxBytes(t *testing.T) {
+	dir := t.TempDir()
+	disk, err := NewDisk(dir, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	disk.Set("a", []byte("11"))
+	disk.Set("b", []byte("22"))
+	// Use a so b is evicted next
+	if _, ok := disk.Get("a"); !ok {
+		t.Fatal("Expected a to be cached")
+	}
+	disk.Set("c", []byte("33"))
+
+	if _, ok := disk.Get("b"); ok {
+		t.Error("Expected b to be evicted")
+	}
+	if _, ok := disk.Get("a"); !ok {
+		t.Error("Expected a to be cached")
+	}
+	if disk.Size() != 4 {
+		t.Errorf("Expected 4 bytes, got %v", disk.Size())
+	}
+
+	// Values larger than the cache aren't stored
+	disk.Set("d", []byte("44444"))
+	if _, ok := disk.Get("d"); ok {
+		t.Error("Expected d to not be cached")
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("Expected 2 files, got %v", len(files))
+	}
+}
+
+// Concurrent writes and evictions of the same keys keep the index in sync with the files
+func TestDiskConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	disk, err := NewDisk(dir, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				key := strconv.Itoa((i + j) % 6)
+				disk.Set(key, []byte("11"))
+				disk.Get(key)
+			}
+		}()
+	}
+	wg.Wait()
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := 0
+	for _, file := range files {
+		info, err := file.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := disk.entries[file.Name()]; !ok {
+			t.Errorf("File %v isn't in the index", file.Name())
+		}
+		size += int(info.Size())
+	}
+	if len(files) != len(disk.entries) || size != disk.Size() {
+		t.Errorf("Expected %v files of %v bytes, got %v of %v bytes", len(disk.entries), disk.Size(), len(files), size)
+	}
+}
+
+func TestDiskRestart(t *testing.T) {
+	dir := t.TempDir()
+	disk, err := NewDisk(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk.Set("a", []byte("11"))
+	disk.Set("b", []byte("22"))
+	disk.Set("c", []byte("33"))
+
+	// Recency is kept in modification times, a is the most recently used
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(dir, disk.name("b")), old, old)
+	os.Chtimes(filepath.Join(dir, disk.name("c")), old.Add(time.Minute), old.Add(time.Minute))
+	// Left by an interrupted write
+	if err := os.WriteFile(filepath.Join(dir, TEMP_FILE_PREFIX+"1"), []byte("1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopening with a smaller limit evicts the oldest files
+	disk, err = NewDisk(dir, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := disk.Get("b"); ok {
+		t.Error("Expected b to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := disk.Get(key); !ok {
+			t.Errorf("Expected %v to be cached", key)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, TEMP_FILE_PREFIX+"1")); !os.IsNotExist(err) {
+		t.Error("Expected the temp file to be removed")
+	}
+}
+
+func TestTieredStats(t *testing.T) {
+	memory := NewLRU(100)
+	disk, err := NewDisk(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk.Set("a", []byte("1"))
+
+	c := New(NewTiered(memory, disk))
+
+	if value, ok := c.Get("a"); !ok || string(value) != "1" {
+		t.Fatalf("Expected a to be 1, got %q", value)
+	}
+	if _, ok := memory.Get("a"); !ok {
+		t.Error("Expected a to be copied to memory")
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Error("Expected b to not be cached")
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
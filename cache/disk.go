@@ -0,0 +1,178 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const TEMP_FILE_PREFIX = "tmp-"
+
+// The default size of the disk cache
+const DEFAULT_DISK_MAX_BYTES = 10 << 30
+
+// Disk stores entries as files in a directory so they persist across restarts.
+// The least recently used files are removed once their total size exceeds maxBytes, 0 is unlimited.
+// Recency is kept in the file modification times so it survives restarts
+type Disk struct {
+	dir      string
+	maxBytes int
+
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element // By file name
+	order   *list.List               // Front is the most recently used
+}
+
+type diskEntry struct {
+	name string
+	size int
+}
+
+func NewDisk(dir string, maxBytes int) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	d := &Disk{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	infos := []os.FileInfo{}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		// Left over from writes interrupted by a restart
+		if strings.HasPrefix(file.Name(), TEMP_FILE_PREFIX) {
+			os.Remove(filepath.Join(dir, file.Name()))
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+
+	slices.SortFunc(infos, func(a, b os.FileInfo) int {
+		return a.ModTime().Compare(b.ModTime())
+	})
+	for _, info := range infos {
+		d.add(info.Name(), int(info.Size()))
+	}
+	d.evict()
+
+	return d, nil
+}
+
+func (d *Disk) name(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+func (d *Disk) Get(key string) ([]byte, bool) {
+	name := d.name(key)
+	path := filepath.Join(d.dir, name)
+	value, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Failed to read cache entry", "error", err)
+		}
+		return nil, false
+	}
+
+	d.mu.Lock()
+	if el, ok := d.entries[name]; ok {
+		d.order.MoveToFront(el)
+	}
+	d.mu.Unlock()
+
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil && !os.IsNotExist(err) {
+		slog.Warn("Failed to update cache entry", "error", err)
+	}
+	return value, true
+}
+
+func (d *Disk) Set(key string, value []byte) {
+	if d.maxBytes > 0 && len(value) > d.maxBytes {
+		return
+	}
+
+	// Write to a temp file first so partial entries are never read
+	tmp, err := os.CreateTemp(d.dir, TEMP_FILE_PREFIX+"*")
+	if err != nil {
+		slog.Warn("Failed to create cache entry", "error", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(value); err != nil {
+		tmp.Close()
+		slog.Warn("Failed to write cache entry", "error", err)
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		slog.Warn("Failed to write cache entry", "error", err)
+		return
+	}
+
+	// The file and the index are updated together so a concurrent eviction can't remove the file after it is renamed but before it is indexed
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	name := d.name(key)
+	if err := os.Rename(tmp.Name(), filepath.Join(d.dir, name)); err != nil {
+		slog.Warn("Failed to write cache entry", "error", err)
+		return
+	}
+	d.add(name, len(value))
+	d.evict()
+}
+
+// add records a file as the most recently used, replacing any previous size
+func (d *Disk) add(name string, size int) {
+	if el, ok := d.entries[name]; ok {
+		d.size -= el.Value.(*diskEntry).size
+		d.order.Remove(el)
+	}
+	d.entries[name] = d.order.PushFront(&diskEntry{name, size})
+	d.size += size
+}
+
+func (d *Disk) evict() {
+	for d.maxBytes > 0 && d.size > d.maxBytes {
+		el := d.order.Back()
+		entry := el.Value.(*diskEntry)
+		d.order.Remove(el)
+		delete(d.entries, entry.name)
+		d.size -= entry.size
+
+		if err := os.Remove(filepath.Join(d.dir, entry.name)); err != nil && !os.IsNotExist(err) {
+			slog.Warn("Failed to evict cache entry", "error", err)
+		}
+	}
+}
+
+// Size is the total size of the files stored
+func (d *Disk) Size() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.size
+}
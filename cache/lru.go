@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// The default size of the in memory cache, a few large results rather than one per maximum response size
+const DEFAULT_MAX_BYTES = 128 << 20
+
+// LRU is an in memory store that evicts the least recently used entries once the total size of keys and values exceeds maxBytes.
+// Values larger than maxBytes aren't stored
+type LRU struct {
+	mu       sync.Mutex
+	maxBytes int
+	size     int
+	entries  map[string]*list.Element
+	order    *list.List // Front is the most recently used
+}
+
+type lruEntry struct {
+	key   string
+	value []byte
+}
+
+func (e *lruEntry) size() int {
+	return len(e.key) + len(e.value)
+}
+
+func NewLRU(maxBytes int) *LRU {
+	return &LRU{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (l *LRU) Get(key string) ([]byte, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, ok := l.entries[key]
+	if !ok {
+		return nil, false
+	}
+	l.order.MoveToFront(el)
+	return el.Value.(*lruEntry).value, true
+}
+
+func (l *LRU) Set(key string, value []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.entries[key]; ok {
+		l.remove(el)
+	}
+
+	entry := &lruEntry{key, value}
+	if entry.size() > l.maxBytes {
+		return
+	}
+	l.entries[key] = l.order.PushFront(entry)
+	l.size += entry.size()
+
+	for l.size > l.maxBytes {
+		l.remove(l.order.Back())
+	}
+}
+
+func (l *LRU) remove(el *list.Element) {
+	entry := el.Value.(*lruEntry)
+	l.order.Remove(el)
+	delete(l.entries, entry.key)
+	l.size -= entry.size()
+}
+
+func (l *LRU) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.order.Len()
+}
+
+// Size is the total size of the keys and values stored
+func (l *LRU) Size() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.size
+}
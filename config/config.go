@@ -0,0 +1,386 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/subquery/solana-takoyaki/api"
+	"github.com/subquery/solana-takoyaki/backend/sqd"
+	"github.com/subquery/solana-takoyaki/cache"
+	"github.com/subquery/solana-takoyaki/logging"
+	"github.com/subquery/solana-takoyaki/meta"
+	"gopkg.in/yaml.v3"
+)
+
+const DEFAULT_SQD_ENDPOINT = "https://portal.sqd.dev/datasets/solana-beta"
+
+// Environment variables are the flag name in upper snake case with this prefix, e.g. TAKOYAKI_MAX_BLOCKS
+const ENV_PREFIX = "TAKOYAKI_"
+
+type Config struct {
+	// The YAML file the config was loaded from
+	File string `yaml:"-"`
+	// Print the config and exit
+	PrintConfig bool `yaml:"-"`
+
+	Network  string         `yaml:"network"`
+	Server   ServerConfig   `yaml:"server"`
+	Upstream UpstreamConfig `yaml:"upstream"`
+	Limits   LimitsConfig   `yaml:"limits"`
+	Auth     AuthConfig     `yaml:"auth"`
+	Cache    CacheConfig    `yaml:"cache"`
+	Logging  LoggingConfig  `yaml:"logging"`
+	Tracing  TracingConfig  `yaml:"tracing"`
+	IdlDir   string         `yaml:"idlDir"`
+}
+
+type ServerConfig struct {
+	Port            uint          `yaml:"port"`
+	WsOrigins       string        `yaml:"wsOrigins"`
+	MaxHeadLag      time.Duration `yaml:"maxHeadLag"`
+	ReadTimeout     time.Duration `yaml:"readTimeout"`
+	WriteTimeout    time.Duration `yaml:"writeTimeout"`
+	IdleTimeout     time.Duration `yaml:"idleTimeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+	// 0 uses GOMAXPROCS
+	TransformConcurrency int `yaml:"transformConcurrency"`
+}
+
+type UpstreamConfig struct {
+	SqdEndpoint      string        `yaml:"sqdEndpoint"`
+	Timeout          time.Duration `yaml:"timeout"`
+	ArchiveDir       string        `yaml:"archiveDir"`
+	QueryChunkSize   uint          `yaml:"queryChunkSize"`
+	QueryConcurrency int           `yaml:"queryConcurrency"`
+}
+
+type LimitsConfig struct {
+	MaxBlocks        int           `yaml:"maxBlocks"`
+	MaxResponseBytes int           `yaml:"maxResponseBytes"`
+	MaxRangeWidth    uint          `yaml:"maxRangeWidth"`
+	MaxFilters       int           `yaml:"maxFilters"`
+	QuotaBlocks      uint64        `yaml:"quotaBlocks"`
+	QuotaWindow      time.Duration `yaml:"quotaWindow"`
+}
+
+type AuthConfig struct {
+	ApiKeys   string  `yaml:"apiKeys"`
+	RateLimit float64 `yaml:"rateLimit"`
+	RateBurst int     `yaml:"rateBurst"`
+}
+
+type CacheConfig struct {
+	MaxBytes    int    `yaml:"maxBytes"`
+	Dir         string `yaml:"dir"`
+	DirMaxBytes int    `yaml:"dirMaxBytes"`
+}
+
+type LoggingConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+type TracingConfig struct {
+	OtlpEndpoint string `yaml:"otlpEndpoint"`
+}
+
+func Default() Config {
+	return Config{
+		Network: meta.MAINNET.ChainId,
+		Server: ServerConfig{
+			Port:            8080,
+			WsOrigins:       "*",
+			MaxHeadLag:      api.DEFAULT_MAX_HEAD_LAG,
+			ReadTimeout:     30 * time.Second,
+			WriteTimeout:    5 * time.Minute,
+			IdleTimeout:     2 * time.Minute,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Upstream: UpstreamConfig{
+			SqdEndpoint:      DEFAULT_SQD_ENDPOINT,
+			QueryChunkSize:   sqd.DEFAULT_PLANNER_CHUNK_SIZE,
+			QueryConcurrency: sqd.DEFAULT_PLANNER_CONCURRENCY,
+		},
+		Limits: LimitsConfig{
+			MaxBlocks:        api.DEFAULT_LIMITS.MaxBlocks,
+			MaxResponseBytes: api.DEFAULT_LIMITS.MaxResponseBytes,
+			MaxRangeWidth:    api.DEFAULT_LIMITS.MaxRangeWidth,
+			MaxFilters:       api.DEFAULT_LIMITS.MaxFilters,
+			QuotaWindow:      time.Hour,
+		},
+		Cache: CacheConfig{
+			MaxBytes:    cache.DEFAULT_MAX_BYTES,
+			DirMaxBytes: cache.DEFAULT_DISK_MAX_BYTES,
+		},
+		Logging: LoggingConfig{
+			Level:  "info",
+			Format: "text",
+		},
+	}
+}
+
+// flagSet binds a flag for every setting to the fields of c
+func (c *Config) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	fs.StringVar(&c.File, "config", c.File, "YAML config file, environment variables and flags take precedence over the file")
+	fs.BoolVar(&c.PrintConfig, "print-config", c.PrintConfig, "Print the resolved config as YAML and exit")
+
+	fs.StringVar(&c.Network, "network", c.Network, "Network served, one of "+strings.Join(networkNames(), ", "))
+	fs.UintVar(&c.Server.Port, "port", c.Server.Port, "Port to listen on")
+	fs.StringVar(&c.Server.WsOrigins, "wsOrigins", c.Server.WsOrigins, "Comma separated list of allowed websocket origins")
+	fs.DurationVar(&c.Server.ReadTimeout, "readTimeout", c.Server.ReadTimeout, "Maximum duration to read a request, 0 is no timeout")
+	fs.DurationVar(&c.Server.WriteTimeout, "writeTimeout", c.Server.WriteTimeout, "Maximum duration from reading a request to writing the response, 0 is no timeout")
+	fs.DurationVar(&c.Server.IdleTimeout, "idleTimeout", c.Server.IdleTimeout, "Maximum duration keep-alive connections are kept open between requests")
+	fs.DurationVar(&c.Server.ShutdownTimeout, "shutdownTimeout", c.Server.ShutdownTimeout, "Maximum duration to wait for in-flight requests on shutdown before cancelling them")
+	fs.IntVar(&c.Server.TransformConcurrency, "transformConcurrency", c.Server.TransformConcurrency, "Number of blocks transformed at once for each request, 0 uses the number of CPUs")
+	fs.DurationVar(&c.Server.MaxHeadLag, "maxHeadLag", c.Server.MaxHeadLag, "Maximum lag of the latest block behind the wall clock before /ready reports not ready")
+
+	fs.StringVar(&c.Upstream.SqdEndpoint, "sqdEndpoint", c.Upstream.SqdEndpoint, "SQD portal dataset url")
+	fs.DurationVar(&c.Upstream.Timeout, "portalTimeout", c.Upstream.Timeout, "Timeout of each portal request including streaming the response, 0 is no timeout")
+	fs.StringVar(&c.Upstream.ArchiveDir, "archiveDir", c.Upstream.ArchiveDir, "Serve blocks from a local archive created with takoyaki archive instead of the portal")
+	fs.UintVar(&c.Upstream.QueryChunkSize, "queryChunkSize", c.Upstream.QueryChunkSize, "Number of slots per chunk when splitting wide queries")
+	fs.IntVar(&c.Upstream.QueryConcurrency, "queryConcurrency", c.Upstream.QueryConcurrency, "Number of query chunks fetched at once from the portal or archive, 1 disables splitting")
+
+	fs.IntVar(&c.Limits.MaxBlocks, "maxBlocks", c.Limits.MaxBlocks, "Maximum blocks per response, larger limits are truncated")
+	fs.IntVar(&c.Limits.MaxResponseBytes, "maxResponseBytes", c.Limits.MaxResponseBytes, "Maximum JSON size of the blocks in a response, 0 is unlimited")
+	fs.UintVar(&c.Limits.MaxRangeWidth, "maxRangeWidth", c.Limits.MaxRangeWidth, "Maximum slots searched per request, 0 is unlimited")
+	fs.IntVar(&c.Limits.MaxFilters, "maxFilters", c.Limits.MaxFilters, "Maximum filters of each type per request")
+	fs.Uint64Var(&c.Limits.QuotaBlocks, "quotaBlocks", c.Limits.QuotaBlocks, "Maximum blocks served to each API key or address per quota window, 0 disables quotas")
+	fs.DurationVar(&c.Limits.QuotaWindow, "quotaWindow", c.Limits.QuotaWindow, "Duration of the quota window")
+
+	fs.StringVar(&c.Auth.ApiKeys, "apiKeys", c.Auth.ApiKeys, "JSON file of API keys, if set requests require a valid API key")
+	fs.Float64Var(&c.Auth.RateLimit, "rateLimit", c.Auth.RateLimit, "Requests per second for each API key or address without a specific limit, 0 is unlimited")
+	fs.IntVar(&c.Auth.RateBurst, "rateBurst", c.Auth.RateBurst, "Burst of requests allowed above the rate limit, defaults to the rate limit")
+
+	fs.IntVar(&c.Cache.MaxBytes, "cacheMaxBytes", c.Cache.MaxBytes, "Maximum size of the finalized query results cached in memory, 0 disables caching in memory")
+	fs.StringVar(&c.Cache.Dir, "cacheDir", c.Cache.Dir, "Directory to persist finalized query results across restarts")
+	fs.IntVar(&c.Cache.DirMaxBytes, "cacheDirMaxBytes", c.Cache.DirMaxBytes, "Maximum size of the results in cacheDir, the least recently used are removed, 0 is unlimited")
+
+	fs.StringVar(&c.Logging.Level, "logLevel", c.Logging.Level, "Log level, one of debug, info, warn, error")
+	fs.StringVar(&c.Logging.Format, "logFormat", c.Logging.Format, "Log format, text or json")
+
+	fs.StringVar(&c.Tracing.OtlpEndpoint, "otlpEndpoint", c.Tracing.OtlpEndpoint, "OTLP/HTTP collector url traces are exported to e.g. http://localhost:4318, overrides OTEL_EXPORTER_OTLP_ENDPOINT. Tracing is disabled if neither is set")
+	fs.StringVar(&c.IdlDir, "idlDir", c.IdlDir, "Directory of Anchor IDLs used to decode instructions and events, files are keyed by program id")
+
+	return fs
+}
+
+// Load resolves the config from the defaults, a YAML file, environment variables and flags, in increasing precedence.
+// flag.ErrHelp is returned if help was requested
+func Load(name string, args []string) (*Config, error) {
+	cfg := Default()
+	fs := cfg.flagSet(name)
+
+	// The config file can be set by a flag or environment variable so these are resolved first
+	if err := applyEnv(fs); err != nil {
+		return nil, err
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	file := cfg.File
+	cfg = Default()
+	cfg.File = file
+	if file != "" {
+		if err := cfg.loadFile(file); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyEnv(fs); err != nil {
+		return nil, err
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Failed to read config file: %w", err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(raw))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("Failed to parse config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// applyEnv sets any flags that have an environment variable
+func applyEnv(fs *flag.FlagSet) error {
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := EnvName(f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		if err := fs.Set(f.Name, value); err != nil {
+			errs = append(errs, fmt.Errorf("Invalid value %q for %s: %w", value, name, err))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// EnvName returns the environment variable for a flag, e.g. maxBlocks is TAKOYAKI_MAX_BLOCKS
+func EnvName(flagName string) string {
+	var b strings.Builder
+	b.WriteString(ENV_PREFIX)
+	for i, r := range flagName {
+		if r == '-' {
+			b.WriteRune('_')
+			continue
+		}
+		if unicode.IsUpper(r) && i > 0 {
+			b.WriteRune('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// Validate checks all settings, every invalid setting is included in the error
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if _, ok := meta.NETWORKS[c.Network]; !ok {
+		invalid("Unknown network %q, expected one of %s", c.Network, strings.Join(networkNames(), ", "))
+	}
+	if c.Server.Port == 0 || c.Server.Port > 65535 {
+		invalid("Port must be between 1 and 65535")
+	}
+
+	if c.Server.ReadTimeout < 0 || c.Server.WriteTimeout < 0 || c.Server.IdleTimeout < 0 || c.Server.ShutdownTimeout < 0 {
+		invalid("Server timeouts can't be negative")
+	}
+	if c.Server.TransformConcurrency < 0 {
+		invalid("transformConcurrency can't be negative")
+	}
+	if c.Server.MaxHeadLag <= 0 {
+		invalid("maxHeadLag must be positive")
+	}
+
+	if c.Upstream.ArchiveDir == "" {
+		if err := validateUrl(c.Upstream.SqdEndpoint); err != nil {
+			invalid("Invalid sqdEndpoint: %v", err)
+		}
+	}
+	if c.Upstream.Timeout < 0 {
+		invalid("portalTimeout can't be negative")
+	}
+	if c.Upstream.QueryConcurrency < 1 {
+		invalid("queryConcurrency must be at least 1")
+	}
+
+	if c.Limits.MaxBlocks < 1 {
+		invalid("maxBlocks must be at least 1")
+	}
+	if c.Limits.MaxResponseBytes < 0 {
+		invalid("maxResponseBytes can't be negative")
+	}
+	if c.Limits.MaxFilters < 1 {
+		invalid("maxFilters must be at least 1")
+	}
+	if c.Limits.QuotaWindow <= 0 {
+		invalid("quotaWindow must be positive")
+	}
+
+	if c.Auth.RateLimit < 0 {
+		invalid("rateLimit can't be negative")
+	}
+	if c.Auth.RateBurst < 0 {
+		invalid("rateBurst can't be negative")
+	}
+
+	if c.Cache.MaxBytes < 0 {
+		invalid("cacheMaxBytes can't be negative")
+	}
+	if c.Cache.DirMaxBytes < 0 {
+		invalid("cacheDirMaxBytes can't be negative")
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Logging.Level)); err != nil {
+		invalid("Invalid logLevel %q", c.Logging.Level)
+	}
+	if c.Logging.Format != "text" && c.Logging.Format != "json" {
+		invalid("Invalid logFormat %q, expected text or json", c.Logging.Format)
+	}
+
+	if c.Tracing.OtlpEndpoint != "" {
+		if err := validateUrl(c.Tracing.OtlpEndpoint); err != nil {
+			invalid("Invalid otlpEndpoint: %v", err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// NetworkMeta returns the meta of the configured network
+func (c *Config) NetworkMeta() meta.NetworkMeta {
+	return meta.NETWORKS[c.Network]
+}
+
+// Logger creates a logger with the configured level and format, records include the request id of their context
+func (c *LoggingConfig) Logger(w io.Writer) *slog.Logger {
+	var level slog.Level
+	// Validated on load
+	_ = level.UnmarshalText([]byte(c.Level))
+
+	return slog.New(logging.NewHandler(w, level, c.Format))
+}
+
+// Print writes the config as YAML
+func (c *Config) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func validateUrl(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q must be an http or https url", raw)
+	}
+	return nil
+}
+
+func networkNames() []string {
+	names := make([]string, 0, len(meta.NETWORKS))
+	for name := range meta.NETWORKS {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
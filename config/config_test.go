@@ -0,0 +1,125 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(file, []byte(`
+server:
+  port: 9000
+  wsOrigins: https://example.com
+upstream:
+  timeout: 30s
+limits:
+  maxBlocks: 10
+  maxFilters: 5
+logging:
+  format: json
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("TAKOYAKI_CONFIG", file)
+	t.Setenv("TAKOYAKI_MAX_BLOCKS", "20")
+	t.Setenv("TAKOYAKI_LOG_LEVEL", "debug")
+
+	cfg, err := Load("takoyaki", []string{"-maxFilters", "50"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Defaults
+	if cfg.Upstream.SqdEndpoint != DEFAULT_SQD_ENDPOINT {
+		t.Errorf("Expected default sqd endpoint, got %v", cfg.Upstream.SqdEndpoint)
+	}
+	// File
+	if cfg.Server.Port != 9000 || cfg.Server.WsOrigins != "https://example.com" || cfg.Upstream.Timeout != 30*time.Second || cfg.Logging.Format != "json" {
+		t.Errorf("Expected values from the config file, got %+v", cfg)
+	}
+	// Env overrides the file
+	if cfg.Limits.MaxBlocks != 20 || cfg.Logging.Level != "debug" {
+		t.Errorf("Expected values from the environment, got %+v", cfg)
+	}
+	// Flags override env and the file
+	if cfg.Limits.MaxFilters != 50 {
+		t.Errorf("Expected maxFilters from flags, got %v", cfg.Limits.MaxFilters)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	_, err := Load("takoyaki", []string{"-port", "0", "-network", "testnet", "-logFormat", "xml", "-sqdEndpoint", "portal"})
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	for _, setting := range []string{"Port", "network", "logFormat", "sqdEndpoint"} {
+		if !strings.Contains(err.Error(), setting) {
+			t.Errorf("Expected error to include %v, got %v", setting, err)
+		}
+	}
+
+	t.Setenv("TAKOYAKI_MAX_BLOCKS", "many")
+	if _, err := Load("takoyaki", nil); err == nil || !strings.Contains(err.Error(), "TAKOYAKI_MAX_BLOCKS") {
+		t.Errorf("Expected an invalid environment variable error, got %v", err)
+	}
+}
+
+func TestLoadUnknownField(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte("limits:\n  maxBlock: 10\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load("takoyaki", []string{"-config", file}); err == nil {
+		t.Errorf("Expected an error for an unknown field")
+	}
+}
+
+func TestPrintRoundTrip(t *testing.T) {
+	cfg, err := Load("takoyaki", []string{"-quotaWindow", "30m", "-cacheDir", "/tmp/cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := cfg.Print(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "quotaWindow: 30m0s") {
+		t.Errorf("Expected durations to be printed as strings, got\n%s", buf.String())
+	}
+
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load("takoyaki", []string{"-config", file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded.File = ""
+	if *loaded != *cfg {
+		t.Errorf("Expected printed config to load the same config\n%+v\n%+v", loaded, cfg)
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	tests := map[string]string{
+		"port":         "TAKOYAKI_PORT",
+		"maxBlocks":    "TAKOYAKI_MAX_BLOCKS",
+		"print-config": "TAKOYAKI_PRINT_CONFIG",
+		"otlpEndpoint": "TAKOYAKI_OTLP_ENDPOINT",
+	}
+	for flagName, expected := range tests {
+		if got := EnvName(flagName); got != expected {
+			t.Errorf("Expected %v for %v, got %v", expected, flagName, got)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/subquery/solana-takoyaki/backend/sqd"
+	"github.com/subquery/solana-takoyaki/backend/sqd/conformance"
+	"github.com/subquery/solana-takoyaki/config"
+)
+
+// The directory recorded pairs are written to for the conformance tests
+const CONFORMANCE_TESTDATA = "backend/sqd/conformance/testdata/recorded"
+
+// runConformance records pairs of portal blocks and getBlock results and reports how they differ.
+// Usage: `takoyaki conformance -rpcEndpoint <url> -slots <slots>` to fetch them, or `takoyaki conformance -fixtures ./fixtures` for files downloaded already
+func runConformance(args []string) {
+	flags := flag.NewFlagSet("conformance", flag.ExitOnError)
+	fixtures := flags.String("fixtures", "", "Directory of portal blocks (*.ndjson) and getBlock results (<slot>.json) to record, fetched blocks are written here")
+	out := flags.String("out", CONFORMANCE_TESTDATA, "Directory to write the pairs to")
+	sqdEndpoint := flags.String("sqdEndpoint", config.DEFAULT_SQD_ENDPOINT, "SQD portal dataset url to fetch blocks from")
+	rpcEndpoint := flags.String("rpcEndpoint", "", "Solana RPC url to fetch getBlock results from, if set the blocks of -slots are fetched before recording")
+	slotList := flags.String("slots", "", "Comma separated slots to fetch")
+
+	flags.Parse(args)
+
+	if err := recordConformance(*fixtures, *out, *sqdEndpoint, *rpcEndpoint, *slotList); err != nil {
+		fatal("Error recording conformance pairs", err)
+	}
+}
+
+// recordConformance returns errors rather than exiting so a temporary fixtures directory is always removed
+func recordConformance(fixtures, out, sqdEndpoint, rpcEndpoint, slotList string) error {
+	if rpcEndpoint != "" {
+		slots := []uint64{}
+		for _, s := range strings.Split(slotList, ",") {
+			slot, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+			if err != nil {
+				return fmt.Errorf("Invalid slot %q: %w", s, err)
+			}
+			slots = append(slots, slot)
+		}
+
+		if fixtures == "" {
+			dir, err := os.MkdirTemp("", "conformance-*")
+			if err != nil {
+				return fmt.Errorf("Failed to create fixtures directory: %w", err)
+			}
+			defer os.RemoveAll(dir)
+			fixtures = dir
+		}
+
+		if err := conformance.Fetch(context.Background(), sqd.NewSoldexerClient(sqdEndpoint), rpcEndpoint, slots, fixtures); err != nil {
+			return fmt.Errorf("Failed to fetch blocks: %w", err)
+		}
+	}
+
+	if fixtures == "" {
+		return fmt.Errorf("A fixtures directory or an RPC endpoint is required")
+	}
+
+	pairs, err := conformance.Record(fixtures, out)
+	if err != nil {
+		return err
+	}
+	if len(pairs) == 0 {
+		return fmt.Errorf("No slots have both a portal block and a getBlock result")
+	}
+
+	for _, pair := range pairs {
+		diffs, err := pair.Diff()
+		if err != nil {
+			return fmt.Errorf("Failed to compare block %v: %w", pair.Name, err)
+		}
+		for _, diff := range diffs {
+			slog.Warn("Block differs from the RPC", "slot", pair.Name, "difference", diff.String())
+		}
+		slog.Info("Recorded conformance pair", "slot", pair.Name, "differences", len(diffs))
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+)
+
+type requestIdKey struct{}
+
+// WithRequestId returns a context with a new random request id, log records using the context include the id
+func WithRequestId(ctx context.Context) context.Context {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return context.WithValue(ctx, requestIdKey{}, hex.EncodeToString(id))
+}
+
+// RequestId returns the request id of the context, or an empty string if there isn't one
+func RequestId(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+// NewHandler creates a text or json handler that adds the request id of the context to records
+func NewHandler(w io.Writer, level slog.Level, format string) slog.Handler {
+	opts := &slog.HandlerOptions{Level: level}
+	if format == "json" {
+		return &contextHandler{slog.NewJSONHandler(w, opts)}
+	}
+	return &contextHandler{slog.NewTextHandler(w, opts)}
+}
+
+// contextHandler adds values from the context to records
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestId(ctx); id != "" {
+		r.AddAttrs(slog.String("requestId", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,45 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestRequestId(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandler(&buf, slog.LevelInfo, "json")).With("component", "api")
+
+	ctx := WithRequestId(context.Background())
+	if len(RequestId(ctx)) != 16 {
+		t.Fatalf("Unexpected request id %q", RequestId(ctx))
+	}
+	if RequestId(WithRequestId(context.Background())) == RequestId(ctx) {
+		t.Errorf("Expected unique request ids")
+	}
+
+	logger.InfoContext(ctx, "with id")
+	logger.Info("without id")
+	logger.DebugContext(ctx, "below level")
+
+	dec := json.NewDecoder(&buf)
+	var withId, withoutId map[string]any
+	if err := dec.Decode(&withId); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Decode(&withoutId); err != nil {
+		t.Fatal(err)
+	}
+	if dec.More() {
+		t.Errorf("Expected debug records to be dropped")
+	}
+
+	if withId["requestId"] != RequestId(ctx) || withId["component"] != "api" {
+		t.Errorf("Expected request id and attrs, got %v", withId)
+	}
+	if _, ok := withoutId["requestId"]; ok {
+		t.Errorf("Expected no request id, got %v", withoutId)
+	}
+}
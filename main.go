@@ -1,50 +1,184 @@
 package main
 
 import (
-	// "context"
+	"context"
 	"flag"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/subquery/solana-takoyaki/anchor"
 	"github.com/subquery/solana-takoyaki/api"
-	// "github.com/subquery/solana-takoyaki/backend/sqd"
-	"github.com/subquery/solana-takoyaki/meta"
+	"github.com/subquery/solana-takoyaki/backend/archive"
+	"github.com/subquery/solana-takoyaki/backend/sqd"
+	"github.com/subquery/solana-takoyaki/cache"
+	"github.com/subquery/solana-takoyaki/config"
+	"github.com/subquery/solana-takoyaki/metrics"
+	"github.com/subquery/solana-takoyaki/server"
+	"github.com/subquery/solana-takoyaki/tracing"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "archive" {
+		runArchive(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "conformance" {
+		runConformance(os.Args[2:])
+		return
+	}
 
-	port := flag.Uint("port", 8080, "Port to listen on")
-	// sqdEndpoint := flag.String("sqdEndpoint", "https://v2.archive.subsquid.io/network/solana-mainnet", "SQD archive endpoint")
+	cfg, err := config.Load(os.Args[0], os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fatal("Invalid config", err)
+	}
 
-	flag.Parse()
+	if cfg.PrintConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			fatal("Error printing config", err)
+		}
+		return
+	}
 
-	// sqdUrl, err := sqd.GetSquidUrl(context.Background(), "solana-mainnet")
-	// if err != nil {
-	// 	fmt.Printf("Failed to get SQD url: %v", err)
-	// 	panic(1)
-	// }
+	slog.SetDefault(cfg.Logging.Logger(os.Stderr))
 
-	sqdUrl := "https://portal.sqd.dev/datasets/solana-beta"
+	// Cancelled on SIGINT or SIGTERM to start a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	subqlApi, err := api.NewSubqlApiService(meta.MAINNET, sqdUrl)
-	if err != nil {
-		fmt.Println("Error creating subql rpc service", err)
-		panic(1)
+	if tracing.Enabled(cfg.Tracing.OtlpEndpoint) {
+		shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.OtlpEndpoint)
+		if err != nil {
+			fatal("Error setting up tracing", err)
+		}
+		defer shutdownTracing(context.Background())
+	}
+
+	var idls *anchor.Registry
+	if cfg.IdlDir != "" {
+		idls, err = anchor.LoadDir(cfg.IdlDir)
+		if err != nil {
+			fatal("Error loading IDLs", err)
+		}
+	}
+
+	var queryCache *cache.Cache
+	if cfg.Cache.MaxBytes > 0 || cfg.Cache.Dir != "" {
+		stores := []cache.Store{}
+		if cfg.Cache.MaxBytes > 0 {
+			stores = append(stores, cache.NewLRU(cfg.Cache.MaxBytes))
+		}
+		if cfg.Cache.Dir != "" {
+			disk, err := cache.NewDisk(cfg.Cache.Dir, cfg.Cache.DirMaxBytes)
+			if err != nil {
+				fatal("Error creating cache directory", err)
+			}
+			stores = append(stores, disk)
+		}
+		queryCache = cache.New(cache.NewTiered(stores...))
+		metrics.RegisterCache(queryCache)
+	}
+
+	var backend sqd.Backend
+	if cfg.Upstream.ArchiveDir != "" {
+		backend, err = archive.Open(cfg.Upstream.ArchiveDir)
+		if err != nil {
+			fatal("Error opening archive", err)
+		}
+	} else {
+		sqdClient := sqd.NewSoldexerClient(cfg.Upstream.SqdEndpoint)
+		sqdClient.SetNetwork(cfg.NetworkMeta())
+		sqdClient.SetTimeout(cfg.Upstream.Timeout)
+		if queryCache != nil {
+			sqdClient.SetCache(queryCache)
+		}
+		backend = sqdClient
+	}
+
+	subqlApi := api.NewSubqlApiServiceWithBackend(sqd.NewPlanner(backend, cfg.Upstream.QueryChunkSize, cfg.Upstream.QueryConcurrency), idls)
+	subqlApi.SetLimits(api.Limits{
+		MaxBlocks:        cfg.Limits.MaxBlocks,
+		MaxResponseBytes: cfg.Limits.MaxResponseBytes,
+		MaxRangeWidth:    cfg.Limits.MaxRangeWidth,
+		MaxFilters:       cfg.Limits.MaxFilters,
+	})
+	subqlApi.SetTransformConcurrency(cfg.Server.TransformConcurrency)
+	subqlApi.SetStreamWriteTimeout(cfg.Server.WriteTimeout)
+
+	apiKeys := []server.APIKeyConfig{}
+	if cfg.Auth.ApiKeys != "" {
+		apiKeys, err = server.LoadAPIKeys(cfg.Auth.ApiKeys)
+		if err != nil {
+			fatal("Error loading API keys", err)
+		}
 	}
 
-	server := rpc.NewServer()
-	err = server.RegisterName("subql", subqlApi)
+	quotas := api.NewQuotas(cfg.Limits.QuotaBlocks, cfg.Limits.QuotaWindow)
+	hasQuotas := cfg.Limits.QuotaBlocks > 0
+	for _, key := range apiKeys {
+		if key.QuotaBlocks > 0 {
+			quotas.SetKeyLimit(key.Key, key.QuotaBlocks)
+			hasQuotas = true
+		}
+	}
+	if hasQuotas {
+		subqlApi.SetQuotas(quotas)
+	}
+
+	rpcServer := rpc.NewServer()
+	err = rpcServer.RegisterName("subql", subqlApi)
 	if err != nil {
-		fmt.Println("Error registering subql rpc service", err)
-		panic(1)
+		fatal("Error registering subql rpc service", err)
+	}
+
+	addr := fmt.Sprintf(":%v", cfg.Server.Port)
+	httpHandler := server.Compress(server.CompactEncoding(rpcServer))
+	wsHandler := rpcServer.WebsocketHandler(strings.Split(cfg.Server.WsOrigins, ","))
+	go subqlApi.TrackHeadLag(ctx.Done())
+	http.Handle("/metrics", metrics.Handler())
+
+	health := api.NewHealth(subqlApi, cfg.Server.MaxHeadLag)
+	http.HandleFunc("/health", health.Live)
+	http.HandleFunc("/ready", health.Ready)
+	http.HandleFunc("/status", health.Status)
+
+	auth := server.NewAuth(apiKeys, cfg.Auth.RateLimit, cfg.Auth.RateBurst)
+	go auth.LogUsage(ctx.Done())
+	http.Handle("/", tracing.Handler(server.APIKey(auth.Handler(server.Websocket(httpHandler, wsHandler)))))
+	streamHandler := tracing.Handler(server.APIKey(auth.Handler(server.Compress(http.HandlerFunc(subqlApi.Stream)))))
+	http.Handle(server.STREAM_PATH, streamHandler)
+	http.Handle(server.STREAM_PATH+"/", streamHandler)
+
+	srv := &http.Server{
+		Addr:         addr,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
 	}
+	// Websocket connections aren't drained, closing them ends their subscriptions so clients can reconnect elsewhere
+	srv.RegisterOnShutdown(rpcServer.Stop)
 
-	addr := fmt.Sprintf(":%v", *port)
-	http.Handle("/", server)
-	fmt.Printf("Starting HTTP server on %v\n", *port)
-	if err := http.ListenAndServe(addr, nil); err != nil {
-		fmt.Printf("HTTP server failed: %v", err)
-		panic(1)
+	slog.Info("Starting HTTP server", "port", cfg.Server.Port)
+	if err := server.Serve(ctx, srv, cfg.Server.ShutdownTimeout); err != nil {
+		fatal("HTTP server failed", err)
+	}
+	slog.Info("Shutdown complete")
+}
+
+// fatal logs the error and exits
+func fatal(msg string, err error) {
+	if err != nil {
+		slog.Error(msg, "error", err)
+	} else {
+		slog.Error(msg)
 	}
+	os.Exit(1)
 }
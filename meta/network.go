@@ -19,3 +19,9 @@ var ECLIPSE_MAINNET = NetworkMeta{
 	GenesisHash:      "", // TODO
 	EarliestSQDBlock: 24_641_070,
 }
+
+// NETWORKS are the supported networks by name
+var NETWORKS = map[string]NetworkMeta{
+	MAINNET.ChainId:         MAINNET,
+	ECLIPSE_MAINNET.ChainId: ECLIPSE_MAINNET,
+}
@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/subquery/solana-takoyaki/cache"
+)
+
+const NAMESPACE = "takoyaki"
+
+var Registry = prometheus.NewRegistry()
+
+var (
+	rpcRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "rpc_requests_total",
+		Help:      "JSON-RPC requests by method and result",
+	}, []string{"method", "result"})
+
+	rpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "rpc_request_duration_seconds",
+		Help:      "JSON-RPC request latency by method",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"method"})
+
+	portalRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "portal_requests_total",
+		Help:      "Portal HTTP requests by endpoint and status code, transport errors have the status error",
+	}, []string{"endpoint", "status"})
+
+	portalDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "portal_request_duration_seconds",
+		Help:      "Portal HTTP request latency by endpoint, streams are measured until the last block is read",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"endpoint"})
+
+	portalBlocks = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "portal_blocks_total",
+		Help:      "Blocks streamed from the portal",
+	})
+
+	portalBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "portal_bytes_total",
+		Help:      "Bytes of blocks streamed from the portal",
+	})
+
+	transformErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "transform_errors_total",
+		Help:      "Blocks that failed to transform from the portal format",
+	})
+
+	transformWarnings = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "transform_warnings_total",
+		Help:      "Transactions and rewards skipped because they failed to transform",
+	})
+
+	headLag = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "head_lag_seconds",
+		Help:      "Seconds between the latest portal block timestamp and the wall clock",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		rpcRequests,
+		rpcDuration,
+		portalRequests,
+		portalDuration,
+		portalBlocks,
+		portalBytes,
+		transformErrors,
+		transformWarnings,
+		headLag,
+	)
+}
+
+// Handler serves the metrics in the Prometheus format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRPC records a JSON-RPC method call, use with defer and a named error result
+func ObserveRPC(method string, start time.Time, err *error) {
+	result := "success"
+	if err != nil && *err != nil {
+		result = "error"
+	}
+	rpcRequests.WithLabelValues(method, result).Inc()
+	rpcDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+// ObservePortal records a portal request, status is 0 if the request failed before a response
+func ObservePortal(endpoint string, status int, start time.Time) {
+	statusLabel := "error"
+	if status != 0 {
+		statusLabel = strconv.Itoa(status)
+	}
+	portalRequests.WithLabelValues(endpoint, statusLabel).Inc()
+	portalDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+}
+
+func AddPortalBlocks(blocks int, bytes int) {
+	portalBlocks.Add(float64(blocks))
+	portalBytes.Add(float64(bytes))
+}
+
+func IncTransformErrors() {
+	transformErrors.Inc()
+}
+
+func IncTransformWarnings() {
+	transformWarnings.Inc()
+}
+
+func SetHeadLag(seconds int64) {
+	headLag.Set(float64(seconds))
+}
+
+// RegisterCache exposes the hits and misses of a query cache
+func RegisterCache(c *cache.Cache) {
+	Registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: NAMESPACE,
+			Name:      "cache_hits_total",
+			Help:      "Query cache hits",
+		}, func() float64 { return float64(c.Stats().Hits) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: NAMESPACE,
+			Name:      "cache_misses_total",
+			Help:      "Query cache misses",
+		}, func() float64 { return float64(c.Stats().Misses) }),
+	)
+}
@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	err := errors.New("failed")
+	ObserveRPC("subql_filterBlocks", time.Now(), &err)
+	ObservePortal("/head", 200, time.Now())
+	ObservePortal("/stream", 0, time.Now())
+	AddPortalBlocks(3, 1024)
+	SetHeadLag(2)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+
+	expected := []string{
+		`takoyaki_rpc_requests_total{method="subql_filterBlocks",result="error"} 1`,
+		`takoyaki_portal_requests_total{endpoint="/head",status="200"} 1`,
+		`takoyaki_portal_requests_total{endpoint="/stream",status="error"} 1`,
+		`takoyaki_portal_blocks_total 3`,
+		`takoyaki_portal_bytes_total 1024`,
+		`takoyaki_head_lag_seconds 2`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(string(body), line) {
+			t.Errorf("Expected metrics to contain %q", line)
+		}
+	}
+}
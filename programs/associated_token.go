@@ -0,0 +1,55 @@
+package programs
+
+import (
+	"fmt"
+
+	"github.com/subquery/solana-takoyaki/solana"
+)
+
+// Associated token account instructions use a u8 index, empty data is a legacy create instruction
+// https://github.com/anza-xyz/agave/blob/master/transaction-status/src/parse_associated_token.rs
+func parseAssociatedToken(accounts []string, data []byte) (*solana.ParsedInstructionInfo, error) {
+	idx := uint8(0)
+	if len(data) > 0 {
+		idx = data[0]
+	}
+
+	info := map[string]interface{}{}
+	instType := ""
+
+	switch idx {
+	case 0, 1:
+		instType = "create"
+		if idx == 1 {
+			instType = "createIdempotent"
+		}
+		if err := checkAccounts(accounts, 6); err != nil {
+			return nil, err
+		}
+		info["source"] = accounts[0]
+		info["account"] = accounts[1]
+		info["wallet"] = accounts[2]
+		info["mint"] = accounts[3]
+		info["systemProgram"] = accounts[4]
+		info["tokenProgram"] = accounts[5]
+	case 2:
+		instType = "recoverNested"
+		if err := checkAccounts(accounts, 7); err != nil {
+			return nil, err
+		}
+		info["nestedSource"] = accounts[0]
+		info["nestedMint"] = accounts[1]
+		info["destination"] = accounts[2]
+		info["nestedOwner"] = accounts[3]
+		info["ownerMint"] = accounts[4]
+		info["wallet"] = accounts[5]
+		info["tokenProgram"] = accounts[6]
+	default:
+		return nil, fmt.Errorf("Unknown instruction: %v", idx)
+	}
+
+	return &solana.ParsedInstructionInfo{
+		Type: instType,
+		Info: info,
+	}, nil
+}
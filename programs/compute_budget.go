@@ -0,0 +1,70 @@
+package programs
+
+import (
+	"fmt"
+
+	"github.com/subquery/solana-takoyaki/solana"
+)
+
+// Compute budget instructions are borsh encoded with a u8 index
+// https://github.com/anza-xyz/agave/blob/master/sdk/compute-budget-interface/src/lib.rs
+func parseComputeBudget(accounts []string, data []byte) (*solana.ParsedInstructionInfo, error) {
+	r := newReader(data)
+	idx, err := r.u8()
+	if err != nil {
+		return nil, err
+	}
+
+	info := map[string]interface{}{}
+	instType := ""
+
+	switch idx {
+	case 0:
+		instType = "requestUnits"
+		units, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		additionalFee, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		info["units"] = units
+		info["additionalFee"] = additionalFee
+	case 1:
+		instType = "requestHeapFrame"
+		bytes, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		info["bytes"] = bytes
+	case 2:
+		instType = "setComputeUnitLimit"
+		units, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		info["units"] = units
+	case 3:
+		instType = "setComputeUnitPrice"
+		microLamports, err := r.u64()
+		if err != nil {
+			return nil, err
+		}
+		info["microLamports"] = microLamports
+	case 4:
+		instType = "setLoadedAccountsDataSizeLimit"
+		bytes, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		info["bytes"] = bytes
+	default:
+		return nil, fmt.Errorf("Unknown instruction: %v", idx)
+	}
+
+	return &solana.ParsedInstructionInfo{
+		Type: instType,
+		Info: info,
+	}, nil
+}
@@ -0,0 +1,156 @@
+package programs
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/mr-tron/base58"
+	"github.com/subquery/solana-takoyaki/solana"
+)
+
+/**
+ * Parsers for builtin programs with fixed instruction layouts.
+ * The output matches the `parsed` instructions of the Solana RPC `jsonParsed` encoding.
+ * Reference https://github.com/anza-xyz/agave/tree/master/transaction-status/src
+ * */
+
+const (
+	SYSTEM_PROGRAM_ID           = "11111111111111111111111111111111"
+	TOKEN_PROGRAM_ID            = "TokenkegQfeZyiNwAJbNbGKPFXCWuBvf9Ss623VQ5DA"
+	TOKEN_2022_PROGRAM_ID       = "TokenzQdBNbLqP5VEhdkAS6EPFLC1PHnBqCXEpPxuEb"
+	ASSOCIATED_TOKEN_PROGRAM_ID = "ATokenGPvbdGVxr1b2hvZbsiqW5xWH25efTNsLJA8knL"
+	COMPUTE_BUDGET_PROGRAM_ID   = "ComputeBudget111111111111111111111111111111"
+)
+
+type parser func(accounts []string, data []byte) (*solana.ParsedInstructionInfo, error)
+
+type program struct {
+	name  string
+	parse parser
+}
+
+var builtinPrograms = map[string]program{
+	SYSTEM_PROGRAM_ID:           {"system", parseSystem},
+	TOKEN_PROGRAM_ID:            {"spl-token", parseToken},
+	TOKEN_2022_PROGRAM_ID:       {"spl-token-2022", parseToken},
+	ASSOCIATED_TOKEN_PROGRAM_ID: {"spl-associated-token-account", parseAssociatedToken},
+	COMPUTE_BUDGET_PROGRAM_ID:   {"compute-budget", parseComputeBudget},
+}
+
+// IsSupported returns whether the program has a builtin parser
+func IsSupported(programId string) bool {
+	_, ok := builtinPrograms[programId]
+	return ok
+}
+
+// Parse parses an instruction for a builtin program.
+// The accounts are the resolved account keys of the instruction.
+// An empty program name with no error is returned if the program is not supported
+func Parse(programId string, accounts []string, data []byte) (string, *solana.ParsedInstructionInfo, error) {
+	p, ok := builtinPrograms[programId]
+	if !ok {
+		return "", nil, nil
+	}
+
+	parsed, err := p.parse(accounts, data)
+	if err != nil {
+		return "", nil, fmt.Errorf("Unable to parse %v instruction: %w", p.name, err)
+	}
+
+	return p.name, parsed, nil
+}
+
+// reader reads little endian values from instruction data
+type reader struct {
+	data []byte
+	pos  int
+}
+
+func newReader(data []byte) *reader {
+	return &reader{data: data}
+}
+
+func (r *reader) read(n int) ([]byte, error) {
+	if n < 0 || r.pos+n > len(r.data) {
+		return nil, fmt.Errorf("Unexpected end of data, wanted %v bytes at offset %v, have %v", n, r.pos, len(r.data))
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *reader) remaining() []byte {
+	return r.data[r.pos:]
+}
+
+func (r *reader) u8() (uint8, error) {
+	b, err := r.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (r *reader) u32() (uint32, error) {
+	b, err := r.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (r *reader) u64() (uint64, error) {
+	b, err := r.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+func (r *reader) pubkey() (string, error) {
+	b, err := r.read(32)
+	if err != nil {
+		return "", err
+	}
+	return base58.Encode(b), nil
+}
+
+// bincodeString reads a u64 length prefixed string as used by the system program
+func (r *reader) bincodeString() (string, error) {
+	l, err := r.u64()
+	if err != nil {
+		return "", err
+	}
+	if l > uint64(len(r.data)) {
+		return "", fmt.Errorf("String length %v exceeds data length", l)
+	}
+	b, err := r.read(int(l))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// optionPubkey reads an optional pubkey with a 1 byte tag as used by the token program instructions
+func (r *reader) optionPubkey() (*string, error) {
+	tag, err := r.u8()
+	if err != nil {
+		return nil, err
+	}
+	if tag == 0 {
+		return nil, nil
+	}
+	key, err := r.pubkey()
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// checkAccounts ensures the instruction has at least the number of accounts required
+func checkAccounts(accounts []string, n int) error {
+	if len(accounts) < n {
+		return fmt.Errorf("Not enough accounts, expected at least %v, got %v", n, len(accounts))
+	}
+	return nil
+}
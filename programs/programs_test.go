@@ -0,0 +1,123 @@
+package programs
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+)
+
+const (
+	ACCOUNT_A = "So11111111111111111111111111111111111111112"
+	ACCOUNT_B = "EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v"
+	ACCOUNT_C = "675kPX9MHTjS2zt1qfr1NYHuzeLXfQM9H24wFSUt1Mp8"
+	ACCOUNT_D = "JUP6LkbZbjS1jKKwapdHNy74zcZ3tLUZoi5QNyVTaV4"
+)
+
+func compareAsJson(t *testing.T, expected string, got interface{}, errorPrefix string) {
+	raw, _ := json.Marshal(got)
+	if string(raw) != expected {
+		t.Errorf("%s Mismatch\nexpected: %v\ngot: %v", errorPrefix, expected, string(raw))
+	}
+}
+
+func TestParseSystemTransfer(t *testing.T) {
+	data := binary.LittleEndian.AppendUint32(nil, 2)
+	data = binary.LittleEndian.AppendUint64(data, 5_000)
+
+	program, parsed, err := Parse(SYSTEM_PROGRAM_ID, []string{ACCOUNT_A, ACCOUNT_B}, data)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if program != "system" {
+		t.Errorf("Expected program system, got %v", program)
+	}
+
+	compareAsJson(t, `{"type":"transfer","info":{"destination":"EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v","lamports":5000,"source":"So11111111111111111111111111111111111111112"}}`, parsed, "System transfer")
+}
+
+func TestParseTokenTransferChecked(t *testing.T) {
+	data := []byte{12}
+	data = binary.LittleEndian.AppendUint64(data, 1_500_000)
+	data = append(data, 6)
+
+	program, parsed, err := Parse(TOKEN_PROGRAM_ID, []string{ACCOUNT_A, ACCOUNT_B, ACCOUNT_C, ACCOUNT_D}, data)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if program != "spl-token" {
+		t.Errorf("Expected program spl-token, got %v", program)
+	}
+
+	compareAsJson(t, `{"type":"transferChecked","info":{"authority":"JUP6LkbZbjS1jKKwapdHNy74zcZ3tLUZoi5QNyVTaV4","destination":"675kPX9MHTjS2zt1qfr1NYHuzeLXfQM9H24wFSUt1Mp8","mint":"EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v","source":"So11111111111111111111111111111111111111112","tokenAmount":{"amount":"1500000","decimals":6,"uiAmount":1.5,"uiAmountString":"1.5"}}}`, parsed, "Token transferChecked")
+}
+
+func TestParseTokenMultisig(t *testing.T) {
+	data := []byte{3}
+	data = binary.LittleEndian.AppendUint64(data, 10)
+
+	_, parsed, err := Parse(TOKEN_2022_PROGRAM_ID, []string{ACCOUNT_A, ACCOUNT_B, ACCOUNT_C, ACCOUNT_D}, data)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	compareAsJson(t, `{"type":"transfer","info":{"amount":"10","destination":"EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v","multisigAuthority":"675kPX9MHTjS2zt1qfr1NYHuzeLXfQM9H24wFSUt1Mp8","signers":["JUP6LkbZbjS1jKKwapdHNy74zcZ3tLUZoi5QNyVTaV4"],"source":"So11111111111111111111111111111111111111112"}}`, parsed, "Token multisig transfer")
+}
+
+func TestParseAssociatedTokenCreate(t *testing.T) {
+	accounts := []string{ACCOUNT_A, ACCOUNT_B, ACCOUNT_C, ACCOUNT_D, SYSTEM_PROGRAM_ID, TOKEN_PROGRAM_ID}
+
+	_, parsed, err := Parse(ASSOCIATED_TOKEN_PROGRAM_ID, accounts, []byte{1})
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if parsed.Type != "createIdempotent" {
+		t.Errorf("Expected createIdempotent, got %v", parsed.Type)
+	}
+	if parsed.Info["tokenProgram"] != TOKEN_PROGRAM_ID {
+		t.Errorf("Expected token program %v, got %v", TOKEN_PROGRAM_ID, parsed.Info["tokenProgram"])
+	}
+}
+
+func TestParseComputeBudget(t *testing.T) {
+	data := []byte{3}
+	data = binary.LittleEndian.AppendUint64(data, 100_000)
+
+	_, parsed, err := Parse(COMPUTE_BUDGET_PROGRAM_ID, []string{}, data)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	compareAsJson(t, `{"type":"setComputeUnitPrice","info":{"microLamports":100000}}`, parsed, "Compute unit price")
+}
+
+func TestParseUnsupported(t *testing.T) {
+	program, parsed, err := Parse(ACCOUNT_C, []string{}, []byte{1})
+	if program != "" || parsed != nil || err != nil {
+		t.Errorf("Expected unsupported program to be ignored, got %v %v %v", program, parsed, err)
+	}
+
+	_, _, err = Parse(TOKEN_PROGRAM_ID, []string{ACCOUNT_A}, []byte{3, 1})
+	if err == nil {
+		t.Error("Expected error for malformed instruction")
+	}
+}
+
+func TestUiTokenAmount(t *testing.T) {
+	tests := []struct {
+		amount   uint64
+		decimals uint8
+		expected string
+	}{
+		{0, 6, "0"},
+		{1, 9, "0.000000001"},
+		{1_000_000, 6, "1"},
+		{123, 0, "123"},
+	}
+
+	for _, test := range tests {
+		res := uiTokenAmount(test.amount, test.decimals)
+		if res.UiAmountString != test.expected {
+			t.Errorf("Expected %v, got %v", test.expected, res.UiAmountString)
+		}
+	}
+}
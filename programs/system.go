@@ -0,0 +1,242 @@
+package programs
+
+import (
+	"fmt"
+
+	"github.com/subquery/solana-takoyaki/solana"
+)
+
+// System program instructions are bincode encoded with a u32 instruction index
+// https://github.com/anza-xyz/agave/blob/master/transaction-status/src/parse_system.rs
+func parseSystem(accounts []string, data []byte) (*solana.ParsedInstructionInfo, error) {
+	r := newReader(data)
+	idx, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+
+	info := map[string]interface{}{}
+	instType := ""
+
+	switch idx {
+	case 0:
+		instType = "createAccount"
+		if err := checkAccounts(accounts, 2); err != nil {
+			return nil, err
+		}
+		lamports, space, owner, err := readLamportsSpaceOwner(r)
+		if err != nil {
+			return nil, err
+		}
+		info["source"] = accounts[0]
+		info["newAccount"] = accounts[1]
+		info["lamports"] = lamports
+		info["space"] = space
+		info["owner"] = owner
+	case 1:
+		instType = "assign"
+		if err := checkAccounts(accounts, 1); err != nil {
+			return nil, err
+		}
+		owner, err := r.pubkey()
+		if err != nil {
+			return nil, err
+		}
+		info["account"] = accounts[0]
+		info["owner"] = owner
+	case 2:
+		instType = "transfer"
+		if err := checkAccounts(accounts, 2); err != nil {
+			return nil, err
+		}
+		lamports, err := r.u64()
+		if err != nil {
+			return nil, err
+		}
+		info["source"] = accounts[0]
+		info["destination"] = accounts[1]
+		info["lamports"] = lamports
+	case 3:
+		instType = "createAccountWithSeed"
+		if err := checkAccounts(accounts, 2); err != nil {
+			return nil, err
+		}
+		base, err := r.pubkey()
+		if err != nil {
+			return nil, err
+		}
+		seed, err := r.bincodeString()
+		if err != nil {
+			return nil, err
+		}
+		lamports, space, owner, err := readLamportsSpaceOwner(r)
+		if err != nil {
+			return nil, err
+		}
+		info["source"] = accounts[0]
+		info["newAccount"] = accounts[1]
+		info["base"] = base
+		info["seed"] = seed
+		info["lamports"] = lamports
+		info["space"] = space
+		info["owner"] = owner
+	case 4:
+		instType = "advanceNonce"
+		if err := checkAccounts(accounts, 3); err != nil {
+			return nil, err
+		}
+		info["nonceAccount"] = accounts[0]
+		info["recentBlockhashesSysvar"] = accounts[1]
+		info["nonceAuthority"] = accounts[2]
+	case 5:
+		instType = "withdrawFromNonce"
+		if err := checkAccounts(accounts, 5); err != nil {
+			return nil, err
+		}
+		lamports, err := r.u64()
+		if err != nil {
+			return nil, err
+		}
+		info["nonceAccount"] = accounts[0]
+		info["destination"] = accounts[1]
+		info["recentBlockhashesSysvar"] = accounts[2]
+		info["rentSysvar"] = accounts[3]
+		info["nonceAuthority"] = accounts[4]
+		info["lamports"] = lamports
+	case 6:
+		instType = "initializeNonce"
+		if err := checkAccounts(accounts, 3); err != nil {
+			return nil, err
+		}
+		authority, err := r.pubkey()
+		if err != nil {
+			return nil, err
+		}
+		info["nonceAccount"] = accounts[0]
+		info["recentBlockhashesSysvar"] = accounts[1]
+		info["rentSysvar"] = accounts[2]
+		info["nonceAuthority"] = authority
+	case 7:
+		instType = "authorizeNonce"
+		if err := checkAccounts(accounts, 2); err != nil {
+			return nil, err
+		}
+		authority, err := r.pubkey()
+		if err != nil {
+			return nil, err
+		}
+		info["nonceAccount"] = accounts[0]
+		info["nonceAuthority"] = accounts[1]
+		info["newAuthorized"] = authority
+	case 8:
+		instType = "allocate"
+		if err := checkAccounts(accounts, 1); err != nil {
+			return nil, err
+		}
+		space, err := r.u64()
+		if err != nil {
+			return nil, err
+		}
+		info["account"] = accounts[0]
+		info["space"] = space
+	case 9:
+		instType = "allocateWithSeed"
+		if err := checkAccounts(accounts, 2); err != nil {
+			return nil, err
+		}
+		base, err := r.pubkey()
+		if err != nil {
+			return nil, err
+		}
+		seed, err := r.bincodeString()
+		if err != nil {
+			return nil, err
+		}
+		space, err := r.u64()
+		if err != nil {
+			return nil, err
+		}
+		owner, err := r.pubkey()
+		if err != nil {
+			return nil, err
+		}
+		info["account"] = accounts[0]
+		info["base"] = base
+		info["seed"] = seed
+		info["space"] = space
+		info["owner"] = owner
+	case 10:
+		instType = "assignWithSeed"
+		if err := checkAccounts(accounts, 2); err != nil {
+			return nil, err
+		}
+		base, err := r.pubkey()
+		if err != nil {
+			return nil, err
+		}
+		seed, err := r.bincodeString()
+		if err != nil {
+			return nil, err
+		}
+		owner, err := r.pubkey()
+		if err != nil {
+			return nil, err
+		}
+		info["account"] = accounts[0]
+		info["base"] = base
+		info["seed"] = seed
+		info["owner"] = owner
+	case 11:
+		instType = "transferWithSeed"
+		if err := checkAccounts(accounts, 3); err != nil {
+			return nil, err
+		}
+		lamports, err := r.u64()
+		if err != nil {
+			return nil, err
+		}
+		seed, err := r.bincodeString()
+		if err != nil {
+			return nil, err
+		}
+		owner, err := r.pubkey()
+		if err != nil {
+			return nil, err
+		}
+		info["source"] = accounts[0]
+		info["sourceBase"] = accounts[1]
+		info["destination"] = accounts[2]
+		info["lamports"] = lamports
+		info["sourceSeed"] = seed
+		info["sourceOwner"] = owner
+	case 12:
+		instType = "upgradeNonce"
+		if err := checkAccounts(accounts, 1); err != nil {
+			return nil, err
+		}
+		info["nonceAccount"] = accounts[0]
+	default:
+		return nil, fmt.Errorf("Unknown instruction: %v", idx)
+	}
+
+	return &solana.ParsedInstructionInfo{
+		Type: instType,
+		Info: info,
+	}, nil
+}
+
+func readLamportsSpaceOwner(r *reader) (lamports, space uint64, owner string, err error) {
+	lamports, err = r.u64()
+	if err != nil {
+		return 0, 0, "", err
+	}
+	space, err = r.u64()
+	if err != nil {
+		return 0, 0, "", err
+	}
+	owner, err = r.pubkey()
+	if err != nil {
+		return 0, 0, "", err
+	}
+	return lamports, space, owner, nil
+}
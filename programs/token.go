@@ -0,0 +1,378 @@
+package programs
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/subquery/solana-takoyaki/solana"
+)
+
+var authorityTypes = []string{
+	"mintTokens",
+	"freezeAccount",
+	"accountOwner",
+	"closeAccount",
+	// Token-2022 only
+	"transferFeeConfig",
+	"withheldWithdraw",
+	"closeMint",
+	"interestRate",
+	"permanentDelegate",
+	"confidentialTransferMint",
+	"transferHookProgramId",
+	"confidentialTransferFeeConfig",
+	"metadataPointer",
+	"groupPointer",
+	"groupMemberPointer",
+	"scaledUiAmount",
+	"pause",
+}
+
+// Token and Token-2022 instructions share the same layout for the base instructions
+// https://github.com/anza-xyz/agave/blob/master/transaction-status/src/parse_token.rs
+func parseToken(accounts []string, data []byte) (*solana.ParsedInstructionInfo, error) {
+	r := newReader(data)
+	idx, err := r.u8()
+	if err != nil {
+		return nil, err
+	}
+
+	info := map[string]interface{}{}
+	instType := ""
+
+	switch idx {
+	case 0, 20:
+		instType = "initializeMint"
+		if idx == 20 {
+			instType = "initializeMint2"
+		}
+		if err := checkAccounts(accounts, 1); err != nil {
+			return nil, err
+		}
+		decimals, err := r.u8()
+		if err != nil {
+			return nil, err
+		}
+		mintAuthority, err := r.pubkey()
+		if err != nil {
+			return nil, err
+		}
+		freezeAuthority, err := r.optionPubkey()
+		if err != nil {
+			return nil, err
+		}
+		info["mint"] = accounts[0]
+		info["decimals"] = decimals
+		info["mintAuthority"] = mintAuthority
+		if freezeAuthority != nil {
+			info["freezeAuthority"] = *freezeAuthority
+		}
+		if idx == 0 {
+			if err := checkAccounts(accounts, 2); err != nil {
+				return nil, err
+			}
+			info["rentSysvar"] = accounts[1]
+		}
+	case 1:
+		instType = "initializeAccount"
+		if err := checkAccounts(accounts, 4); err != nil {
+			return nil, err
+		}
+		info["account"] = accounts[0]
+		info["mint"] = accounts[1]
+		info["owner"] = accounts[2]
+		info["rentSysvar"] = accounts[3]
+	case 2, 19:
+		instType = "initializeMultisig"
+		signersOffset := 2
+		if idx == 19 {
+			instType = "initializeMultisig2"
+			signersOffset = 1
+		}
+		if err := checkAccounts(accounts, signersOffset); err != nil {
+			return nil, err
+		}
+		m, err := r.u8()
+		if err != nil {
+			return nil, err
+		}
+		info["multisig"] = accounts[0]
+		if idx == 2 {
+			info["rentSysvar"] = accounts[1]
+		}
+		info["signers"] = accounts[signersOffset:]
+		info["m"] = m
+	case 3:
+		instType = "transfer"
+		if err := checkAccounts(accounts, 3); err != nil {
+			return nil, err
+		}
+		amount, err := r.u64()
+		if err != nil {
+			return nil, err
+		}
+		info["source"] = accounts[0]
+		info["destination"] = accounts[1]
+		info["amount"] = strconv.FormatUint(amount, 10)
+		parseSigners(info, accounts, 2, "authority", "multisigAuthority")
+	case 4:
+		instType = "approve"
+		if err := checkAccounts(accounts, 3); err != nil {
+			return nil, err
+		}
+		amount, err := r.u64()
+		if err != nil {
+			return nil, err
+		}
+		info["source"] = accounts[0]
+		info["delegate"] = accounts[1]
+		info["amount"] = strconv.FormatUint(amount, 10)
+		parseSigners(info, accounts, 2, "owner", "multisigOwner")
+	case 5:
+		instType = "revoke"
+		if err := checkAccounts(accounts, 2); err != nil {
+			return nil, err
+		}
+		info["source"] = accounts[0]
+		parseSigners(info, accounts, 1, "owner", "multisigOwner")
+	case 6:
+		instType = "setAuthority"
+		if err := checkAccounts(accounts, 2); err != nil {
+			return nil, err
+		}
+		authorityType, err := r.u8()
+		if err != nil {
+			return nil, err
+		}
+		if int(authorityType) >= len(authorityTypes) {
+			return nil, fmt.Errorf("Unknown authority type: %v", authorityType)
+		}
+		newAuthority, err := r.optionPubkey()
+		if err != nil {
+			return nil, err
+		}
+		owned := "mint"
+		if authorityTypes[authorityType] == "accountOwner" || authorityTypes[authorityType] == "closeAccount" {
+			owned = "account"
+		}
+		info[owned] = accounts[0]
+		info["authorityType"] = authorityTypes[authorityType]
+		info["newAuthority"] = newAuthority
+		parseSigners(info, accounts, 1, "authority", "multisigAuthority")
+	case 7, 14:
+		instType = "mintTo"
+		if idx == 14 {
+			instType = "mintToChecked"
+		}
+		if err := checkAccounts(accounts, 3); err != nil {
+			return nil, err
+		}
+		info["mint"] = accounts[0]
+		info["account"] = accounts[1]
+		if err := readAmount(r, info, idx == 14); err != nil {
+			return nil, err
+		}
+		parseSigners(info, accounts, 2, "mintAuthority", "multisigMintAuthority")
+	case 8, 15:
+		instType = "burn"
+		if idx == 15 {
+			instType = "burnChecked"
+		}
+		if err := checkAccounts(accounts, 3); err != nil {
+			return nil, err
+		}
+		info["account"] = accounts[0]
+		info["mint"] = accounts[1]
+		if err := readAmount(r, info, idx == 15); err != nil {
+			return nil, err
+		}
+		parseSigners(info, accounts, 2, "authority", "multisigAuthority")
+	case 9:
+		instType = "closeAccount"
+		if err := checkAccounts(accounts, 3); err != nil {
+			return nil, err
+		}
+		info["account"] = accounts[0]
+		info["destination"] = accounts[1]
+		parseSigners(info, accounts, 2, "owner", "multisigOwner")
+	case 10, 11:
+		instType = "freezeAccount"
+		if idx == 11 {
+			instType = "thawAccount"
+		}
+		if err := checkAccounts(accounts, 3); err != nil {
+			return nil, err
+		}
+		info["account"] = accounts[0]
+		info["mint"] = accounts[1]
+		parseSigners(info, accounts, 2, "freezeAuthority", "multisigFreezeAuthority")
+	case 12:
+		instType = "transferChecked"
+		if err := checkAccounts(accounts, 4); err != nil {
+			return nil, err
+		}
+		info["source"] = accounts[0]
+		info["mint"] = accounts[1]
+		info["destination"] = accounts[2]
+		if err := readAmount(r, info, true); err != nil {
+			return nil, err
+		}
+		parseSigners(info, accounts, 3, "authority", "multisigAuthority")
+	case 13:
+		instType = "approveChecked"
+		if err := checkAccounts(accounts, 4); err != nil {
+			return nil, err
+		}
+		info["source"] = accounts[0]
+		info["mint"] = accounts[1]
+		info["delegate"] = accounts[2]
+		if err := readAmount(r, info, true); err != nil {
+			return nil, err
+		}
+		parseSigners(info, accounts, 3, "owner", "multisigOwner")
+	case 16, 18:
+		instType = "initializeAccount2"
+		if idx == 18 {
+			instType = "initializeAccount3"
+		}
+		if err := checkAccounts(accounts, 2); err != nil {
+			return nil, err
+		}
+		owner, err := r.pubkey()
+		if err != nil {
+			return nil, err
+		}
+		info["account"] = accounts[0]
+		info["mint"] = accounts[1]
+		info["owner"] = owner
+		if idx == 16 {
+			if err := checkAccounts(accounts, 3); err != nil {
+				return nil, err
+			}
+			info["rentSysvar"] = accounts[2]
+		}
+	case 17:
+		instType = "syncNative"
+		if err := checkAccounts(accounts, 1); err != nil {
+			return nil, err
+		}
+		info["account"] = accounts[0]
+	case 21:
+		instType = "getAccountDataSize"
+		if err := checkAccounts(accounts, 1); err != nil {
+			return nil, err
+		}
+		info["mint"] = accounts[0]
+	case 22:
+		instType = "initializeImmutableOwner"
+		if err := checkAccounts(accounts, 1); err != nil {
+			return nil, err
+		}
+		info["account"] = accounts[0]
+	case 23:
+		instType = "amountToUiAmount"
+		if err := checkAccounts(accounts, 1); err != nil {
+			return nil, err
+		}
+		amount, err := r.u64()
+		if err != nil {
+			return nil, err
+		}
+		info["mint"] = accounts[0]
+		info["amount"] = strconv.FormatUint(amount, 10)
+	case 24:
+		instType = "uiAmountToAmount"
+		if err := checkAccounts(accounts, 1); err != nil {
+			return nil, err
+		}
+		info["mint"] = accounts[0]
+		info["uiAmount"] = string(r.remaining())
+	// Token-2022 extension instructions
+	case 25:
+		instType = "initializeMintCloseAuthority"
+		if err := checkAccounts(accounts, 1); err != nil {
+			return nil, err
+		}
+		authority, err := r.optionPubkey()
+		if err != nil {
+			return nil, err
+		}
+		info["mint"] = accounts[0]
+		info["newAuthority"] = authority
+	case 31:
+		instType = "createNativeMint"
+		if err := checkAccounts(accounts, 3); err != nil {
+			return nil, err
+		}
+		info["payer"] = accounts[0]
+		info["nativeMint"] = accounts[1]
+		info["systemProgram"] = accounts[2]
+	case 32:
+		instType = "initializeNonTransferableMint"
+		if err := checkAccounts(accounts, 1); err != nil {
+			return nil, err
+		}
+		info["mint"] = accounts[0]
+	case 35:
+		instType = "initializePermanentDelegate"
+		if err := checkAccounts(accounts, 1); err != nil {
+			return nil, err
+		}
+		delegate, err := r.pubkey()
+		if err != nil {
+			return nil, err
+		}
+		info["mint"] = accounts[0]
+		info["delegate"] = delegate
+	default:
+		return nil, fmt.Errorf("Unsupported instruction: %v", idx)
+	}
+
+	return &solana.ParsedInstructionInfo{
+		Type: instType,
+		Info: info,
+	}, nil
+}
+
+// parseSigners sets the authority of an instruction.
+// If there are more accounts than the authority it is a multisig and the remaining accounts are the signers
+func parseSigners(info map[string]interface{}, accounts []string, authorityIdx int, ownerField, multisigField string) {
+	if len(accounts) > authorityIdx+1 {
+		info[multisigField] = accounts[authorityIdx]
+		info["signers"] = accounts[authorityIdx+1:]
+	} else {
+		info[ownerField] = accounts[authorityIdx]
+	}
+}
+
+// readAmount reads the amount, and decimals for checked instructions, in the format used by the RPC
+func readAmount(r *reader, info map[string]interface{}, checked bool) error {
+	amount, err := r.u64()
+	if err != nil {
+		return err
+	}
+	if !checked {
+		info["amount"] = strconv.FormatUint(amount, 10)
+		return nil
+	}
+
+	decimals, err := r.u8()
+	if err != nil {
+		return err
+	}
+	info["tokenAmount"] = uiTokenAmount(amount, decimals)
+	return nil
+}
+
+func uiTokenAmount(amount uint64, decimals uint8) *solana.UiTokenAmount {
+	raw := strconv.FormatUint(amount, 10)
+	uiAmountString := solana.ShiftDecimals(raw, decimals)
+	uiAmount, _ := strconv.ParseFloat(uiAmountString, 64)
+
+	return &solana.UiTokenAmount{
+		Amount:         raw,
+		Decimals:       decimals,
+		UiAmount:       &uiAmount,
+		UiAmountString: uiAmountString,
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+const API_KEY_HEADER = "X-API-Key"
+
+// The path of the NDJSON stream endpoint, the API key can also be passed in the path as `/stream/<apiKey>`
+const STREAM_PATH = "/stream"
+
+type apiKeyContextKey struct{}
+
+// APIKey adds the API key from the X-API-Key header, apiKey query param or URL path (`/<apiKey>` or `/stream/<apiKey>`) to the request context
+func APIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(API_KEY_HEADER)
+		if key == "" {
+			key = r.URL.Query().Get("apiKey")
+		}
+		if key == "" {
+			key = pathAPIKey(r.URL.Path)
+		}
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+	})
+}
+
+// pathAPIKey returns the key of a `/<apiKey>` or `/stream/<apiKey>` path, other paths don't have a key
+func pathAPIKey(path string) string {
+	if path == STREAM_PATH {
+		return ""
+	}
+	path = strings.TrimPrefix(path, STREAM_PATH+"/")
+	path = strings.Trim(path, "/")
+	if strings.Contains(path, "/") {
+		return ""
+	}
+	return path
+}
+
+// APIKeyFromContext returns the API key of the request or an empty string if there is none
+func APIKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(apiKeyContextKey{}).(string)
+	return key
+}
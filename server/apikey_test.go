@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIKey(t *testing.T) {
+	var key string
+	handler := APIKey(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key = APIKeyFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set(API_KEY_HEADER, "header-key")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if key != "header-key" {
+		t.Errorf("Expected header key, got %q", key)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/?apiKey=query-key", nil))
+	if key != "query-key" {
+		t.Errorf("Expected query key, got %q", key)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+	if key != "" {
+		t.Errorf("Expected no key, got %q", key)
+	}
+}
+
+func TestPathAPIKey(t *testing.T) {
+	var key string
+	handler := APIKey(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key = APIKeyFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/path-key", "path-key"},
+		{STREAM_PATH, ""},
+		{STREAM_PATH + "/", ""},
+		{STREAM_PATH + "/stream-key", "stream-key"},
+		{"/nested/path", ""},
+	}
+
+	for _, test := range tests {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", test.path, nil))
+		if key != test.expected {
+			t.Errorf("%v: expected key %q, got %q", test.path, test.expected, key)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// How often usage per API key is logged
+const USAGE_LOG_INTERVAL = time.Minute
+
+// The number of anonymous clients tracked before they are reset
+const MAX_ANONYMOUS_CLIENTS = 10_000
+
+type APIKeyConfig struct {
+	Key  string `json:"key"`
+	Name string `json:"name"` // Used in logs instead of the key
+	// Requests per second, 0 uses the default rate limit
+	RateLimit float64 `json:"rateLimit"`
+	Burst     int     `json:"burst"`
+	// Blocks served per quota window, 0 uses the default quota
+	QuotaBlocks uint64 `json:"quotaBlocks"`
+}
+
+// LoadAPIKeys reads a JSON array of API key configs
+func LoadAPIKeys(path string) ([]APIKeyConfig, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []APIKeyConfig{}
+	if err := json.Unmarshal(raw, &keys); err != nil {
+		return nil, fmt.Errorf("Failed to parse API keys: %w", err)
+	}
+
+	for i, key := range keys {
+		if key.Key == "" {
+			return nil, fmt.Errorf("API key %d is empty", i)
+		}
+		if key.Name == "" {
+			keys[i].Name = fmt.Sprintf("key-%d", i)
+		}
+	}
+
+	return keys, nil
+}
+
+type client struct {
+	name     string
+	limiter  *rate.Limiter
+	requests atomic.Uint64
+	limited  atomic.Uint64
+}
+
+// Auth authenticates requests by API key and rate limits each client.
+// If no keys are configured all requests are allowed and clients are rate limited by address
+type Auth struct {
+	keys map[string]*client
+
+	mu           sync.Mutex
+	anonymous    map[string]*client
+	defaultRate  float64
+	defaultBurst int
+}
+
+// NewAuth creates an Auth with the API keys, defaultRate is the requests per second for keys without a limit and anonymous clients, 0 is unlimited
+func NewAuth(keys []APIKeyConfig, defaultRate float64, defaultBurst int) *Auth {
+	a := &Auth{
+		keys:         map[string]*client{},
+		anonymous:    map[string]*client{},
+		defaultRate:  defaultRate,
+		defaultBurst: defaultBurst,
+	}
+
+	for _, key := range keys {
+		a.keys[key.Key] = a.newClient(key.Name, key.RateLimit, key.Burst)
+	}
+
+	return a
+}
+
+func (a *Auth) newClient(name string, rateLimit float64, burst int) *client {
+	// Keys with their own rate limit but no burst default to a burst of the rate limit rather than the default burst
+	if rateLimit == 0 {
+		rateLimit = a.defaultRate
+		if burst == 0 {
+			burst = a.defaultBurst
+		}
+	}
+
+	limit := rate.Limit(rateLimit)
+	if rateLimit == 0 {
+		limit = rate.Inf
+	}
+	if burst == 0 {
+		burst = max(1, int(math.Ceil(rateLimit)))
+	}
+
+	return &client{name: name, limiter: rate.NewLimiter(limit, burst)}
+}
+
+func (a *Auth) client(r *http.Request) *client {
+	if len(a.keys) > 0 {
+		return a.keys[APIKeyFromContext(r.Context())]
+	}
+
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	c, ok := a.anonymous[addr]
+	if !ok {
+		if len(a.anonymous) >= MAX_ANONYMOUS_CLIENTS {
+			a.anonymous = map[string]*client{}
+		}
+		c = a.newClient(addr, 0, 0)
+		a.anonymous[addr] = c
+	}
+	return c
+}
+
+// Handler rejects requests with an unknown API key and requests over the client rate limit.
+// It must be wrapped by APIKey so the key is available
+func (a *Auth) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := a.client(r)
+		if c == nil {
+			http.Error(w, "Invalid or missing API key", http.StatusUnauthorized)
+			return
+		}
+
+		c.requests.Add(1)
+
+		reservation := c.limiter.Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			reservation.Cancel()
+			c.limited.Add(1)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LogUsage logs the requests made by each API key every USAGE_LOG_INTERVAL until done is closed
+func (a *Auth) LogUsage(done <-chan struct{}) {
+	ticker := time.NewTicker(USAGE_LOG_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		for _, c := range a.keys {
+			requests := c.requests.Swap(0)
+			limited := c.limited.Swap(0)
+			if requests > 0 {
+				slog.Info("API key usage", "name", c.name, "requests", requests, "rateLimited", limited, "interval", USAGE_LOG_INTERVAL)
+			}
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func authRequest(handler http.Handler, path string, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, nil)
+	if key != "" {
+		req.Header.Set(API_KEY_HEADER, key)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAuth(t *testing.T) {
+	auth := NewAuth([]APIKeyConfig{
+		{Key: "team-a-key", Name: "team-a", RateLimit: 1, Burst: 2},
+		{Key: "team-b-key", Name: "team-b"},
+	}, 0, 0)
+	handler := APIKey(auth.Handler(okHandler))
+
+	if rec := authRequest(handler, "/", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected missing key to be unauthorized, got %v", rec.Code)
+	}
+	if rec := authRequest(handler, "/", "unknown"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected unknown key to be unauthorized, got %v", rec.Code)
+	}
+	if rec := authRequest(handler, "/team-b-key", ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected path key to be authorized, got %v", rec.Code)
+	}
+
+	// Burst of 2 then limited
+	for i := 0; i < 2; i++ {
+		if rec := authRequest(handler, "/", "team-a-key"); rec.Code != http.StatusOK {
+			t.Fatalf("Expected request %d to be allowed, got %v", i, rec.Code)
+		}
+	}
+	rec := authRequest(handler, "/", "team-a-key")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected rate limit, got %v", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected Retry-After header")
+	}
+
+	// Keys without a rate limit use the default, which is unlimited
+	for i := 0; i < 10; i++ {
+		if rec := authRequest(handler, "/", "team-b-key"); rec.Code != http.StatusOK {
+			t.Fatalf("Expected unlimited key to be allowed, got %v", rec.Code)
+		}
+	}
+}
+
+func TestAuthAnonymous(t *testing.T) {
+	handler := APIKey(NewAuth(nil, 1, 1).Handler(okHandler))
+
+	if rec := authRequest(handler, "/", ""); rec.Code != http.StatusOK {
+		t.Fatalf("Expected anonymous request to be allowed, got %v", rec.Code)
+	}
+	if rec := authRequest(handler, "/", ""); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected anonymous client to be rate limited, got %v", rec.Code)
+	}
+}
+
+// A key with only a burst keeps it with the default rate limit
+func TestAuthKeyBurst(t *testing.T) {
+	auth := NewAuth([]APIKeyConfig{{Key: "team-a-key", Burst: 3}}, 1, 1)
+	handler := APIKey(auth.Handler(okHandler))
+
+	for i := 0; i < 3; i++ {
+		if rec := authRequest(handler, "/", "team-a-key"); rec.Code != http.StatusOK {
+			t.Fatalf("Expected request %d to be allowed, got %v", i, rec.Code)
+		}
+	}
+	if rec := authRequest(handler, "/", "team-a-key"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected rate limit, got %v", rec.Code)
+	}
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	os.WriteFile(path, []byte(`[{"key":"abc","rateLimit":5,"quotaBlocks":1000},{"key":"def","name":"team-b"}]`), 0o644)
+
+	keys, err := LoadAPIKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].Name != "key-0" || keys[0].QuotaBlocks != 1000 || keys[1].Name != "team-b" {
+		t.Errorf("Unexpected keys %+v", keys)
+	}
+
+	os.WriteFile(path, []byte(`[{"name":"no key"}]`), 0o644)
+	if _, err := LoadAPIKeys(path); err == nil {
+		t.Errorf("Expected empty key to fail")
+	}
+}
+
+func TestAuthStream(t *testing.T) {
+	auth := NewAuth([]APIKeyConfig{{Key: "team-a-key", Name: "team-a"}}, 0, 0)
+	handler := APIKey(auth.Handler(okHandler))
+	mux := http.NewServeMux()
+	mux.Handle(STREAM_PATH, handler)
+	mux.Handle(STREAM_PATH+"/", handler)
+
+	if rec := authRequest(mux, STREAM_PATH, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a stream without a key to be unauthorized, got %v", rec.Code)
+	}
+	if rec := authRequest(mux, STREAM_PATH, "team-a-key"); rec.Code != http.StatusOK {
+		t.Errorf("Expected a stream with a header key to be authorized, got %v", rec.Code)
+	}
+	if rec := authRequest(mux, STREAM_PATH+"/team-a-key", ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected a stream with a path key to be authorized, got %v", rec.Code)
+	}
+}
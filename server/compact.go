@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+const CONTENT_TYPE_CBOR = "application/cbor"
+
+// CompactEncoding re-encodes JSON-RPC responses as CBOR when requested with `Accept: application/cbor`.
+// Requests are still JSON, only the response encoding changes. Responses are transcoded as they are written so they are never buffered whole
+func CompactEncoding(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsCBOR(r.Header.Get("Accept")) || isWebsocket(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept")
+		cw := &cborResponseWriter{ResponseWriter: w}
+		defer func() {
+			if err := cw.Close(); err != nil {
+				slog.Warn("Failed to transcode response to CBOR", "error", err)
+			}
+		}()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+func acceptsCBOR(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mediaType == CONTENT_TYPE_CBOR {
+			return true
+		}
+	}
+	return false
+}
+
+// cborResponseWriter transcodes successful JSON responses to CBOR, other responses are written as is
+type cborResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	transcoder  *cborTranscoder // nil if the response isn't transcoded
+}
+
+func (w *cborResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if status == http.StatusOK && mediaType == "application/json" {
+		w.Header().Set("Content-Type", CONTENT_TYPE_CBOR)
+		w.Header().Del("Content-Length")
+		w.transcoder = newCBORTranscoder(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cborResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.transcoder == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.transcoder.Write(b)
+}
+
+func (w *cborResponseWriter) Flush() {
+	if w.transcoder != nil {
+		w.transcoder.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *cborResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *cborResponseWriter) Close() error {
+	if w.transcoder == nil {
+		return nil
+	}
+	return w.transcoder.Close()
+}
+
+// cborTranscoder converts JSON to CBOR token by token, objects and arrays are encoded with indefinite lengths so they can be written before they end.
+// Write only returns once every complete token written has been transcoded, so flushing sends everything written so far
+type cborTranscoder struct {
+	out    *bufio.Writer
+	chunks chan []byte
+	ack    chan struct{}
+	done   chan struct{}
+	err    error
+
+	// Only used by the decoder
+	chunk   []byte
+	pending bool
+}
+
+func newCBORTranscoder(w io.Writer) *cborTranscoder {
+	t := &cborTranscoder{
+		out:    bufio.NewWriter(w),
+		chunks: make(chan []byte),
+		ack:    make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(t.done)
+		t.err = t.transcode()
+	}()
+	return t
+}
+
+func (t *cborTranscoder) Write(b []byte) (int, error) {
+	select {
+	case t.chunks <- b:
+	case <-t.done:
+		return 0, t.closedErr()
+	}
+	select {
+	case <-t.ack:
+		return len(b), nil
+	case <-t.done:
+		return 0, t.closedErr()
+	}
+}
+
+func (t *cborTranscoder) Flush() {
+	t.out.Flush()
+}
+
+// Close waits for the remaining tokens to be transcoded and flushes them
+func (t *cborTranscoder) Close() error {
+	close(t.chunks)
+	<-t.done
+	if err := t.out.Flush(); err != nil && t.err == nil {
+		return err
+	}
+	return t.err
+}
+
+func (t *cborTranscoder) closedErr() error {
+	if t.err != nil {
+		return t.err
+	}
+	return io.ErrClosedPipe
+}
+
+// Read provides the written chunks to the decoder, a Write is acknowledged once the decoder needs more input
+func (t *cborTranscoder) Read(p []byte) (int, error) {
+	for len(t.chunk) == 0 {
+		if t.pending {
+			t.ack <- struct{}{}
+			t.pending = false
+		}
+		chunk, ok := <-t.chunks
+		if !ok {
+			return 0, io.EOF
+		}
+		t.chunk, t.pending = chunk, true
+	}
+	n := copy(p, t.chunk)
+	t.chunk = t.chunk[n:]
+	return n, nil
+}
+
+func (t *cborTranscoder) transcode() error {
+	dec := json.NewDecoder(t)
+	dec.UseNumber()
+	enc := cbor.NewEncoder(t.out)
+
+	for {
+		token, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch token := token.(type) {
+		case json.Delim:
+			switch token {
+			case '{':
+				err = enc.StartIndefiniteMap()
+			case '[':
+				err = enc.StartIndefiniteArray()
+			default:
+				err = enc.EndIndefinite()
+			}
+		case json.Number:
+			err = enc.Encode(compactNumber(token))
+		default:
+			err = enc.Encode(token)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// compactNumber converts json numbers to integers where possible so they are encoded compactly
+func compactNumber(n json.Number) interface{} {
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	if u, err := strconv.ParseUint(n.String(), 10, 64); err == nil {
+		return u
+	}
+	if f, err := n.Float64(); err == nil {
+		return f
+	}
+	return n.String()
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	ENCODING_GZIP = "gzip"
+	ENCODING_ZSTD = "zstd"
+)
+
+var gzipPool = sync.Pool{
+	New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return w
+	},
+}
+
+var zstdPool = sync.Pool{
+	New: func() interface{} {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+		return w
+	},
+}
+
+type compressWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+	Flush() error
+}
+
+// Compress compresses responses with zstd or gzip based on the request Accept-Encoding header
+func Compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Websocket connections handle their own compression
+		if isWebsocket(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var cw compressWriter
+		var pool *sync.Pool
+		switch encoding {
+		case ENCODING_ZSTD:
+			pool = &zstdPool
+			cw = pool.Get().(*zstd.Encoder)
+		case ENCODING_GZIP:
+			pool = &gzipPool
+			cw = pool.Get().(*gzip.Writer)
+		}
+		cw.Reset(w)
+		defer func() {
+			cw.Close()
+			cw.Reset(nil)
+			pool.Put(cw)
+		}()
+
+		w.Header().Set("Content-Encoding", encoding)
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		next.ServeHTTP(&compressResponseWriter{ResponseWriter: w, writer: cw}, r)
+	})
+}
+
+type compressResponseWriter struct {
+	http.ResponseWriter
+	writer compressWriter
+}
+
+func (w *compressResponseWriter) WriteHeader(status int) {
+	// The length changes once compressed
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *compressResponseWriter) Write(b []byte) (int, error) {
+	w.Header().Del("Content-Length")
+	return w.writer.Write(b)
+}
+
+func (w *compressResponseWriter) Flush() {
+	w.writer.Flush()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// negotiateEncoding picks the supported encoding with the highest quality, zstd is preferred on ties
+func negotiateEncoding(header string) string {
+	best := ""
+	bestQ := 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != ENCODING_ZSTD && name != ENCODING_GZIP {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		if q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && name == ENCODING_ZSTD) {
+			best = name
+			bestQ = q
+		}
+	}
+	return best
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+const TEST_RESPONSE = `{"jsonrpc":"2.0","id":1,"result":{"blocks":[],"blockRange":[327347682,18446744073709551615],"genesisHash":"mainnet"}}`
+
+var jsonHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(TEST_RESPONSE))
+})
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected string
+	}{
+		{"", ""},
+		{"br", ""},
+		{"gzip", ENCODING_GZIP},
+		{"gzip, zstd", ENCODING_ZSTD},
+		{"gzip;q=1.0, zstd;q=0.5", ENCODING_GZIP},
+		{"zstd;q=0, gzip", ENCODING_GZIP},
+		{"deflate, GZIP", ENCODING_GZIP},
+	}
+
+	for _, test := range tests {
+		if res := negotiateEncoding(test.header); res != test.expected {
+			t.Errorf("Header %q: expected %q, got %q", test.header, test.expected, res)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	handler := Compress(jsonHandler)
+
+	for _, encoding := range []string{ENCODING_GZIP, ENCODING_ZSTD} {
+		req := httptest.NewRequest("POST", "/", nil)
+		req.Header.Set("Accept-Encoding", encoding)
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		if rec.Header().Get("Content-Encoding") != encoding {
+			t.Fatalf("Expected content encoding %v, got %v", encoding, rec.Header().Get("Content-Encoding"))
+		}
+
+		var reader io.Reader
+		switch encoding {
+		case ENCODING_GZIP:
+			gr, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatalf("Failed to create gzip reader: %v", err)
+			}
+			reader = gr
+		case ENCODING_ZSTD:
+			zr, err := zstd.NewReader(rec.Body)
+			if err != nil {
+				t.Fatalf("Failed to create zstd reader: %v", err)
+			}
+			defer zr.Close()
+			reader = zr
+		}
+
+		body, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("Failed to decompress %v: %v", encoding, err)
+		}
+		if string(body) != TEST_RESPONSE {
+			t.Errorf("Body mismatch for %v\nexpected: %v\ngot: %v", encoding, TEST_RESPONSE, string(body))
+		}
+	}
+}
+
+func TestCompressSkipsUnsupported(t *testing.T) {
+	req := httptest.NewRequest("POST", "/", nil)
+	rec := httptest.NewRecorder()
+
+	Compress(jsonHandler).ServeHTTP(rec, req)
+
+	if rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Expected no content encoding, got %v", rec.Header().Get("Content-Encoding"))
+	}
+	if rec.Body.String() != TEST_RESPONSE {
+		t.Errorf("Body mismatch, got %v", rec.Body.String())
+	}
+}
+
+func TestCompactEncoding(t *testing.T) {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{}`))
+	req.Header.Set("Accept", "application/cbor")
+	rec := httptest.NewRecorder()
+
+	CompactEncoding(jsonHandler).ServeHTTP(rec, req)
+
+	if rec.Header().Get("Content-Type") != CONTENT_TYPE_CBOR {
+		t.Fatalf("Expected content type %v, got %v", CONTENT_TYPE_CBOR, rec.Header().Get("Content-Type"))
+	}
+
+	var res struct {
+		Result struct {
+			BlockRange  []uint64 `cbor:"blockRange"`
+			GenesisHash string   `cbor:"genesisHash"`
+		} `cbor:"result"`
+	}
+	if err := cbor.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("Failed to decode cbor: %v", err)
+	}
+
+	if res.Result.BlockRange[0] != 327347682 || res.Result.BlockRange[1] != 18446744073709551615 {
+		t.Errorf("Unexpected block range: %v", res.Result.BlockRange)
+	}
+	if res.Result.GenesisHash != "mainnet" {
+		t.Errorf("Unexpected genesis hash: %v", res.Result.GenesisHash)
+	}
+	if rec.Body.Len() >= len(TEST_RESPONSE) {
+		t.Errorf("Expected cbor to be smaller than json, got %v >= %v", rec.Body.Len(), len(TEST_RESPONSE))
+	}
+}
+
+func TestCompactEncodingStream(t *testing.T) {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{}`))
+	req.Header.Set("Accept", "application/cbor")
+	rec := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":[{"slot":327347682,"fee":0.5},`))
+		w.(http.Flusher).Flush()
+
+		// Everything written before flushing is sent
+		if rec.Body.Len() == 0 {
+			t.Error("Expected the transcoded response to be flushed")
+		}
+		w.Write([]byte(`{"slot":327347683,"hash":"abc"`))
+		w.Write([]byte(`,"ok":true,"err":null}]}`))
+	})
+
+	CompactEncoding(handler).ServeHTTP(rec, req)
+
+	if !rec.Flushed {
+		t.Error("Expected flushing to reach the underlying writer")
+	}
+
+	dec, err := cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]interface{}{})}.DecMode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res map[string]interface{}
+	if err := dec.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("Failed to decode cbor: %v", err)
+	}
+	encoded, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"id":1,"jsonrpc":"2.0","result":[{"fee":0.5,"slot":327347682},{"err":null,"hash":"abc","ok":true,"slot":327347683}]}`
+	if string(encoded) != expected {
+		t.Errorf("Expected %v, got %v", expected, string(encoded))
+	}
+}
+
+func TestCompactEncodingError(t *testing.T) {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{}`))
+	req.Header.Set("Accept", "application/cbor")
+	rec := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(`{"error":"too large"}`))
+	})
+
+	CompactEncoding(handler).ServeHTTP(rec, req)
+
+	if rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected errors not to be transcoded, got %v", rec.Header().Get("Content-Type"))
+	}
+	if rec.Body.String() != `{"error":"too large"}` {
+		t.Errorf("Body mismatch, got %v", rec.Body.String())
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Serve runs srv until ctx is cancelled, then stops accepting connections and waits up to shutdownTimeout for in-flight requests to complete.
+// Requests still running after the timeout have their contexts cancelled, which cancels any portal queries
+func Serve(ctx context.Context, srv *http.Server, shutdownTimeout time.Duration) error {
+	// Request contexts outlive ctx so in-flight requests can complete while draining
+	baseCtx, cancelRequests := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelRequests()
+	srv.BaseContext = func(net.Listener) context.Context { return baseCtx }
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down, draining in-flight requests", "timeout", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := srv.Shutdown(shutdownCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		slog.Warn("Shutdown timeout reached, cancelling in-flight requests")
+		cancelRequests()
+		err = srv.Close()
+	}
+
+	if serveErr := <-serveErr; !errors.Is(serveErr, http.ErrServerClosed) {
+		return serveErr
+	}
+	return err
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// freeAddr returns a local address that is free to listen on
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// startServe runs Serve with the handler and waits for it to accept connections
+func startServe(t *testing.T, ctx context.Context, handler http.Handler, timeout time.Duration) (string, <-chan error) {
+	addr := freeAddr(t)
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(ctx, &http.Server{Addr: addr, Handler: handler}, timeout)
+	}()
+
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return addr, done
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Server didn't start")
+	return "", nil
+}
+
+func TestServeDrain(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	addr, done := startServe(t, ctx, handler, 5*time.Second)
+
+	resErr := make(chan error, 1)
+	var body []byte
+	go func() {
+		res, err := http.Get("http://" + addr)
+		if err == nil {
+			body, err = io.ReadAll(res.Body)
+			res.Body.Close()
+		}
+		resErr <- err
+	}()
+
+	<-started
+	cancel()
+
+	// New connections are refused while draining
+	time.Sleep(50 * time.Millisecond)
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Errorf("Expected new connections to be refused while draining")
+	}
+
+	// The in-flight request completes
+	close(release)
+	if err := <-resErr; err != nil {
+		t.Fatalf("In-flight request failed: %v", err)
+	}
+	if string(body) != "done" {
+		t.Errorf("Unexpected response %q", body)
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected serve error: %v", err)
+	}
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+		close(cancelled)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	addr, done := startServe(t, ctx, handler, 50*time.Millisecond)
+
+	go http.Get("http://" + addr)
+	<-started
+	cancel()
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the request context to be cancelled after the shutdown timeout")
+	}
+	<-done
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Websocket routes websocket upgrade requests to the ws handler and all other requests to the http handler
+func Websocket(httpHandler, wsHandler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isWebsocket(r) {
+			// Connections keep the server read and write deadlines after being upgraded, websockets are long lived and manage their own deadlines
+			rc := http.NewResponseController(w)
+			rc.SetReadDeadline(time.Time{})
+			rc.SetWriteDeadline(time.Time{})
+			wsHandler.ServeHTTP(w, r)
+			return
+		}
+		httpHandler.ServeHTTP(w, r)
+	})
+}
+
+func isWebsocket(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+		strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
+}
@@ -1,5 +1,14 @@
 package solana
 
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	solanaGo "github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
 /**
  * These types are mostly sourced from github.com/gagliardetto/solana-go
  * But with less parsing and minor variations to align directly with RPC types
@@ -48,9 +57,109 @@ type Transaction struct {
 	// The transaction data when the encoding is `json`
 	Transaction *JSONTransaction `json:"transaction"`
 
+	// The transaction data when the encoding is not `json`, this replaces Transaction when serialized.
+	// Either a ParsedTransaction for `jsonParsed` or a BinaryTransaction for `base64`
+	Encoded interface{} `json:"-"`
+
 	// Transaction status metadata object
 	Meta *TransactionMeta `json:"meta,omitempty"`
-	// Version TransactionVersion `json:"version"`
+
+	// The transaction version, "legacy" or a number
+	Version rpc.TransactionVersion `json:"version"`
+}
+
+func (t Transaction) MarshalJSON() ([]byte, error) {
+	type Alias Transaction
+	if t.Encoded == nil {
+		return json.Marshal(Alias(t))
+	}
+
+	return json.Marshal(struct {
+		Alias
+		Transaction interface{} `json:"transaction"`
+	}{
+		Alias(t),
+		t.Encoded,
+	})
+}
+
+// BinaryTransaction is the wire format of a transaction as a tuple of [data, encoding]
+type BinaryTransaction [2]string
+
+type ParsedTransaction struct {
+	Message ParsedMessage `json:"message"`
+
+	Signatures []string `json:"signatures"`
+}
+
+type ParsedMessage struct {
+	// All account keys used by the transaction including loaded addresses.
+	AccountKeys []ParsedAccountKey `json:"accountKeys"`
+
+	// A base-58 encoded hash of a recent block in the ledger.
+	RecentBlockhash string `json:"recentBlockhash"`
+
+	// List of program instructions, parsed if the program is supported.
+	Instructions []ParsedInstruction `json:"instructions"`
+
+	// List of address table lookups used to load additional accounts for this transaction.
+	AddressTableLookups []MessageAddressTableLookup `json:"addressTableLookups,omitempty"`
+}
+
+type ParsedAccountKey struct {
+	Pubkey   string `json:"pubkey"`
+	Signer   bool   `json:"signer"`
+	Writable bool   `json:"writable"`
+
+	// Where the account key comes from, "transaction" or "lookupTable"
+	Source string `json:"source"`
+}
+
+// ParsedInstruction is either a parsed instruction, with Program and Parsed set,
+// or a partially decoded instruction with Accounts and Data set
+type ParsedInstruction struct {
+	Program   string                 `json:"program,omitempty"`
+	ProgramId string                 `json:"programId"`
+	Parsed    *ParsedInstructionInfo `json:"parsed,omitempty"`
+
+	Accounts []string `json:"accounts"`
+	Data     string   `json:"data"`
+
+	// The decoded instruction if the program has a registered IDL.
+	Decoded *DecodedInstruction `json:"decoded,omitempty"`
+
+	StackHeight *uint16 `json:"stackHeight"`
+}
+
+// MarshalJSON encodes only the fields of the instruction's shape like the RPC, partially decoded instructions always include accounts and data
+func (i ParsedInstruction) MarshalJSON() ([]byte, error) {
+	if i.Parsed != nil {
+		return json.Marshal(struct {
+			Program     string                 `json:"program"`
+			ProgramId   string                 `json:"programId"`
+			Parsed      *ParsedInstructionInfo `json:"parsed"`
+			Decoded     *DecodedInstruction    `json:"decoded,omitempty"`
+			StackHeight *uint16                `json:"stackHeight"`
+		}{i.Program, i.ProgramId, i.Parsed, i.Decoded, i.StackHeight})
+	}
+
+	accounts := i.Accounts
+	if accounts == nil {
+		accounts = []string{}
+	}
+	return json.Marshal(struct {
+		ProgramId   string              `json:"programId"`
+		Accounts    []string            `json:"accounts"`
+		Data        string              `json:"data"`
+		Decoded     *DecodedInstruction `json:"decoded,omitempty"`
+		StackHeight *uint16             `json:"stackHeight"`
+	}{i.ProgramId, accounts, i.Data, i.Decoded, i.StackHeight})
+}
+
+// ParsedInnerInstruction is an inner instruction with the jsonParsed encoding
+type ParsedInnerInstruction struct {
+	Index        uint64              `json:"index"`
+	Instructions []ParsedInstruction `json:"instructions"`
 }
 
 type JSONTransaction struct {
@@ -104,9 +213,10 @@ type MessageHeader struct {
 }
 
 type MessageAddressTableLookup struct {
-	AccountKey      string  `json:"accountKey"` // The account key of the address table.
-	WritableIndexes []uint8 `json:"writableIndexes"`
-	ReadonlyIndexes []uint8 `json:"readonlyIndexes"`
+	AccountKey string `json:"accountKey"` // The account key of the address table.
+	// Encoded as arrays of numbers like the RPC rather than base64
+	WritableIndexes solanaGo.Uint8SliceAsNum `json:"writableIndexes"`
+	ReadonlyIndexes solanaGo.Uint8SliceAsNum `json:"readonlyIndexes"`
 }
 
 type TransactionMeta struct {
@@ -150,6 +260,24 @@ type TransactionMeta struct {
 	ReturnData *ReturnData `json:"returnData,omitempty"`
 
 	ComputeUnitsConsumed *uint64 `json:"computeUnitsConsumed"`
+
+	// Inner instructions in a different encoding, these replace InnerInstructions when serialized
+	EncodedInnerInstructions interface{} `json:"-"`
+}
+
+func (m TransactionMeta) MarshalJSON() ([]byte, error) {
+	type Alias TransactionMeta
+	if m.EncodedInnerInstructions == nil {
+		return json.Marshal(Alias(m))
+	}
+
+	return json.Marshal(struct {
+		Alias
+		InnerInstructions interface{} `json:"innerInstructions"`
+	}{
+		Alias(m),
+		m.EncodedInnerInstructions,
+	})
 }
 
 type Log struct {
@@ -157,6 +285,9 @@ type Log struct {
 	ProgramId string `json:"programId"`
 	LogIndex  uint64 `json:"logIndex"`
 	Kind      string `json:"kind"` // 'log' | 'data' | 'other'
+
+	// The decoded event if the log is a `data` log from a program with a registered IDL.
+	Decoded *DecodedEvent `json:"decoded,omitempty"`
 }
 
 type BlockReward struct {
@@ -207,6 +338,42 @@ type CompiledInstruction struct {
 
 	// The program input data encoded in a base-58 string.
 	Data string `json:"data"`
+
+	// The invocation depth of the instruction, only set for inner instructions.
+	StackHeight *uint16 `json:"stackHeight,omitempty"`
+
+	// The decoded instruction if the program has a registered IDL.
+	Decoded *DecodedInstruction `json:"decoded,omitempty"`
+
+	// The program name if the instruction belongs to a builtin program that can be parsed.
+	Program string `json:"program,omitempty"`
+
+	// The parsed instruction if the program is a builtin program, matches the RPC `jsonParsed` encoding.
+	Parsed *ParsedInstructionInfo `json:"parsed,omitempty"`
+}
+
+type ParsedInstructionInfo struct {
+	// The instruction type, e.g. "transfer".
+	Type string `json:"type"`
+
+	// The instruction accounts and arguments.
+	Info map[string]interface{} `json:"info"`
+}
+
+type DecodedInstruction struct {
+	// The instruction name as defined in the program IDL.
+	Name string `json:"name"`
+
+	// The instruction arguments keyed by their IDL names.
+	Args map[string]interface{} `json:"args"`
+}
+
+type DecodedEvent struct {
+	// The event name as defined in the program IDL.
+	Name string `json:"name"`
+
+	// The event fields keyed by their IDL names.
+	Data map[string]interface{} `json:"data"`
 }
 
 type TokenBalance struct {
@@ -238,6 +405,21 @@ type UiTokenAmount struct {
 	UiAmountString string `json:"uiAmountString"`
 }
 
+// ShiftDecimals moves the decimal point of a raw token amount left by decimals places and trims trailing zeros like the RPC.
+// The string is shifted rather than a float so large amounts don't lose precision
+func ShiftDecimals(amount string, decimals uint8) string {
+	if decimals == 0 {
+		return amount
+	}
+	padded := amount
+	if len(padded) <= int(decimals) {
+		padded = strings.Repeat("0", int(decimals)-len(padded)+1) + padded
+	}
+	split := len(padded) - int(decimals)
+	shifted := strings.TrimRight(padded[:split]+"."+padded[split:], "0")
+	return strings.TrimSuffix(shifted, ".")
+}
+
 type LoadedAddresses struct {
 	Readonly []string `json:"readonly"`
 	Writable []string `json:"writable"`
@@ -247,3 +429,29 @@ type ReturnData struct {
 	ProgramId string `json:"programId"`
 	Data      string `json:"data"`
 }
+
+// AccountKey resolves an account index the same way the runtime does,
+// static account keys first followed by the writable then readonly loaded addresses.
+// This is the inverse of the lookup used when compiling instructions from SQD data.
+func (t *Transaction) AccountKey(idx uint16) (string, error) {
+	i := int(idx)
+	if t.Transaction != nil {
+		if i < len(t.Transaction.Message.AccountKeys) {
+			return t.Transaction.Message.AccountKeys[i], nil
+		}
+		i -= len(t.Transaction.Message.AccountKeys)
+	}
+
+	if t.Meta != nil {
+		if i < len(t.Meta.LoadedAddresses.Writable) {
+			return t.Meta.LoadedAddresses.Writable[i], nil
+		}
+		i -= len(t.Meta.LoadedAddresses.Writable)
+
+		if i < len(t.Meta.LoadedAddresses.Readonly) {
+			return t.Meta.LoadedAddresses.Readonly[i], nil
+		}
+	}
+
+	return "", fmt.Errorf("Account index out of range: %v", idx)
+}
@@ -0,0 +1,92 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const SERVICE_NAME = "takoyaki"
+
+const TRACER_NAME = "github.com/subquery/solana-takoyaki"
+
+func init() {
+	// Trace context is always propagated so traces from clients continue through the proxy even when spans aren't exported here
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// The path spans are posted to on an OTLP/HTTP collector
+const TRACES_PATH = "/v1/traces"
+
+// Enabled returns whether spans should be exported, either to endpoint or the collector set by the standard OTLP environment variables
+func Enabled(endpoint string) bool {
+	return endpoint != "" || os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// Setup exports spans to an OTLP/HTTP collector, endpoint is the base url of the collector e.g. http://localhost:4318.
+// If endpoint is empty the collector is set by OTEL_EXPORTER_OTLP_ENDPOINT, headers, compression, TLS and timeouts are configured by the other OTEL_EXPORTER_OTLP_* variables.
+// The returned function flushes any remaining spans and should be called on shutdown
+func Setup(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	opts := []otlptracehttp.Option{}
+	if endpoint != "" {
+		tracesUrl, err := url.JoinPath(endpoint, TRACES_PATH)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, otlptracehttp.WithEndpointURL(tracesUrl))
+	}
+
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(SERVICE_NAME)))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Start starts a span using the global tracer provider, spans are no-ops unless Setup has been called
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(TRACER_NAME).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends a span recording the error if there is one, use with defer and a named error result
+func End(span trace.Span, err *error) {
+	if err != nil && *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
+
+// Handler extracts the trace context of incoming requests and starts a server span for each request
+func Handler(h http.Handler) http.Handler {
+	return otelhttp.NewHandler(h, "rpc")
+}
+
+// Transport starts a client span for each outgoing request and injects the trace context into the request headers
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base)
+}
@@ -0,0 +1,167 @@
+package tracing
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// collector is an in-process OTLP/HTTP collector that keeps the received spans and the headers of the last request
+type collector struct {
+	*httptest.Server
+	mu     sync.Mutex
+	spans  []*tracepb.Span
+	header http.Header
+}
+
+func newCollector(t *testing.T) *collector {
+	c := &collector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != TRACES_PATH || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("Unexpected export request %v %v", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("Failed to decompress export request: %v", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = zr
+		}
+		raw, _ := io.ReadAll(body)
+		exportReq := &coltracepb.ExportTraceServiceRequest{}
+		if err := proto.Unmarshal(raw, exportReq); err != nil {
+			t.Errorf("Failed to decode export request: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.header = r.Header
+		for _, rs := range exportReq.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				c.spans = append(c.spans, ss.Spans...)
+			}
+		}
+	}))
+	return c
+}
+
+func (c *collector) span(name string) *tracepb.Span {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, span := range c.spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func TestExport(t *testing.T) {
+	c := newCollector(t)
+	defer c.Close()
+
+	shutdown, err := Setup(context.Background(), c.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A portal that checks the trace context is propagated
+	portal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("traceparent") == "" {
+			t.Errorf("Expected traceparent header on portal request")
+		}
+	}))
+	defer portal.Close()
+
+	handler := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := Start(r.Context(), "FilterBlocks")
+		defer span.End()
+
+		req, _ := http.NewRequestWithContext(ctx, "GET", portal.URL, nil)
+		res, err := (&http.Client{Transport: Transport(http.DefaultTransport)}).Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		res.Body.Close()
+	}))
+
+	// The indexer's trace context
+	traceId := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("traceparent", "00-"+traceId+"-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	server := c.span("rpc")
+	filter := c.span("FilterBlocks")
+	if server == nil || filter == nil {
+		t.Fatalf("Expected rpc and FilterBlocks spans, got %v", c.spans)
+	}
+
+	if got := hex.EncodeToString(server.TraceId); got != traceId {
+		t.Errorf("Expected trace id %v, got %v", traceId, got)
+	}
+	if !bytes.Equal(filter.ParentSpanId, server.SpanId) {
+		t.Errorf("Expected FilterBlocks to be a child of the rpc span")
+	}
+
+	// The outgoing request is a child of FilterBlocks
+	found := false
+	for _, span := range c.spans {
+		if span.Kind == tracepb.Span_SPAN_KIND_CLIENT && bytes.Equal(span.ParentSpanId, filter.SpanId) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected a client span under FilterBlocks")
+	}
+}
+
+// The collector, headers and compression can be set with the standard environment variables
+func TestExportEnv(t *testing.T) {
+	c := newCollector(t)
+	defer c.Close()
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", c.URL)
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "authorization=Bearer secret")
+	t.Setenv("OTEL_EXPORTER_OTLP_COMPRESSION", "gzip")
+	if !Enabled("") {
+		t.Fatal("Expected tracing to be enabled by the environment")
+	}
+
+	shutdown, err := Setup(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := Start(context.Background(), "FilterBlocks")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.span("FilterBlocks") == nil {
+		t.Fatalf("Expected FilterBlocks span, got %v", c.spans)
+	}
+	if c.header.Get("Authorization") != "Bearer secret" || c.header.Get("Content-Encoding") != "gzip" {
+		t.Errorf("Unexpected export headers %v", c.header)
+	}
+}
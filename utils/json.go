@@ -1,10 +1,13 @@
 package utils
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"slices"
 	"strings"
+	"sync"
 )
 
 func parseTag(jsonTag string) (string, bool) {
@@ -18,27 +21,31 @@ func parseTag(jsonTag string) (string, bool) {
 	return parts[0], omitEmpty
 }
 
-// MarshalWithEmptySlices ensures nil slices are omitted while empty slices are serialized as [].
-func MarshalWithEmptySlices(v interface{}) ([]byte, error) {
-	val := reflect.ValueOf(v)
+// fieldEncoder is how a struct field is encoded, built once per struct type
+type fieldEncoder struct {
+	index     int
+	name      string
+	key       []byte // The quoted JSON name followed by a colon
+	omitEmpty bool
+}
 
-	// Ensure we're working with a struct pointer
-	if val.Kind() == reflect.Ptr {
-		val = val.Elem()
-	}
-	if val.Kind() != reflect.Struct {
-		return nil, fmt.Errorf("expected struct, got %T", v)
-	}
+// Struct types to their fields sorted by JSON name
+var structEncoders sync.Map
 
-	result := make(map[string]interface{})
+func structFields(t reflect.Type) []fieldEncoder {
+	if cached, ok := structEncoders.Load(t); ok {
+		return cached.([]fieldEncoder)
+	}
 
-	// Iterate through the struct fields
-	for i := 0; i < val.NumField(); i++ {
-		field := val.Field(i)
-		fieldType := val.Type().Field(i)
-		jsonTag := fieldType.Tag.Get("json")
+	fields := []fieldEncoder{}
+	for i := 0; i < t.NumField(); i++ {
+		fieldType := t.Field(i)
+		// Unexported fields can't be read
+		if !fieldType.IsExported() {
+			continue
+		}
 
-		jsonName, jsonOmit := parseTag(jsonTag)
+		jsonName, jsonOmit := parseTag(fieldType.Tag.Get("json"))
 
 		// Ignore fields with `-` JSON tags
 		if jsonName == "-" {
@@ -51,65 +58,107 @@ func MarshalWithEmptySlices(v interface{}) ([]byte, error) {
 			fieldName = jsonName
 		}
 
-		// Handle slice fields separately
-		if field.Kind() == reflect.Slice {
-			if field.IsNil() && jsonOmit {
-				continue // Omit nil slices
-			}
-			if field.Len() == 0 {
-				result[fieldName] = []interface{}{} // Ensure empty slices are `[]`
-				continue
-			}
-		}
+		key, _ := json.Marshal(fieldName)
+		fields = append(fields, fieldEncoder{i, fieldName, append(key, ':'), jsonOmit})
+	}
 
-		// Recursively process nested structs
-		if field.Kind() == reflect.Struct {
-			processedStruct, err := MarshalWithEmptySlices(field.Interface())
-			if err != nil {
-				return nil, err
-			}
-			var nestedMap map[string]interface{}
-			if err := json.Unmarshal(processedStruct, &nestedMap); err != nil {
-				return nil, err
-			}
-			result[fieldName] = nestedMap
-			continue
-		}
+	// Keys are sorted like an encoded map so the output is stable for use in cache keys
+	slices.SortStableFunc(fields, func(a, b fieldEncoder) int {
+		return strings.Compare(a.name, b.name)
+	})
 
-		// Process pointers to structs
-		if field.Kind() == reflect.Ptr && field.Elem().Kind() == reflect.Struct {
-			if field.IsNil() {
-				if jsonOmit {
-					continue // Omit nil struct pointers
-				}
-				result[fieldName] = nil
-			} else {
-				processedStruct, err := MarshalWithEmptySlices(field.Elem().Interface())
-				if err != nil {
-					return nil, err
+	structEncoders.Store(t, fields)
+	return fields
+}
+
+type encodeState struct {
+	bytes.Buffer
+	enc *json.Encoder
+}
+
+var encodeStatePool = sync.Pool{
+	New: func() any {
+		e := &encodeState{}
+		e.enc = json.NewEncoder(&e.Buffer)
+		return e
+	},
+}
+
+// MarshalWithEmptySlices ensures nil slices are omitted while empty slices are serialized as [].
+// Nested structs follow the same rules, all other values are encoded with encoding/json
+func MarshalWithEmptySlices(v interface{}) ([]byte, error) {
+	val := reflect.ValueOf(v)
+
+	// Ensure we're working with a struct pointer
+	if val.Kind() == reflect.Ptr {
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("expected struct, got %T", v)
+	}
+
+	e := encodeStatePool.Get().(*encodeState)
+	defer encodeStatePool.Put(e)
+	e.Reset()
+
+	if err := e.encodeStruct(val); err != nil {
+		return nil, err
+	}
+
+	return bytes.Clone(e.Bytes()), nil
+}
+
+func (e *encodeState) encodeStruct(val reflect.Value) error {
+	e.WriteByte('{')
+	first := true
+	for _, f := range structFields(val.Type()) {
+		field := val.Field(f.index)
+
+		if f.omitEmpty {
+			switch field.Kind() {
+			case reflect.Slice, reflect.Ptr:
+				if field.IsNil() {
+					continue // Omit nil slices and pointers
 				}
-				var nestedMap map[string]interface{}
-				if err := json.Unmarshal(processedStruct, &nestedMap); err != nil {
-					return nil, err
+			case reflect.Map:
+				if field.Len() == 0 {
+					continue // Omit empty maps
 				}
-				result[fieldName] = nestedMap
 			}
-			continue
 		}
 
-		// Process maps
-		if field.Kind() == reflect.Map && field.IsValid() && jsonOmit && field.Len() == 0 {
-			continue
+		if !first {
+			e.WriteByte(',')
 		}
+		first = false
+		e.Write(f.key)
 
-		// Process nil pointers
-		if field.Kind() == reflect.Ptr && field.IsNil() && jsonOmit {
-			continue
+		if err := e.encodeValue(field); err != nil {
+			return err
 		}
+	}
+	e.WriteByte('}')
+	return nil
+}
 
-		// Add non-slice fields as they are
-		result[fieldName] = field.Interface()
+func (e *encodeState) encodeValue(field reflect.Value) error {
+	switch {
+	// Ensure empty slices are `[]`
+	case field.Kind() == reflect.Slice && field.Len() == 0:
+		e.WriteString("[]")
+		return nil
+	// Recursively process nested structs
+	case field.Kind() == reflect.Struct:
+		return e.encodeStruct(field)
+	// Process pointers to structs
+	case field.Kind() == reflect.Ptr && !field.IsNil() && field.Elem().Kind() == reflect.Struct:
+		return e.encodeStruct(field.Elem())
 	}
 
-	return json.Marshal(result)
+	// Add other fields as they are, the encoder appends a newline that isn't wanted
+	if err := e.enc.Encode(field.Interface()); err != nil {
+		return err
+	}
+	e.Truncate(e.Len() - 1)
+	return nil
 }
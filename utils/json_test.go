@@ -1,8 +1,11 @@
 package utils
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -149,3 +152,185 @@ func jsonDeepEqual(a, b map[string]interface{}) bool {
 		return true
 	}()
 }
+
+// marshalWithEmptySlicesMaps is the original implementation that builds nested maps, the output must not change
+func marshalWithEmptySlicesMaps(v interface{}) ([]byte, error) {
+	val := reflect.ValueOf(v)
+	if val.Kind() == reflect.Ptr {
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("expected struct, got %T", v)
+	}
+
+	result := make(map[string]interface{})
+	for i := 0; i < val.NumField(); i++ {
+		field := val.Field(i)
+		fieldType := val.Type().Field(i)
+		jsonName, jsonOmit := parseTag(fieldType.Tag.Get("json"))
+		if jsonName == "-" {
+			continue
+		}
+		fieldName := fieldType.Name
+		if jsonName != "" {
+			fieldName = jsonName
+		}
+
+		if field.Kind() == reflect.Slice {
+			if field.IsNil() && jsonOmit {
+				continue
+			}
+			if field.Len() == 0 {
+				result[fieldName] = []interface{}{}
+				continue
+			}
+		}
+
+		nested := field
+		if field.Kind() == reflect.Ptr && field.Elem().Kind() == reflect.Struct {
+			nested = field.Elem()
+		}
+		if nested.Kind() == reflect.Struct {
+			processedStruct, err := marshalWithEmptySlicesMaps(nested.Interface())
+			if err != nil {
+				return nil, err
+			}
+			var nestedMap map[string]interface{}
+			if err := json.Unmarshal(processedStruct, &nestedMap); err != nil {
+				return nil, err
+			}
+			result[fieldName] = nestedMap
+			continue
+		}
+
+		if field.Kind() == reflect.Map && jsonOmit && field.Len() == 0 {
+			continue
+		}
+		if field.Kind() == reflect.Ptr && field.IsNil() && jsonOmit {
+			continue
+		}
+
+		result[fieldName] = field.Interface()
+	}
+
+	return json.Marshal(result)
+}
+
+type fuzzNested struct {
+	Name    string         `json:"name"`
+	Numbers []int32        `json:"numbers,omitempty"`
+	Records map[string]int `json:"records,omitempty"`
+	Flag    *bool          `json:"flag,omitempty"`
+}
+
+type fuzzRequest struct {
+	Type      string       `json:"type"`
+	FromBlock uint32       `json:"fromBlock"`
+	Include   *bool        `json:"includeAllBlocks,omitempty"`
+	Fields    fuzzNested   `json:"fields,omitempty"`
+	Parent    *fuzzNested  `json:"parent"`
+	Items     []fuzzNested `json:"items,omitempty"`
+	Strings   []string     `json:"strings"`
+	Map       map[string]string
+	Ignored   string `json:"-"`
+	Untagged  bool
+}
+
+// newFuzzRequest builds a request where each bit of shape chooses between nil, empty and populated values
+func newFuzzRequest(s string, n int32, shape uint16) fuzzRequest {
+	bit := func(i int) bool { return shape&(1<<i) != 0 }
+	flag := bit(0)
+
+	nested := func(offset int) fuzzNested {
+		out := fuzzNested{Name: s}
+		if bit(offset) {
+			out.Numbers = []int32{}
+			if bit(offset + 1) {
+				out.Numbers = append(out.Numbers, n, -n)
+			}
+		}
+		if bit(offset + 2) {
+			out.Records = map[string]int{s: int(n)}
+		} else if bit(offset + 1) {
+			out.Records = map[string]int{}
+		}
+		if bit(offset + 3) {
+			out.Flag = &flag
+		}
+		return out
+	}
+
+	req := fuzzRequest{
+		Type:      s,
+		FromBlock: uint32(n),
+		Fields:    nested(1),
+		Ignored:   s,
+		Untagged:  bit(5),
+	}
+	if bit(6) {
+		req.Include = &flag
+	}
+	if bit(7) {
+		parent := nested(8)
+		req.Parent = &parent
+	}
+	if bit(12) {
+		req.Items = []fuzzNested{}
+		if bit(13) {
+			req.Items = append(req.Items, nested(2))
+		}
+	}
+	if bit(14) {
+		req.Strings = strings.Split(s, ",")
+	}
+	if bit(15) {
+		req.Map = map[string]string{s: s}
+	}
+	return req
+}
+
+func FuzzMarshalWithEmptySlices(f *testing.F) {
+	f.Add("solana", int32(0), uint16(0))
+	f.Add("<a&b>,c", int32(-1), uint16(0xffff))
+	f.Add("", int32(1<<30), uint16(0x5555))
+	f.Add("\xff\"", int32(7), uint16(0xaaaa))
+
+	f.Fuzz(func(t *testing.T, s string, n int32, shape uint16) {
+		req := newFuzzRequest(s, n, shape)
+
+		expected, err := marshalWithEmptySlicesMaps(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := MarshalWithEmptySlices(&req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(expected, got) {
+			t.Errorf("Mismatch\nexpected: %s\ngot: %s", expected, got)
+		}
+	})
+}
+
+func BenchmarkMarshalWithEmptySlices(b *testing.B) {
+	req := newFuzzRequest("11111111111111111111111111111111,TokenkegQfeZyiNwAJbNbGKPFXCWuBvf9Ss623VQ5DA", 320_000_000, 0xffff)
+
+	b.Run("maps", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, err := marshalWithEmptySlicesMaps(req); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("encoder", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, err := MarshalWithEmptySlices(req); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,43 @@
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Version can be set at build time with -ldflags "-X github.com/subquery/solana-takoyaki/version.Version=v1.0.0", otherwise the module version is used
+var Version = ""
+
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Modified  bool   `json:"modified,omitempty"` // The build had uncommitted changes
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the version and the VCS info embedded by the go toolchain
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		GoVersion: runtime.Version(),
+	}
+
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+
+	if info.Version == "" {
+		info.Version = build.Main.Version
+	}
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Commit = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+
+	return info
+}